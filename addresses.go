@@ -0,0 +1,240 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	lookupAddr           = net.DefaultResolver.LookupAddr // replaced in tests
+	reverseLookupTimeout = 2 * time.Second
+	externalAddressTTL   = 5 * time.Minute
+	externalHTTPClient   = &http.Client{Timeout: 5 * time.Second}
+
+	externalAddressCache struct {
+		sync.Mutex
+		url, address string
+		at           time.Time
+	}
+)
+
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+type Address struct {
+	Interface    string
+	Family       string // inet or inet6
+	Address      string
+	PrefixLength int
+	Class        string // loopback, link-local, private, ula or public
+	Names        []string
+	Primary      bool
+}
+
+type Addresses struct {
+	Primary   string // the address traffic to the default gateway is sent from
+	Hostname  string
+	Resolved  []string // what the hostname resolves to, often only 127.0.1.1
+	External  string   // as seen by DASHBOARD_EXTERNAL_IP_URL, e.g. the address of a NAT gateway
+	Addresses []*Address
+}
+
+// classifyAddress tells where an address is reachable from. IPv4 shared address space (RFC 6598)
+// as used by carrier grade NAT is not reachable from the internet either and counts as private.
+func classifyAddress(ip net.IP) string {
+	switch {
+	case ip.IsLoopback():
+		return "loopback"
+	case ip.IsLinkLocalUnicast():
+		return "link-local"
+	case ip.To4() == nil && ip.IsPrivate():
+		return "ula"
+	case ip.IsPrivate() || cgnat.Contains(ip):
+		return "private"
+	}
+	return "public"
+}
+
+// interfaceAddresses lists the addresses of all network interfaces.
+func interfaceAddresses() ([]*Address, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*Address{}
+	for _, i := range interfaces {
+		addrs, err := i.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			length, _ := ipnet.Mask.Size()
+			address := &Address{
+				Interface:    i.Name,
+				Family:       "inet",
+				Address:      ipnet.IP.String(),
+				PrefixLength: length,
+				Class:        classifyAddress(ipnet.IP),
+				Names:        []string{},
+			}
+			if ipnet.IP.To4() == nil {
+				address.Family = "inet6"
+			}
+			result = append(result, address)
+		}
+	}
+	return result, nil
+}
+
+// primaryAddress picks the address traffic to the internet is sent from: the one on the interface of the default route
+// with the lowest metric, preferring IPv4 and an address in the same network as the gateway.
+// Without a default route the first public, or else the first private address is taken.
+func primaryAddress(addresses []*Address, routes []*Route) *Address {
+	gateways := defaultGateways(routes)
+	sort.SliceStable(gateways, func(i, j int) bool {
+		if gateways[i].Family != gateways[j].Family {
+			return gateways[i].Family == "inet"
+		}
+		return gateways[i].Metric < gateways[j].Metric
+	})
+
+	for _, gateway := range gateways {
+		var candidate *Address
+		for _, address := range addresses {
+			if address.Interface != gateway.Interface || address.Family != gateway.Family ||
+				address.Class == "loopback" || address.Class == "link-local" {
+				continue
+			}
+			bits := 8 * net.IPv6len
+			if address.Family == "inet" {
+				bits = 8 * net.IPv4len
+			}
+			network := &net.IPNet{IP: net.ParseIP(address.Address), Mask: net.CIDRMask(address.PrefixLength, bits)}
+			if network.Contains(net.ParseIP(gateway.Gateway)) {
+				return address
+			}
+			if candidate == nil {
+				candidate = address
+			}
+		}
+		if candidate != nil {
+			return candidate
+		}
+	}
+
+	for _, class := range []string{"public", "private", "ula"} {
+		for _, address := range addresses {
+			if address.Class == class {
+				return address
+			}
+		}
+	}
+	return nil
+}
+
+// reverseLookup resolves the names of all but link-local addresses concurrently.
+// Addresses without PTR records are common, lookup failures are not reported.
+func reverseLookup(addresses []*Address) {
+	ctx, cancel := context.WithTimeout(context.Background(), reverseLookupTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, address := range addresses {
+		if address.Class == "link-local" {
+			continue
+		}
+		wg.Add(1)
+		go func(address *Address) {
+			defer wg.Done()
+			names, err := lookupAddr(ctx, address.Address)
+			if err != nil {
+				return
+			}
+			for _, name := range names {
+				address.Names = append(address.Names, strings.TrimSuffix(name, "."))
+			}
+		}(address)
+	}
+	wg.Wait()
+}
+
+// externalAddress asks an echo service like https://api.ipify.org which address our requests come from.
+// The answer is cached, to not ask on every page load.
+func externalAddress(url string) (string, error) {
+	externalAddressCache.Lock()
+	defer externalAddressCache.Unlock()
+	if externalAddressCache.url == url && time.Since(externalAddressCache.at) < externalAddressTTL {
+		return externalAddressCache.address, nil
+	}
+
+	response, err := externalHTTPClient.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned status %d", url, response.StatusCode)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return "", fmt.Errorf("%s returned no IP address", url)
+	}
+
+	externalAddressCache.url, externalAddressCache.address, externalAddressCache.at = url, ip.String(), time.Now()
+	return ip.String(), nil
+}
+
+func addresses(hostname string) (*Addresses, error) {
+	list, err := interfaceAddresses()
+	if err != nil {
+		return nil, err
+	}
+	data := &Addresses{Hostname: hostname, Resolved: []string{}, Addresses: list}
+
+	var routes []*Route
+	if content, err := ioutil.ReadFile(procDir + "/net/route"); err == nil {
+		parsed, _ := parseRoutes(string(content))
+		routes = append(routes, parsed...)
+	}
+	if content, err := ioutil.ReadFile(procDir + "/net/ipv6_route"); err == nil {
+		parsed, _ := parseIPv6Routes(string(content))
+		routes = append(routes, parsed...)
+	}
+	if primary := primaryAddress(list, routes); primary != nil {
+		primary.Primary = true
+		data.Primary = primary.Address
+	}
+
+	reverseLookup(list)
+	if resolved, err := ip(hostname); err == nil {
+		data.Resolved = resolved
+	}
+
+	if url := os.Getenv("DASHBOARD_EXTERNAL_IP_URL"); len(url) > 0 {
+		if data.External, err = externalAddress(url); err != nil {
+			return data, Warnings{fmt.Sprintf("external address: %v", err)}
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,132 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func Test_todoapp_addresses_classifyAddress(t *testing.T) {
+	for address, class := range map[string]string{
+		"127.0.1.1":      "loopback",
+		"::1":            "loopback",
+		"169.254.10.1":   "link-local",
+		"fe80::1":        "link-local",
+		"10.1.2.3":       "private",
+		"172.20.0.2":     "private",
+		"192.168.1.10":   "private",
+		"100.64.12.1":    "private",
+		"fd12:3456::1":   "ula",
+		"8.8.8.8":        "public",
+		"2a00:1450::200": "public",
+	} {
+		if result := classifyAddress(net.ParseIP(address)); result != class {
+			t.Errorf("Expected [%s] to be classified as [%s], but got [%s]", address, class, result)
+		}
+	}
+}
+
+func Test_todoapp_addresses_primaryAddress(t *testing.T) {
+	addresses := []*Address{
+		{Interface: "lo", Family: "inet", Address: "127.0.0.1", PrefixLength: 8, Class: "loopback"},
+		{Interface: "docker0", Family: "inet", Address: "172.17.0.1", PrefixLength: 16, Class: "private"},
+		{Interface: "eth0", Family: "inet6", Address: "fe80::1", PrefixLength: 64, Class: "link-local"},
+		{Interface: "eth0", Family: "inet6", Address: "2001:db8::10", PrefixLength: 64, Class: "public"},
+		{Interface: "eth0", Family: "inet", Address: "10.0.0.5", PrefixLength: 24, Class: "private"},
+		{Interface: "eth0", Family: "inet", Address: "192.168.1.5", PrefixLength: 24, Class: "private"},
+		{Interface: "eth1", Family: "inet", Address: "203.0.113.5", PrefixLength: 24, Class: "public"},
+	}
+	routes := []*Route{
+		{Family: "inet6", Destination: "::/0", Gateway: "fe80::ff", Interface: "eth0"},
+		{Family: "inet", Destination: "10.0.0.0/24", Interface: "eth0"},
+		{Family: "inet", Destination: "0.0.0.0/0", Gateway: "203.0.113.1", Interface: "eth1", Metric: 200},
+		{Family: "inet", Destination: "0.0.0.0/0", Gateway: "192.168.1.1", Interface: "eth0", Metric: 100},
+	}
+
+	// IPv4 first, the lowest metric, and the address in the network of the gateway
+	Expect(t, primaryAddress(addresses, routes).Address, "192.168.1.5")
+	Expect(t, primaryAddress(addresses, routes[:3]).Address, "203.0.113.5")
+	Expect(t, primaryAddress(addresses, routes[:2]).Address, "2001:db8::10")
+
+	// without default route, public addresses come first
+	Expect(t, primaryAddress(addresses, nil).Address, "2001:db8::10")
+	Expect(t, primaryAddress(addresses[:2], nil).Address, "172.17.0.1")
+	Expect(t, primaryAddress(addresses[:1], nil) == nil, true)
+}
+
+func Test_todoapp_addresses_reverseLookup(t *testing.T) {
+	original := lookupAddr
+	defer func() {
+		lookupAddr = original
+	}()
+	var looked []string
+	lookupAddr = func(ctx context.Context, address string) ([]string, error) {
+		looked = append(looked, address)
+		if address == "10.0.0.5" {
+			return []string{"web1.example.org.", "www.example.org."}, nil
+		}
+		return nil, errors.New("no PTR record")
+	}
+
+	addresses := []*Address{
+		{Address: "fe80::1", Class: "link-local", Names: []string{}},
+		{Address: "10.0.0.5", Class: "private", Names: []string{}},
+	}
+	reverseLookup(addresses)
+	Expect(t, looked, []string{"10.0.0.5"})
+	Expect(t, addresses[0].Names, []string{})
+	Expect(t, addresses[1].Names, []string{"web1.example.org", "www.example.org"})
+}
+
+func Test_todoapp_addresses_externalAddress(t *testing.T) {
+	var requests int
+	body := "203.0.113.7\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	address, err := externalAddress(server.URL)
+	Expect(t, err, nil)
+	Expect(t, address, "203.0.113.7")
+
+	// answers are cached
+	address, _ = externalAddress(server.URL)
+	Expect(t, address, "203.0.113.7")
+	Expect(t, requests, 1)
+
+	body = "<html>not an address</html>"
+	_, err = externalAddress(server.URL + "/other")
+	Expect(t, err.Error(), server.URL+"/other returned no IP address")
+}
+
+func Test_todoapp_api_GetAddresses(t *testing.T) {
+	os.Setenv("DASHBOARD_EXTERNAL_IP_URL", "http://127.0.0.1:1/")
+	defer os.Unsetenv("DASHBOARD_EXTERNAL_IP_URL")
+
+	var data Addresses
+	response := getV2(t, "/api/addresses", &data)
+	Expect(t, response.Code, http.StatusOK)
+	Contain(t, response.Header().Get("Warning"), "external address: ")
+	Expect(t, data.Hostname, currentHostname)
+
+	var loopback bool
+	for _, address := range data.Addresses {
+		if address.Address == "127.0.0.1" {
+			loopback = true
+			Expect(t, address.Class, "loopback")
+			Expect(t, address.Primary, false)
+		}
+	}
+	Expect(t, loopback, true)
+}
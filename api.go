@@ -0,0 +1,100 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+)
+
+var (
+	requestIDHeader = "X-Request-Id"
+	rxRequestID     = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+)
+
+// API error codes
+const (
+	ErrBadRequest       = "bad_request"
+	ErrUnauthorized     = "unauthorized"
+	ErrForbidden        = "forbidden"
+	ErrNotFound         = "not_found"
+	ErrUnknownCollector = "unknown_collector"
+	ErrCollectorFailed  = "collector_failed"
+	ErrNotAcceptable    = "not_acceptable"
+	ErrUpstreamFailed   = "upstream_failed"
+)
+
+// APIError is the body of every failed /api/* call.
+type APIError struct {
+	Code      string
+	Message   string
+	Collector string `json:",omitempty"`
+	RequestID string
+}
+
+// Warnings is returned as error by collectors that still produced partial data,
+// each entry describes something that had to be skipped.
+type Warnings []string
+
+func (w Warnings) Error() string {
+	return strings.Join(w, "; ")
+}
+
+// RequestID returns a middleware that tags every request and response with an X-Request-Id,
+// a sane id passed in by a client or proxy is kept so log lines can be correlated.
+func RequestID() martini.Handler {
+	return func(res http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(requestIDHeader)
+		if !rxRequestID.MatchString(id) {
+			data := make([]byte, 8)
+			rand.Read(data)
+			id = hex.EncodeToString(data)
+			req.Header.Set(requestIDHeader, id)
+		}
+		res.Header().Set(requestIDHeader, id)
+	}
+}
+
+func apiError(r render.Render, status int, code, collector string, err error) {
+	r.JSON(status, map[string]*APIError{
+		"Error": {
+			Code:      code,
+			Message:   err.Error(),
+			Collector: collector,
+			RequestID: r.Header().Get(requestIDHeader),
+		},
+	})
+}
+
+// writeWarnings adds partial result warnings as HTTP Warning headers, so the payload itself stays unchanged.
+func writeWarnings(r render.Render, warnings Warnings) {
+	for _, warning := range warnings {
+		r.Header().Add("Warning", "199 dashboard "+strconv.Quote(warning))
+	}
+}
+
+// collectorError splits a collector error into partial result warnings and a real failure.
+func collectorError(err error) (Warnings, error) {
+	if warnings, ok := err.(Warnings); ok {
+		return warnings, nil
+	}
+	return nil, err
+}
+
+func NotFoundHandler(r render.Render, req *http.Request) {
+	if isAPIPath(req.URL.Path) {
+		apiError(r, http.StatusNotFound, ErrNotFound, "", fmt.Errorf("no such endpoint [%s]", req.URL.Path))
+		return
+	}
+	r.HTML(http.StatusNotFound, "404", View("404 - Not Found"))
+}
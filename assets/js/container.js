@@ -0,0 +1,23 @@
+var container = angular.module('container', []);
+
+// shows a single Docker container, the id is the last part of the page path /containers/<id>
+container.controller('containerCtrl', ['$scope', '$http',
+    function($scope, $http) {
+
+        $scope.ID = decodeURIComponent(window.location.pathname.split('/').pop());
+
+        var failed = function(data, status) {
+            $scope.Error = (data && data.Error) ? data.Error.Message : 'request failed (' + status + ')';
+        };
+
+        $scope.LoadContainer = function() {
+            $scope.Error = null;
+            $http.get('/api/containers/' + encodeURIComponent($scope.ID)).success(function(data) {
+                $scope.Detail = data;
+                $scope.Container = data.Container;
+            }).error(failed);
+        };
+
+        $scope.LoadContainer();
+    }
+]);
@@ -5,6 +5,56 @@ var dashboard = angular.module('dashboard', [
 
 var dashboardControllers = angular.module('dashboardControllers', []);
 
+// collect API errors and partial result warnings, so they can be shown instead of silently empty panels
+dashboard.factory('apiAlerts', ['$q', '$rootScope',
+    function($q, $rootScope) {
+        $rootScope.Alerts = [];
+
+        var add = function(type, message) {
+            for (var i = 0; i < $rootScope.Alerts.length; i++) {
+                if ($rootScope.Alerts[i].Message == message) {
+                    return;
+                }
+            }
+            $rootScope.Alerts.push({
+                Type: type,
+                Message: message
+            });
+        };
+
+        $rootScope.CloseAlert = function(index) {
+            $rootScope.Alerts.splice(index, 1);
+        };
+
+        return {
+            response: function(response) {
+                var warnings = response.headers('Warning');
+                if (warnings) {
+                    var rx = /199 dashboard "((?:[^"\\]|\\.)*)"/g;
+                    var match;
+                    while ((match = rx.exec(warnings)) !== null) {
+                        add('warning', match[1].replace(/\\(.)/g, '$1'));
+                    }
+                }
+                return response;
+            },
+            responseError: function(response) {
+                if (response.data && response.data.Error && response.status != 401) {
+                    var error = response.data.Error;
+                    add('danger', error.Message + ' (request ' + error.RequestID + ')');
+                }
+                return $q.reject(response);
+            }
+        };
+    }
+]);
+
+dashboard.config(['$httpProvider',
+    function($httpProvider) {
+        $httpProvider.interceptors.push('apiAlerts');
+    }
+]);
+
 // main controller
 dashboardControllers.controller('dashboardCtrl', ['$scope', '$http', '$location', '$anchorScroll', '$sce',
     function($scope, $http, $location, $anchorScroll, $sce) {
@@ -18,6 +68,24 @@ dashboardControllers.controller('dashboardCtrl', ['$scope', '$http', '$location'
             $anchorScroll();
         }
 
+        $scope.Permissions = {
+            Collectors: {},
+            Actions: {}
+        };
+
+        $scope.Allowed = function(collector) {
+            return $scope.Permissions.Collectors[collector] === true;
+        };
+
+        $scope.LoadPermissions = function(callback) {
+            $http.get('/api/permissions').success(function(data) {
+                $scope.Permissions = data;
+                if (callback) {
+                    callback();
+                }
+            });
+        };
+
         $scope.LoadHostname = function(callback) {
             $http.get('/api/hostname').success(function(data) {
                 $scope.Hostname = data.Hostname;
@@ -29,10 +97,13 @@ dashboardControllers.controller('dashboardCtrl', ['$scope', '$http', '$location'
 
         $scope.LoadIP = function(callback) {
             $http.get('/api/ip').success(function(data) {
-                $scope.IP = "0.0.0.0";
-                for (var ip in data) {
-                    if (data[ip] != null && data[ip] != "") {
-                        $scope.IP = data[ip];
+                // the primary address of the addresses collector is preferred, the hostname often resolves to loopback only
+                if (!$scope.Addresses || !$scope.Addresses.Primary) {
+                    $scope.IP = "0.0.0.0";
+                    for (var ip in data) {
+                        if (data[ip] != null && data[ip] != "") {
+                            $scope.IP = data[ip];
+                        }
                     }
                 }
                 if (callback) {
@@ -41,6 +112,18 @@ dashboardControllers.controller('dashboardCtrl', ['$scope', '$http', '$location'
             });
         };
 
+        $scope.LoadAddresses = function(callback) {
+            $http.get('/api/addresses').success(function(data) {
+                $scope.Addresses = data;
+                if (data.Primary) {
+                    $scope.IP = data.Primary;
+                }
+                if (callback) {
+                    callback();
+                }
+            });
+        };
+
         $scope.LoadCPU = function(callback) {
             $http.get('/api/cpu').success(function(data) {
                 $scope.CPU = data;
@@ -50,6 +133,15 @@ dashboardControllers.controller('dashboardCtrl', ['$scope', '$http', '$location'
             });
         };
 
+        $scope.LoadCPUUsage = function(callback) {
+            $http.get('/api/cpu_usage').success(function(data) {
+                $scope.CPUUsage = data;
+                if (callback) {
+                    callback();
+                }
+            });
+        };
+
         $scope.LoadMemory = function(callback) {
             $http.get('/api/mem').success(function(data) {
                 $scope.Memory = data;
@@ -72,6 +164,25 @@ dashboardControllers.controller('dashboardCtrl', ['$scope', '$http', '$location'
             });
         };
 
+        $scope.LoadCgroup = function(callback) {
+            $http.get('/api/cgroup').success(function(data) {
+                $scope.Cgroup = data;
+                if (data.Memory) {
+                    data.Memory.Class = "progress-bar-success";
+                    if (data.Memory.UsagePercent > 85) {
+                        data.Memory.Class = "progress-bar-danger";
+                    } else if (data.Memory.UsagePercent > 65) {
+                        data.Memory.Class = "progress-bar-warning";
+                    } else if (data.Memory.UsagePercent > 45) {
+                        data.Memory.Class = "progress-bar-primary";
+                    }
+                }
+                if (callback) {
+                    callback();
+                }
+            });
+        };
+
         $scope.LoadDisk = function(callback) {
             $http.get('/api/disk').success(function(data) {
                 $scope.Disk = data;
@@ -110,6 +221,15 @@ dashboardControllers.controller('dashboardCtrl', ['$scope', '$http', '$location'
             });
         };
 
+        $scope.LoadDiskIO = function(callback) {
+            $http.get('/api/disk_io').success(function(data) {
+                $scope.DiskIO = data;
+                if (callback) {
+                    callback();
+                }
+            });
+        };
+
         $scope.LoadProcesses = function(callback) {
             $http.get('/api/processes').success(function(data) {
                 $scope.Processes = data;
@@ -121,6 +241,24 @@ dashboardControllers.controller('dashboardCtrl', ['$scope', '$http', '$location'
             });
         };
 
+        $scope.LoadRouting = function(callback) {
+            $http.get('/api/routing').success(function(data) {
+                $scope.Routing = data;
+                if (callback) {
+                    callback();
+                }
+            });
+        };
+
+        $scope.LoadSockets = function(callback) {
+            $http.get('/api/sockets').success(function(data) {
+                $scope.Sockets = data;
+                if (callback) {
+                    callback();
+                }
+            });
+        };
+
         $scope.LoadNetwork = function(callback) {
             $http.get('/api/network').success(function(data) {
                 $scope.Network = data;
@@ -130,8 +268,24 @@ dashboardControllers.controller('dashboardCtrl', ['$scope', '$http', '$location'
             });
         };
 
+        $scope.LoadNetworkTraffic = function(callback) {
+            $http.get('/api/network_traffic').success(function(data) {
+                $scope.NetworkTraffic = data;
+                if (callback) {
+                    callback();
+                }
+            });
+        };
+
+        $scope.RevealEnv = false;
+
+        $scope.ToggleRevealEnv = function() {
+            $scope.RevealEnv = !$scope.RevealEnv;
+            $scope.LoadEnv();
+        };
+
         $scope.LoadEnv = function(callback) {
-            $http.get('/api/env').success(function(data) {
+            $http.get('/api/env' + ($scope.RevealEnv ? '?reveal=true' : '')).success(function(data) {
                 $scope.Env = data;
                 if (callback) {
                     callback();
@@ -148,22 +302,97 @@ dashboardControllers.controller('dashboardCtrl', ['$scope', '$http', '$location'
             });
         };
 
+        $scope.LoadCgroups = function(callback) {
+            $http.get('/api/cgroups').success(function(data) {
+                $scope.Cgroups = data;
+                if (callback) {
+                    callback();
+                }
+            });
+        };
+
+        // CgroupProcesses limits the process table to the members of a cgroup, or shows all processes again.
+        $scope.CgroupProcesses = function(cgroup) {
+            $scope.ProcessCgroup = cgroup;
+        };
+
+        $scope.InProcessCgroup = function(process) {
+            return !$scope.ProcessCgroup || $scope.ProcessCgroup.Pids.indexOf(process.Pid) >= 0;
+        };
+
+        $scope.LoadContainers = function(callback) {
+            $http.get('/api/containers').success(function(data) {
+                $scope.Containers = data;
+                if (callback) {
+                    callback();
+                }
+            });
+        };
+
+        $scope.LoadImages = function(callback) {
+            $http.get('/api/images').success(function(data) {
+                $scope.Images = data;
+                if (callback) {
+                    callback();
+                }
+            });
+        };
+
+        $scope.LoadKubernetes = function(callback) {
+            $http.get('/api/kubernetes').success(function(data) {
+                $scope.Kubernetes = data;
+                if (callback) {
+                    callback();
+                }
+            });
+        };
+
+        $scope.LoadPods = function(callback) {
+            $http.get('/api/pods').success(function(data) {
+                $scope.Pods = data;
+                if (callback) {
+                    callback();
+                }
+            });
+        };
+
         $scope.LoadAllData = function(callback) {
-            $scope.LoadHostname();
-            $scope.LoadIP();
-            $scope.LoadCPU();
-            $scope.LoadMemory();
-            $scope.LoadDisk();
-            $scope.LoadUsers();
-            $scope.LoadLoggedOn();
-            $scope.LoadProcesses();
-            $scope.LoadNetwork();
-            $scope.LoadEnv();
-            $scope.LoadHeaders();
+            $scope.LoadPermissions(function() {
+                var loaders = {
+                    'hostname': $scope.LoadHostname,
+                    'ip': $scope.LoadIP,
+                    'addresses': $scope.LoadAddresses,
+                    'cpu': $scope.LoadCPU,
+                    'cpustat': $scope.LoadCPUUsage,
+                    'mem': $scope.LoadMemory,
+                    'cgroup': $scope.LoadCgroup,
+                    'disk': $scope.LoadDisk,
+                    'diskio': $scope.LoadDiskIO,
+                    'passwd': $scope.LoadUsers,
+                    'logged_on': $scope.LoadLoggedOn,
+                    'top': $scope.LoadProcesses,
+                    'cgroups': $scope.LoadCgroups,
+                    'docker': $scope.LoadContainers,
+                    'docker_images': $scope.LoadImages,
+                    'kubernetes': $scope.LoadKubernetes,
+                    'kubernetes_pods': $scope.LoadPods,
+                    'network': $scope.LoadNetwork,
+                    'netstat': $scope.LoadNetworkTraffic,
+                    'routing': $scope.LoadRouting,
+                    'sockets': $scope.LoadSockets,
+                    'env': $scope.LoadEnv,
+                    'headers': $scope.LoadHeaders
+                };
+                for (var collector in loaders) {
+                    if ($scope.Allowed(collector)) {
+                        loaders[collector]();
+                    }
+                }
 
-            if (callback) {
-                callback();
-            }
+                if (callback) {
+                    callback();
+                }
+            });
         };
 
         $scope.LoadAllData(function() {
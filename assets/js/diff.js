@@ -0,0 +1,66 @@
+var diff = angular.module('diff', []);
+
+// compares stored snapshots, the current state and fleet hosts through /api/diff
+diff.controller('diffCtrl', ['$scope', '$http',
+    function($scope, $http) {
+
+        $scope.Sources = [];
+        $scope.Sections = ['Processes', 'Users', 'Mounts', 'Interfaces', 'Env'];
+        $scope.Query = {
+            To: 'current',
+            Threshold: 0.1
+        };
+
+        var failed = function(data, status) {
+            $scope.Error = (data && data.Error) ? data.Error.Message : 'request failed (' + status + ')';
+        };
+
+        $scope.LoadSnapshots = function() {
+            $http.get('/api/snapshots').success(function(data) {
+                var sources = [{
+                    ID: 'current',
+                    Label: 'current'
+                }];
+                (data.Fleet || []).forEach(function(name) {
+                    sources.push({
+                        ID: 'host:' + name,
+                        Label: 'host ' + name
+                    });
+                });
+                (data.Snapshots || []).slice().reverse().forEach(function(snapshot) {
+                    sources.push({
+                        ID: snapshot.ID,
+                        Label: snapshot.Hostname + ' ' + snapshot.Taken
+                    });
+                });
+                $scope.Sources = sources;
+                if (!$scope.Query.From && sources.length > 1) {
+                    $scope.Query.From = sources[sources.length - 1].ID;
+                }
+            }).error(failed);
+        };
+
+        $scope.Compare = function() {
+            $scope.Error = null;
+            $http.get('/api/diff', {
+                params: {
+                    from: $scope.Query.From,
+                    to: $scope.Query.To,
+                    threshold: $scope.Query.Threshold
+                }
+            }).success(function(data) {
+                $scope.Diff = data;
+            }).error(failed);
+        };
+
+        $scope.Take = function() {
+            $scope.Error = null;
+            $http.post('/api/snapshots').success(function(snapshot) {
+                $scope.LoadSnapshots();
+                $scope.Query.From = snapshot.ID;
+            }).error(failed);
+        };
+
+        $scope.LoadSnapshots();
+    }
+]);
@@ -0,0 +1,92 @@
+var explorer = angular.module('explorer', []);
+
+// api explorer, renders /api/openapi.json and lets users try out every operation
+explorer.controller('explorerCtrl', ['$scope', '$http',
+    function($scope, $http) {
+
+        $scope.Operations = [];
+        $scope.Tags = [];
+
+        // resolve local $refs, so schemas can be shown in one piece
+        var resolve = function(schema, seen) {
+            if (angular.isArray(schema)) {
+                return schema.map(function(item) {
+                    return resolve(item, seen);
+                });
+            }
+            if (!angular.isObject(schema)) {
+                return schema;
+            }
+            if (schema.$ref) {
+                var name = schema.$ref.replace('#/components/schemas/', '');
+                if (seen.indexOf(name) >= 0) {
+                    return schema;
+                }
+                return resolve($scope.Spec.components.schemas[name], seen.concat([name]));
+            }
+            var resolved = {};
+            for (var key in schema) {
+                resolved[key] = resolve(schema[key], seen);
+            }
+            return resolved;
+        };
+
+        $scope.LoadSpec = function() {
+            $http.get('/api/openapi.json').success(function(spec) {
+                $scope.Spec = spec;
+                $scope.Operations = [];
+                for (var path in spec.paths) {
+                    var operation = spec.paths[path].get;
+                    $scope.Operations.push({
+                        Path: path,
+                        Tag: operation.tags[0],
+                        Summary: operation.summary,
+                        Description: operation.description,
+                        Parameters: operation.parameters || [],
+                        Schema: angular.toJson(resolve(operation.responses['200'].content['application/json'].schema, []), true),
+                        Values: {}
+                    });
+                }
+                $scope.Operations.sort(function(a, b) {
+                    return a.Path < b.Path ? -1 : 1;
+                });
+                $scope.Tags = spec.tags.map(function(tag) {
+                    return tag.name;
+                });
+            }).error(function(data, status) {
+                $scope.Error = (data && data.Error) ? data.Error.Message : 'could not load /api/openapi.json (' + status + ')';
+            });
+        };
+
+        $scope.URL = function(operation) {
+            var url = operation.Path;
+            var query = [];
+            operation.Parameters.forEach(function(parameter) {
+                var value = operation.Values[parameter.name];
+                if (parameter.in == 'path') {
+                    url = url.replace('{' + parameter.name + '}', encodeURIComponent(value || ''));
+                } else if (value) {
+                    query.push(encodeURIComponent(parameter.name) + '=' + encodeURIComponent(value));
+                }
+            });
+            return query.length > 0 ? url + '?' + query.join('&') : url;
+        };
+
+        $scope.Try = function(operation) {
+            var show = function(data, status, headers) {
+                operation.Response = {
+                    Status: status,
+                    RequestID: headers('X-Request-Id'),
+                    Warnings: headers('Warning'),
+                    Body: angular.isString(data) ? data : angular.toJson(data, true)
+                };
+            };
+            operation.Response = {
+                Status: '...'
+            };
+            $http.get($scope.URL(operation)).success(show).error(show);
+        };
+
+        $scope.LoadSpec();
+    }
+]);
@@ -0,0 +1,460 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	sessionCookieName = "dashboard_session"
+	sessionDefaultTTL = 12 * time.Hour
+
+	errInvalidCredentials = errors.New("invalid credentials")
+	errInvalidSession     = errors.New("invalid or expired session")
+
+	// anonymous is used for every request while authentication is disabled
+	anonymous = &Identity{Name: "anonymous", Method: "none", Role: RoleAdmin}
+	// unauthenticated is used for public pages like /login while authentication is enabled
+	unauthenticated = &Identity{Name: "anonymous", Method: "none", Role: RoleNone}
+)
+
+// Identity is the authenticated user or API client of a request.
+// It is mapped into the martini context by the Authentication middleware.
+type Identity struct {
+	Name   string
+	Method string
+	Role   Role
+}
+
+// Authenticator checks a request for one kind of credentials.
+// It returns nil, nil if the request does not carry any credentials of that kind,
+// and an error if it does carry them but they are not valid.
+type Authenticator interface {
+	Authenticate(req *http.Request) (*Identity, error)
+}
+
+type auth struct {
+	roles          *roles
+	users          *htpasswd
+	oidc           *oidcProvider
+	sessions       *sessions
+	authenticators []Authenticator
+}
+
+func (a *auth) enabled() bool {
+	return len(a.authenticators) > 0
+}
+
+func (a *auth) use(authenticator Authenticator) {
+	a.authenticators = append(a.authenticators, authenticator)
+}
+
+// setupAuth configures authentication from the environment:
+//
+//	DASHBOARD_HTPASSWD        htpasswd file with bcrypt hashed users
+//	DASHBOARD_TOKENS          file with "name:token" lines for static API tokens
+//	DASHBOARD_SESSION_SECRET  key used to sign login session and OpenID Connect state cookies (random if unset)
+//	DASHBOARD_SESSION_TTL     lifetime of a login session, e.g. "8h" (default 12h)
+//
+// Authentication stays disabled if neither users, tokens, OpenID Connect nor client certificates are configured.
+func setupAuth(roles *roles) *auth {
+	a := &auth{roles: roles}
+
+	secret := []byte(os.Getenv("DASHBOARD_SESSION_SECRET"))
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("Could not generate session secret: %v", err)
+		}
+	}
+	ttl := sessionDefaultTTL
+	if value := os.Getenv("DASHBOARD_SESSION_TTL"); len(value) > 0 {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid DASHBOARD_SESSION_TTL [%s]: %v", value, err)
+		}
+		ttl = duration
+	}
+	a.sessions = newSessions(secret, ttl)
+
+	if certs := setupCertAuth(roles); certs != nil {
+		a.use(certs)
+	}
+
+	if file := os.Getenv("DASHBOARD_HTPASSWD"); len(file) > 0 {
+		users, err := loadHtpasswd(file)
+		if err != nil {
+			log.Fatalf("Could not load htpasswd file [%s]: %v", file, err)
+		}
+		a.users = users
+		a.use(&basicAuth{users})
+	}
+
+	if file := os.Getenv("DASHBOARD_TOKENS"); len(file) > 0 {
+		tokens, err := loadTokens(file)
+		if err != nil {
+			log.Fatalf("Could not load tokens file [%s]: %v", file, err)
+		}
+		a.use(tokens)
+	}
+
+	a.oidc = setupOIDC()
+
+	// explicit credentials take precedence over a possibly stale session cookie
+	if a.users != nil || a.oidc != nil {
+		a.use(a.sessions)
+	}
+
+	return a
+}
+
+// Authentication returns a middleware that requires every non-public request to be authenticated.
+// API requests are answered with 401, browsers are redirected to the login page.
+func Authentication(a *auth) martini.Handler {
+	return func(c martini.Context, res http.ResponseWriter, req *http.Request, r render.Render) {
+		if !a.enabled() {
+			c.Map(anonymous)
+			return
+		}
+		if isPublicPath(req.URL.Path) {
+			c.Map(unauthenticated)
+			return
+		}
+
+		for _, authenticator := range a.authenticators {
+			identity, err := authenticator.Authenticate(req)
+			if err != nil {
+				unauthorized(a, res, req, r, err)
+				return
+			}
+			if identity != nil {
+				if identity.Role == RoleNone {
+					identity.Role = a.roles.For(identity.Name)
+				}
+				c.Map(identity)
+				return
+			}
+		}
+		unauthorized(a, res, req, r, errors.New("authentication required"))
+	}
+}
+
+func isPublicPath(path string) bool {
+	switch path {
+	case "/login", "/logout", "/login/oidc", "/login/oidc/callback":
+		return true
+	}
+	return false
+}
+
+func isAPIPath(path string) bool {
+	return strings.HasPrefix(path, "/api/")
+}
+
+func unauthorized(a *auth, res http.ResponseWriter, req *http.Request, r render.Render, err error) {
+	if isAPIPath(req.URL.Path) {
+		if a.users != nil {
+			res.Header().Set("WWW-Authenticate", `Basic realm="dashboard"`)
+		}
+		apiError(r, http.StatusUnauthorized, ErrUnauthorized, "", err)
+		return
+	}
+	r.Redirect("/login?next=" + url.QueryEscape(req.URL.RequestURI()))
+}
+
+type loginView struct {
+	Title    string
+	Error    error
+	Next     string
+	Password bool
+	SSO      bool
+}
+
+func (a *auth) loginView(next string, err error) *loginView {
+	return &loginView{
+		Title:    "Login",
+		Error:    err,
+		Next:     next,
+		Password: a.users != nil,
+		SSO:      a.oidc != nil,
+	}
+}
+
+func LoginHandler(a *auth, r render.Render, req *http.Request) {
+	if !a.enabled() {
+		r.Redirect("/")
+		return
+	}
+	r.HTML(http.StatusOK, "login", a.loginView(safeRedirect(req.URL.Query().Get("next")), nil), render.HTMLOptions{})
+}
+
+func LoginFormHandler(a *auth, res http.ResponseWriter, req *http.Request, r render.Render) {
+	next := safeRedirect(req.FormValue("next"))
+	username := req.FormValue("username")
+
+	if a.users == nil || !a.users.Verify(username, req.FormValue("password")) {
+		r.HTML(http.StatusUnauthorized, "login", a.loginView(next, errors.New("invalid username or password")), render.HTMLOptions{})
+		return
+	}
+
+	a.sessions.Issue(res, req, &Identity{Name: username, Method: "session", Role: a.roles.For(username)})
+	r.Redirect(next)
+}
+
+func LogoutHandler(a *auth, res http.ResponseWriter, req *http.Request, r render.Render) {
+	a.sessions.Clear(res, req)
+	r.Redirect("/login")
+}
+
+// safeRedirect only allows local paths as redirect targets after login.
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+type htpasswd struct {
+	hashes map[string][]byte
+}
+
+// loadHtpasswd reads an htpasswd file as written by "htpasswd -B".
+// Only bcrypt hashes are supported, other entries are skipped.
+func loadHtpasswd(file string) (*htpasswd, error) {
+	users := &htpasswd{hashes: make(map[string][]byte)}
+	err := readLines(file, func(line string) error {
+		values := strings.SplitN(line, ":", 2)
+		if len(values) != 2 {
+			return errors.New("malformed line, expected user:hash")
+		}
+		if _, err := bcrypt.Cost([]byte(values[1])); err != nil {
+			log.Printf("Skipping user [%s], only bcrypt hashes are supported\n", values[0])
+			return nil
+		}
+		users.hashes[values[0]] = []byte(values[1])
+		return nil
+	})
+	return users, err
+}
+
+func (h *htpasswd) Verify(username, password string) bool {
+	hash, ok := h.hashes[username]
+	if !ok {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+type basicAuth struct {
+	users *htpasswd
+}
+
+func (b *basicAuth) Authenticate(req *http.Request) (*Identity, error) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	if !b.users.Verify(username, password) {
+		return nil, errInvalidCredentials
+	}
+	return &Identity{Name: username, Method: "basic"}, nil
+}
+
+type tokenAuth struct {
+	names  []string
+	tokens [][]byte
+}
+
+// loadTokens reads a file of "name:token" lines, the name identifies the API client.
+func loadTokens(file string) (*tokenAuth, error) {
+	tokens := &tokenAuth{}
+	err := readLines(file, func(line string) error {
+		values := strings.SplitN(line, ":", 2)
+		if len(values) != 2 || len(trim(values[1])) == 0 {
+			return errors.New("malformed line, expected name:token")
+		}
+		tokens.names = append(tokens.names, values[0])
+		tokens.tokens = append(tokens.tokens, []byte(trim(values[1])))
+		return nil
+	})
+	return tokens, err
+}
+
+func (t *tokenAuth) Authenticate(req *http.Request) (*Identity, error) {
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, nil
+	}
+	token := []byte(trim(strings.TrimPrefix(header, "Bearer ")))
+
+	// compare against every token so the response time doesn't reveal a match
+	name := ""
+	for i := range t.tokens {
+		if subtle.ConstantTimeCompare(t.tokens[i], token) == 1 {
+			name = t.names[i]
+		}
+	}
+	if len(name) == 0 {
+		return nil, errInvalidCredentials
+	}
+	return &Identity{Name: name, Method: "token"}, nil
+}
+
+// sessions are cookies carrying a sessionPayload signed with HMAC-SHA256. The ids of open sessions are
+// kept in memory, so logging out ends a session for good instead of only deleting the cookie of the browser.
+// Restarting the dashboard ends all sessions.
+type sessions struct {
+	secret []byte
+	ttl    time.Duration
+
+	sync.Mutex
+	active map[string]time.Time // expiry by session id
+}
+
+// sessionPayload is encoded as JSON, names like OpenID Connect subjects may contain any character.
+type sessionPayload struct {
+	ID      string
+	Name    string
+	Method  string
+	Role    string
+	Expires int64
+}
+
+func newSessions(secret []byte, ttl time.Duration) *sessions {
+	return &sessions{secret: secret, ttl: ttl, active: make(map[string]time.Time)}
+}
+
+func (s *sessions) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *sessions) Issue(res http.ResponseWriter, req *http.Request, identity *Identity) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.Printf("Could not generate session id: %v\n", err)
+		return
+	}
+	sessionID := base64.RawURLEncoding.EncodeToString(id)
+	expires := time.Now().Add(s.ttl)
+
+	s.Lock()
+	for other, expiry := range s.active { // forget expired sessions, their cookies are rejected anyway
+		if time.Now().After(expiry) {
+			delete(s.active, other)
+		}
+	}
+	s.active[sessionID] = expires
+	s.Unlock()
+
+	data, _ := json.Marshal(&sessionPayload{sessionID, identity.Name, identity.Method, identity.Role.String(), expires.Unix()})
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	http.SetCookie(res, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    payload + "." + s.sign(payload),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Clear ends the session of the request and deletes its cookie.
+func (s *sessions) Clear(res http.ResponseWriter, req *http.Request) {
+	if session, err := s.verify(req); err == nil && session != nil {
+		s.Lock()
+		delete(s.active, session.ID)
+		s.Unlock()
+	}
+	http.SetCookie(res, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// verify checks the signature of the session cookie and returns its payload, nil if there is no cookie.
+func (s *sessions) verify(req *http.Request) (*sessionPayload, error) {
+	cookie, err := req.Cookie(sessionCookieName)
+	if err != nil || len(cookie.Value) == 0 {
+		return nil, nil
+	}
+
+	values := strings.SplitN(cookie.Value, ".", 2)
+	if len(values) != 2 || !hmac.Equal([]byte(values[1]), []byte(s.sign(values[0]))) {
+		return nil, errInvalidSession
+	}
+	var session sessionPayload
+	if err := decodeSegment(values[0], &session); err != nil {
+		return nil, errInvalidSession
+	}
+	return &session, nil
+}
+
+func (s *sessions) Authenticate(req *http.Request) (*Identity, error) {
+	session, err := s.verify(req)
+	if session == nil {
+		return nil, err
+	}
+	role, err := parseRole(session.Role)
+	if err != nil || time.Now().Unix() > session.Expires {
+		return nil, errInvalidSession
+	}
+
+	s.Lock()
+	_, active := s.active[session.ID]
+	s.Unlock()
+	if !active { // logged out, or issued before a restart
+		return nil, errInvalidSession
+	}
+	return &Identity{Name: session.Name, Method: session.Method, Role: role}, nil
+}
+
+// readLines calls fn for every non-empty, non-comment line of a file.
+func readLines(file string, fn func(line string) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	number := 0
+	for scanner.Scan() {
+		number++
+		line := trim(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return fmt.Errorf("line %d: %v", number, err)
+		}
+	}
+	return scanner.Err()
+}
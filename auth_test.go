@@ -0,0 +1,199 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-martini/martini"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func setupAuthMartini(t *testing.T) (*martini.Martini, func()) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "dashboard-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/htpasswd", []byte("# users\nalice:"+string(hash)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/tokens", []byte("monitoring:s3cr3t-t0k3n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/roles", []byte("alice:admin\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("DASHBOARD_HTPASSWD", dir+"/htpasswd")
+	os.Setenv("DASHBOARD_TOKENS", dir+"/tokens")
+	os.Setenv("DASHBOARD_ROLES", dir+"/roles")
+	m := setupMartini()
+
+	return m, func() {
+		os.Unsetenv("DASHBOARD_HTPASSWD")
+		os.Unsetenv("DASHBOARD_TOKENS")
+		os.Unsetenv("DASHBOARD_ROLES")
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_todoapp_auth_API(t *testing.T) {
+	m, cleanup := setupAuthMartini(t)
+	defer cleanup()
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/api/hostname", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusUnauthorized)
+	Expect(t, response.Header().Get("WWW-Authenticate"), `Basic realm="dashboard"`)
+	Contain(t, response.Body.String(), `"Code": "unauthorized"`)
+	Contain(t, response.Body.String(), `"Message": "authentication required"`)
+	NotExpect(t, response.Header().Get("X-Request-Id"), "")
+
+	response = httptest.NewRecorder()
+	req.SetBasicAuth("alice", "wrong")
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusUnauthorized)
+
+	response = httptest.NewRecorder()
+	req.SetBasicAuth("alice", "secret")
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+	Contain(t, response.Body.String(), `"Hostname": "`)
+
+	response = httptest.NewRecorder()
+	req.Header.Set("Authorization", "Bearer wrong")
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusUnauthorized)
+
+	response = httptest.NewRecorder()
+	req.Header.Set("Authorization", "Bearer s3cr3t-t0k3n")
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+}
+
+func Test_todoapp_auth_Login(t *testing.T) {
+	m, cleanup := setupAuthMartini(t)
+	defer cleanup()
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusFound)
+	Expect(t, response.Header().Get("Location"), "/login?next=%2F")
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://localhost:4005/login?next=//evil.example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+	body := response.Body.String()
+	Contain(t, body, `<form method="POST" action="/login">`)
+	Contain(t, body, `<input type="hidden" name="next" value="/">`)
+
+	form := url.Values{"username": {"alice"}, "password": {"wrong"}, "next": {"/#env"}}
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "http://localhost:4005/login", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusUnauthorized)
+	Contain(t, response.Body.String(), `invalid username or password`)
+
+	form.Set("password", "secret")
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "http://localhost:4005/login", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusFound)
+	Expect(t, response.Header().Get("Location"), "/#env")
+
+	cookies := response.Result().Cookies()
+	Expect(t, len(cookies), 1)
+	Expect(t, cookies[0].Name, sessionCookieName)
+	Expect(t, cookies[0].HttpOnly, true)
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://localhost:4005/api/hostname", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(cookies[0])
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+
+	tampered := *cookies[0]
+	tampered.Value = "x" + tampered.Value
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://localhost:4005/api/hostname", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&tampered)
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusUnauthorized)
+	Contain(t, response.Body.String(), `"Message": "invalid or expired session"`)
+}
+
+func Test_todoapp_auth_Logout(t *testing.T) {
+	m, cleanup := setupAuthMartini(t)
+	defer cleanup()
+
+	form := url.Values{"username": {"alice"}, "password": {"secret"}}
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "http://localhost:4005/login", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusFound)
+	session := response.Result().Cookies()[0]
+
+	get := func(path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://localhost:4005"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(session)
+		m.ServeHTTP(response, req)
+		return response
+	}
+	Expect(t, get("/api/hostname").Code, http.StatusOK)
+
+	response = get("/logout")
+	Expect(t, response.Code, http.StatusFound)
+	Expect(t, response.Result().Cookies()[0].MaxAge, -1)
+
+	// a copy of the cookie kept after logging out is not accepted anymore
+	response = get("/api/hostname")
+	Expect(t, response.Code, http.StatusUnauthorized)
+	Contain(t, response.Body.String(), `"Message": "invalid or expired session"`)
+}
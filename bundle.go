@@ -0,0 +1,306 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/martini-contrib/render"
+)
+
+// bundleSource is a raw file or command output a collector is built from.
+type bundleSource struct {
+	Name    string
+	File    string
+	Command []string
+	Docker  bool // the command talks to the Docker Engine API socket of the docker collector
+	Args    bool // the output has command lines, their arguments are redacted
+}
+
+// kubernetes has none, the collector output is all there is without the raw annotations, which include
+// last-applied-configuration.
+var bundleSources = map[string][]bundleSource{
+	"cpu": {
+		{Name: "cpuinfo", File: "/proc/cpuinfo"},
+		{Name: "loadavg", File: "/proc/loadavg"},
+		{Name: "stat", File: "/proc/stat"},
+	},
+	"cpustat": {
+		{Name: "stat", File: "/proc/stat"},
+	},
+	"cgroup": {
+		{Name: "cgroup", File: "/proc/self/cgroup"},
+		{Name: "mountinfo", File: "/proc/self/mountinfo"},
+	},
+	"cgroups": {
+		{Name: "systemd-cgls", Command: []string{"systemd-cgls", "--no-pager", "--all"}},
+	},
+	"docker": {
+		{Name: "docker-ps", Command: []string{"docker", "ps", "--all", "--no-trunc"}, Docker: true, Args: true},
+	},
+	"docker_images": {
+		{Name: "docker-images", Command: []string{"docker", "images", "--all", "--no-trunc"}, Docker: true},
+	},
+	"mem": {
+		{Name: "meminfo", File: "/proc/meminfo"},
+		{Name: "free", Command: []string{"free", "-otm"}},
+	},
+	"disk": {
+		{Name: "df", Command: []string{"df", "-hP"}},
+		{Name: "df-bytes", Command: []string{"df", "-PB1"}},
+		{Name: "mounts", File: "/proc/mounts"},
+	},
+	"diskio": {
+		{Name: "diskstats", File: "/proc/diskstats"},
+	},
+	"top": {
+		{Name: "top", Command: []string{"top", "-b", "-n", "1"}},
+		{Name: "ps", Command: []string{"ps", "-aux"}, Args: true},
+	},
+	"addresses": {
+		{Name: "ip-addr", Command: []string{"ip", "addr"}},
+		{Name: "ip-route", Command: []string{"ip", "route", "show", "table", "all"}},
+	},
+	"routing": {
+		{Name: "route", File: "/proc/net/route"},
+		{Name: "ipv6_route", File: "/proc/net/ipv6_route"},
+		{Name: "arp", File: "/proc/net/arp"},
+		{Name: "ip-neigh", Command: []string{"ip", "neigh"}},
+		{Name: "resolv.conf", File: "/etc/resolv.conf"},
+		{Name: "hosts", File: "/etc/hosts"},
+		{Name: "nsswitch.conf", File: "/etc/nsswitch.conf"},
+	},
+	"sockets": {
+		{Name: "tcp", File: "/proc/net/tcp"},
+		{Name: "tcp6", File: "/proc/net/tcp6"},
+		{Name: "udp", File: "/proc/net/udp"},
+		{Name: "udp6", File: "/proc/net/udp6"},
+		{Name: "unix", File: "/proc/net/unix"},
+	},
+	"logged_on": {
+		{Name: "w", Command: []string{"w", "-ih"}},
+	},
+	"passwd": {
+		{Name: "passwd", File: "/etc/passwd"},
+	},
+	"network": {
+		{Name: "ip-addr", Command: []string{"ip", "-o", "addr"}},
+	},
+	"netstat": {
+		{Name: "net-dev", File: "/proc/net/dev"},
+	},
+}
+
+// bundleHistory is the number of most recent snapshots included in a bundle.
+const bundleHistory = 12
+
+// configVariables are included in the bundle next to all DASHBOARD_* variables.
+var configVariables = []string{"HOST", "PORT"}
+
+type manifestFile struct {
+	Path   string
+	Size   int
+	SHA256 string
+	Error  string `json:",omitempty"`
+}
+
+type manifestSection struct {
+	Name   string
+	Reason string
+}
+
+type bundleManifest struct {
+	Version     string
+	Hostname    string
+	Created     time.Time
+	CreatedBy   string
+	RequestID   string
+	Files       []*manifestFile
+	Unavailable []*manifestSection
+}
+
+// bundle writes a gzipped tar archive, all files end up in a single top level directory.
+type bundle struct {
+	dir      string
+	created  time.Time
+	tar      *tar.Writer
+	manifest *bundleManifest
+}
+
+func (b *bundle) add(path string, content []byte, err error) {
+	sum := sha256.Sum256(content)
+	file := &manifestFile{Path: path, Size: len(content), SHA256: hex.EncodeToString(sum[:])}
+	if err != nil {
+		file.Error = err.Error()
+	}
+	b.manifest.Files = append(b.manifest.Files, file)
+	b.write(path, content)
+}
+
+func (b *bundle) addJSON(path string, data interface{}, err error) {
+	content, jsonErr := json.MarshalIndent(data, "", "  ")
+	if jsonErr != nil {
+		content, err = nil, jsonErr
+	}
+	b.add(path, content, err)
+}
+
+func (b *bundle) write(path string, content []byte) {
+	b.tar.WriteHeader(&tar.Header{
+		Name:    b.dir + "/" + path,
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: b.created,
+	})
+	b.tar.Write(content)
+}
+
+func (b *bundle) unavailable(name, reason string) {
+	b.manifest.Unavailable = append(b.manifest.Unavailable, &manifestSection{name, reason})
+}
+
+// close adds the manifest and a sha256sum compatible checksum list covering every file.
+func (b *bundle) close() error {
+	manifest, err := json.MarshalIndent(b.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	b.write("manifest.json", manifest)
+
+	var checksums bytes.Buffer
+	for _, file := range b.manifest.Files {
+		fmt.Fprintf(&checksums, "%s  %s\n", file.SHA256, file.Path)
+	}
+	sum := sha256.Sum256(manifest)
+	fmt.Fprintf(&checksums, "%s  %s\n", hex.EncodeToString(sum[:]), "manifest.json")
+	b.write("checksums.sha256", checksums.Bytes())
+
+	return b.tar.Close()
+}
+
+func readSource(source bundleSource) ([]byte, error) {
+	if len(source.File) > 0 {
+		return ioutil.ReadFile(source.File)
+	}
+	var stdout, stderr bytes.Buffer
+	command := exec.Command(source.Command[0], source.Command[1:]...)
+	command.Stdout, command.Stderr = &stdout, &stderr
+	if source.Docker {
+		command.Env = append(os.Environ(), "DOCKER_HOST=unix://"+dockerSocket())
+	}
+	if err := command.Run(); err != nil {
+		if message := trim(stderr.String()); len(message) > 0 {
+			return stdout.Bytes(), fmt.Errorf("%v: %s", err, message)
+		}
+		return stdout.Bytes(), err
+	}
+	return stdout.Bytes(), nil
+}
+
+// dashboardConfig returns the configuration of the dashboard, i.e. all DASHBOARD_* variables.
+func dashboardConfig() (config []*Env) {
+	for _, env := range env() {
+		if strings.HasPrefix(env.Key, "DASHBOARD_") || containsString(configVariables, env.Key) {
+			config = append(config, env)
+		}
+	}
+	sort.Slice(config, func(i, j int) bool { return config[i].Key < config[j].Key })
+	return config
+}
+
+// BundleHandler streams a support bundle with the output and raw sources of every collector the caller may read.
+// Secrets are always redacted, revealing them is not supported for bundles.
+func BundleHandler(res http.ResponseWriter, req *http.Request, r render.Render, p *permissions, rd *redactor, store *snapshotStore, id *Identity) {
+	if !p.Action(id.Role, "bundle") {
+		forbidden(r, id, "", "bundle")
+		return
+	}
+
+	created := time.Now().UTC()
+	name := fmt.Sprintf("dashboard-bundle-%s-%s", currentHostname, created.Format("20060102T150405Z"))
+	res.Header().Set("Content-Type", "application/gzip")
+	res.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar.gz"`, name))
+	res.WriteHeader(http.StatusOK)
+
+	gz := gzip.NewWriter(res)
+	defer gz.Close()
+	b := &bundle{
+		dir:     name,
+		created: created,
+		tar:     tar.NewWriter(gz),
+		manifest: &bundleManifest{
+			Version:   version,
+			Hostname:  currentHostname,
+			Created:   created,
+			CreatedBy: id.Name,
+			RequestID: req.Header.Get(requestIDHeader),
+		},
+	}
+
+	b.addJSON("version.json", map[string]string{
+		"Version":   version,
+		"GoVersion": runtime.Version(),
+		"OS":        runtime.GOOS,
+		"Arch":      runtime.GOARCH,
+	}, nil)
+	b.addJSON("config.json", rd.Env(dashboardConfig()), nil)
+
+	for _, endpoint := range v1Endpoints {
+		if !p.Collector(id.Role, endpoint.Method) {
+			b.unavailable("collectors/"+endpoint.Method, fmt.Sprintf("role [%s] is not allowed to access it", id.Role))
+			continue
+		}
+
+		data, err := data(endpoint.Method)
+		if endpoint.Method == "headers" {
+			data, err = req.Header, nil
+		}
+		b.addJSON("collectors/"+endpoint.Method+".json", rd.Redact(data), err)
+
+		for _, source := range bundleSources[endpoint.Method] {
+			content, err := readSource(source)
+			if source.Args {
+				content = []byte(rd.CommandLine(string(content)))
+			}
+			b.add("sources/"+endpoint.Method+"/"+source.Name+".txt", content, err)
+		}
+	}
+
+	// recent history, snapshots are stored redacted and only lose the collectors the caller may not read
+	refs := store.List()
+	b.addJSON("history/snapshots.json", refs, nil)
+	if len(refs) > bundleHistory {
+		refs = refs[len(refs)-bundleHistory:]
+	}
+	for _, ref := range refs {
+		snapshot, err := store.Load(ref.ID)
+		if err != nil {
+			b.addJSON("history/"+ref.ID+".json", nil, err)
+			continue
+		}
+		b.addJSON("history/"+ref.ID+".json", snapshot.filter(p, id.Role), nil)
+	}
+
+	b.unavailable("alerts", "this dashboard does not evaluate alerts")
+
+	if err := b.close(); err != nil {
+		log.Printf("Could not finish bundle [%s]: %v\n", name, err)
+	}
+}
@@ -0,0 +1,190 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readBundle(t *testing.T, body io.Reader) (string, map[string][]byte) {
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := tar.NewReader(gz)
+
+	var dir string
+	files := make(map[string][]byte)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := strings.SplitN(header.Name, "/", 2)
+		dir = path[0]
+		content, err := ioutil.ReadAll(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[path[1]] = content
+	}
+	return dir, files
+}
+
+func Test_todoapp_api_Bundle(t *testing.T) {
+	os.Setenv("DASHBOARD_TEST_SECRET", "hunter2")
+	defer os.Unsetenv("DASHBOARD_TEST_SECRET")
+	// a process with a secret on its command line, as listed by ps
+	process := exec.Command("sh", "-c", "sleep 10", "sh", "--password=hunter2")
+	if err := process.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer process.Wait()
+	defer process.Process.Kill()
+
+	m := setupMartini()
+
+	// recent history
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "http://localhost:4005/api/snapshots", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusCreated)
+	var taken Snapshot
+	if err := json.Unmarshal(response.Body.Bytes(), &taken); err != nil {
+		t.Fatal(err)
+	}
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://localhost:4005/api/bundle", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, response.Header().Get("Content-Type"), "application/gzip")
+	Contain(t, response.Header().Get("Content-Disposition"), `attachment; filename="dashboard-bundle-`+currentHostname)
+
+	dir, files := readBundle(t, response.Body)
+	Contain(t, dir, "dashboard-bundle-"+currentHostname)
+
+	var manifest bundleManifest
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, manifest.Version, version)
+	Expect(t, manifest.Hostname, currentHostname)
+	Expect(t, manifest.RequestID, response.Header().Get("X-Request-Id"))
+	Expect(t, manifest.Unavailable[0].Name, "alerts")
+
+	// every file is listed in the manifest and its checksum matches
+	for _, file := range manifest.Files {
+		content, ok := files[file.Path]
+		if !ok {
+			t.Errorf("Expected bundle to contain [%s]", file.Path)
+			continue
+		}
+		sum := sha256.Sum256(content)
+		Expect(t, file.SHA256, hex.EncodeToString(sum[:]))
+	}
+	Expect(t, len(files), len(manifest.Files)+2)
+	for _, line := range strings.Split(Trim(string(files["checksums.sha256"])), "\n") {
+		checksum := strings.SplitN(line, "  ", 2)
+		sum := sha256.Sum256(files[checksum[1]])
+		Expect(t, checksum[0], hex.EncodeToString(sum[:]))
+	}
+
+	var cpu CPU
+	if err := json.Unmarshal(files["collectors/cpu.json"], &cpu); err != nil {
+		t.Fatal(err)
+	}
+	NotExpect(t, cpu.Processors, 0)
+	Contain(t, string(files["sources/cpu/cpuinfo.txt"]), "processor")
+
+	Contain(t, string(files["config.json"]), `"Key": "DASHBOARD_TEST_SECRET"`)
+	NotContain(t, string(files["config.json"]), "hunter2")
+	NotContain(t, string(files["collectors/env.json"]), "hunter2")
+	Contain(t, string(files["sources/top/ps.txt"]), "--password="+redactedValue)
+	Contain(t, string(files["collectors/top.json"]), "--password="+redactedValue)
+	NotContain(t, string(files["collectors/top.json"]), "hunter2")
+
+	var refs []*SnapshotRef
+	if err := json.Unmarshal(files["history/snapshots.json"], &refs); err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, refs[len(refs)-1].ID, taken.ID)
+	var snapshot Snapshot
+	if err := json.Unmarshal(files["history/"+taken.ID+".json"], &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, snapshot.ID, taken.ID)
+	NotExpect(t, len(snapshot.Processes), 0)
+	NotContain(t, string(files["history/"+taken.ID+".json"]), "hunter2")
+
+	// snapshots store the same process list
+	collected, err := json.Marshal(collectSnapshot(setupRedactor(), []string{"top"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Contain(t, string(collected), "--password="+redactedValue)
+	NotContain(t, string(collected), "hunter2")
+	NotContain(t, string(files["sources/top/ps.txt"]), "hunter2")
+}
+
+func Test_todoapp_api_Bundle_Forbidden(t *testing.T) {
+	m, cleanup := setupAuthMartini(t)
+	defer cleanup()
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/api/bundle", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer s3cr3t-t0k3n")
+
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusForbidden)
+	Contain(t, response.Body.String(), `is not allowed to access [bundle]`)
+}
+
+func Test_todoapp_bundle_DockerSource(t *testing.T) {
+	// the docker CLI is pointed to the socket of the docker collector
+	dir, err := ioutil.TempDir("", "dashboard-docker-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "docker"), []byte("#!/bin/sh\necho \"$DOCKER_HOST\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+	os.Setenv("DASHBOARD_DOCKER_SOCKET", "/run/user/1000/docker.sock")
+	defer os.Unsetenv("DASHBOARD_DOCKER_SOCKET")
+
+	content, err := readSource(bundleSources["docker"][0])
+	Expect(t, err, nil)
+	Expect(t, string(content), "unix:///run/user/1000/docker.sock\n")
+}
@@ -0,0 +1,312 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// cgroupUnlimited is the smallest value cgroup v1 reports for "no limit", the largest page counter rounded to pages.
+const cgroupUnlimited = 1 << 62
+
+var cgroupCPUSampler = newSampler(func() (interface{}, error) {
+	fs, err := detectCgroupFS()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := ownCgroupPaths()
+	if err != nil {
+		return nil, err
+	}
+	return fs.cpuCounters(fs.ownDir("cpu", paths), fs.ownDir("cpuacct", paths)), nil
+})
+
+type CgroupMemory struct {
+	LimitBytes      uint64  // the effective limit, including those of parent cgroups, 0 if unlimited
+	UsageBytes      uint64  // including the page cache
+	CacheBytes      uint64  // page cache, which the kernel reclaims before hitting the limit
+	WorkingSetBytes uint64  // usage without inactive page cache, what counts towards an OOM kill
+	UsagePercent    float64 // working set of the limit, 0 if unlimited
+	LimitHits       uint64  // times the usage hit the limit
+	OOMKills        uint64
+}
+
+type CgroupCPU struct {
+	QuotaCores       float64 // CPU time per period in cores, 0 if unlimited
+	Shares           uint64  // relative weight on cgroup v1
+	Weight           uint64  // relative weight on cgroup v2
+	UsageSeconds     float64 // since the cgroup was created
+	UsagePercent     float64 // of the quota, or of all CPUs without quota, since the previous request
+	Periods          uint64
+	ThrottledPeriods uint64
+	ThrottledSeconds float64
+	ThrottledPercent float64 // of the periods since the previous request
+}
+
+type CgroupPids struct {
+	Current uint64
+	Max     uint64 // 0 if unlimited
+}
+
+// Cgroup describes the resources of the cgroup the dashboard runs in, usually its container.
+type Cgroup struct {
+	Version int // 1 or 2, 0 if no cgroup filesystem is mounted
+	Path    string
+	Limited bool // whether a memory or CPU limit applies
+	Memory  *CgroupMemory
+	CPU     *CgroupCPU
+	Pids    *CgroupPids
+}
+
+// cgroupCPUCounters are the cumulative CPU counters of a cgroup, in nanoseconds.
+type cgroupCPUCounters struct {
+	usage, periods, throttled, throttledTime uint64
+}
+
+type cgroupFS struct {
+	version int
+	root    string
+}
+
+func detectCgroupFS() (*cgroupFS, error) {
+	root := filepath.Join(sysDir, "fs", "cgroup")
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		return &cgroupFS{version: 2, root: root}, nil
+	}
+	for _, controller := range []string{"memory", "cpu", "cpuacct"} {
+		if _, err := os.Stat(filepath.Join(root, controller)); err == nil {
+			return &cgroupFS{version: 1, root: root}, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// parseProcCgroup returns the cgroup path per controller from /proc/<pid>/cgroup, the v2 hierarchy has the controller "".
+func parseProcCgroup(content string) map[string]string {
+	paths := make(map[string]string)
+	for _, line := range strings.Split(trim(content), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		for _, controller := range strings.Split(fields[1], ",") {
+			paths[controller] = fields[2]
+		}
+	}
+	return paths
+}
+
+func ownCgroupPaths() (map[string]string, error) {
+	content, err := ioutil.ReadFile(procDir + "/self/cgroup")
+	if err != nil {
+		return nil, err
+	}
+	return parseProcCgroup(string(content)), nil
+}
+
+// dir returns the directory of a cgroup for a controller, cgroup v2 has all controllers in one hierarchy.
+func (fs *cgroupFS) dir(controller, path string) string {
+	if fs.version == 2 {
+		return filepath.Join(fs.root, path)
+	}
+	return filepath.Join(fs.root, controller, path)
+}
+
+// ownDir returns the directory of our own cgroup. Without a cgroup namespace, containers see the host path
+// in /proc/self/cgroup but have their own cgroup mounted as the root.
+func (fs *cgroupFS) ownDir(controller string, paths map[string]string) string {
+	path := paths[controller]
+	if fs.version == 2 {
+		path = paths[""]
+	}
+	dir := fs.dir(controller, path)
+	if _, err := os.Stat(dir); err != nil {
+		return fs.dir(controller, "/")
+	}
+	return dir
+}
+
+// readCgroupValue reads a single number, "max" and v1's huge "unlimited" values are returned as 0.
+func readCgroupValue(dir, file string) (uint64, bool) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return 0, false
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "max" {
+		return 0, true
+	}
+	number, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if number >= cgroupUnlimited {
+		return 0, true
+	}
+	return number, true
+}
+
+// readCgroupStats reads files like memory.stat and cpu.stat with a "key value" line per counter.
+func readCgroupStats(dir, file string) map[string]uint64 {
+	stats := make(map[string]uint64)
+	content, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return stats
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			stats[fields[0]], _ = strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return stats
+}
+
+func (fs *cgroupFS) memory(dir string) *CgroupMemory {
+	memory := &CgroupMemory{}
+	var inactiveFile uint64
+	if fs.version == 2 {
+		memory.UsageBytes, _ = readCgroupValue(dir, "memory.current")
+		stats := readCgroupStats(dir, "memory.stat")
+		memory.CacheBytes, inactiveFile = stats["file"], stats["inactive_file"]
+		events := readCgroupStats(dir, "memory.events")
+		memory.LimitHits, memory.OOMKills = events["max"], events["oom_kill"]
+
+		// the effective limit is the lowest one up to the root
+		for current := dir; strings.HasPrefix(current, fs.root) && current != fs.root; current = filepath.Dir(current) {
+			if limit, ok := readCgroupValue(current, "memory.max"); ok && limit > 0 && (memory.LimitBytes == 0 || limit < memory.LimitBytes) {
+				memory.LimitBytes = limit
+			}
+		}
+	} else {
+		memory.UsageBytes, _ = readCgroupValue(dir, "memory.usage_in_bytes")
+		memory.LimitBytes, _ = readCgroupValue(dir, "memory.limit_in_bytes")
+		memory.LimitHits, _ = readCgroupValue(dir, "memory.failcnt")
+		memory.OOMKills = readCgroupStats(dir, "memory.oom_control")["oom_kill"]
+		stats := readCgroupStats(dir, "memory.stat")
+		memory.CacheBytes, inactiveFile = stats["total_cache"], stats["total_inactive_file"]
+		if limit := stats["hierarchical_memory_limit"]; limit > 0 && limit < cgroupUnlimited && (memory.LimitBytes == 0 || limit < memory.LimitBytes) {
+			memory.LimitBytes = limit
+		}
+	}
+
+	memory.WorkingSetBytes = memory.UsageBytes
+	if inactiveFile < memory.UsageBytes {
+		memory.WorkingSetBytes -= inactiveFile
+	}
+	if memory.LimitBytes > 0 {
+		memory.UsagePercent = math.Round(float64(memory.WorkingSetBytes)/float64(memory.LimitBytes)*1000) / 10
+	}
+	return memory
+}
+
+// cpuQuota returns the CPU time per period in cores, and the relative weight.
+func (fs *cgroupFS) cpuQuota(dir string) (cores float64, shares, weight uint64) {
+	if fs.version == 2 {
+		weight, _ = readCgroupValue(dir, "cpu.weight")
+		content, err := ioutil.ReadFile(filepath.Join(dir, "cpu.max"))
+		if err != nil {
+			return 0, 0, weight
+		}
+		fields := strings.Fields(string(content)) // "max 100000" or "50000 100000"
+		if len(fields) == 2 && fields[0] != "max" {
+			quota, _ := strconv.ParseFloat(fields[0], 64)
+			period, _ := strconv.ParseFloat(fields[1], 64)
+			if period > 0 {
+				cores = quota / period
+			}
+		}
+		return cores, 0, weight
+	}
+
+	shares, _ = readCgroupValue(dir, "cpu.shares")
+	content, err := ioutil.ReadFile(filepath.Join(dir, "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0, shares, 0
+	}
+	quota, _ := strconv.ParseFloat(strings.TrimSpace(string(content)), 64) // -1 if unlimited
+	period, _ := readCgroupValue(dir, "cpu.cfs_period_us")
+	if quota > 0 && period > 0 {
+		cores = quota / float64(period)
+	}
+	return cores, shares, 0
+}
+
+func (fs *cgroupFS) cpuCounters(cpuDir, cpuacctDir string) *cgroupCPUCounters {
+	stats := readCgroupStats(cpuDir, "cpu.stat")
+	counters := &cgroupCPUCounters{periods: stats["nr_periods"], throttled: stats["nr_throttled"]}
+	if fs.version == 2 {
+		counters.usage = stats["usage_usec"] * 1000
+		counters.throttledTime = stats["throttled_usec"] * 1000
+	} else {
+		counters.usage, _ = readCgroupValue(cpuacctDir, "cpuacct.usage")
+		counters.throttledTime = stats["throttled_time"]
+	}
+	return counters
+}
+
+func cgroupCPUBetween(previous, current *cgroupCPUCounters, seconds, quotaCores float64, cpus int) *CgroupCPU {
+	cpu := &CgroupCPU{
+		UsageSeconds:     math.Round(float64(current.usage)/1e7) / 100,
+		Periods:          current.periods,
+		ThrottledPeriods: current.throttled,
+		ThrottledSeconds: math.Round(float64(current.throttledTime)/1e7) / 100,
+		QuotaCores:       quotaCores,
+	}
+	cores := quotaCores
+	if cores == 0 {
+		cores = float64(cpus)
+	}
+	if cores > 0 {
+		cpu.UsagePercent = math.Round(rate(previous.usage, current.usage, seconds)/1e9/cores*1000) / 10
+	}
+	if current.periods > previous.periods && current.throttled >= previous.throttled {
+		cpu.ThrottledPercent = math.Round(float64(current.throttled-previous.throttled)/float64(current.periods-previous.periods)*1000) / 10
+	}
+	return cpu
+}
+
+func cgroup() (*Cgroup, error) {
+	fs, err := detectCgroupFS()
+	if err != nil { // not on Linux, or in a container without /sys/fs/cgroup
+		return &Cgroup{}, nil
+	}
+	paths, err := ownCgroupPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	data := &Cgroup{Version: fs.version, Path: paths["memory"]}
+	if fs.version == 2 {
+		data.Path = paths[""]
+	}
+	data.Memory = fs.memory(fs.ownDir("memory", paths))
+
+	previous, current, seconds, err := cgroupCPUSampler.sample()
+	if err != nil {
+		return nil, err
+	}
+	cpuDir := fs.ownDir("cpu", paths)
+	quota, shares, weight := fs.cpuQuota(cpuDir)
+	data.CPU = cgroupCPUBetween(previous.(*cgroupCPUCounters), current.(*cgroupCPUCounters), seconds, quota, runtime.NumCPU())
+	data.CPU.Shares, data.CPU.Weight = shares, weight
+
+	pidsDir := fs.ownDir("pids", paths)
+	if current, ok := readCgroupValue(pidsDir, "pids.current"); ok {
+		data.Pids = &CgroupPids{Current: current}
+		data.Pids.Max, _ = readCgroupValue(pidsDir, "pids.max")
+	}
+
+	data.Limited = data.Memory.LimitBytes > 0 || data.CPU.QuotaCores > 0
+	return data, nil
+}
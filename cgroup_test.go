@@ -0,0 +1,188 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeRoot creates files below a temporary directory, which replaces /proc and /sys.
+func fakeRoot(t *testing.T, files map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "dashboard-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	originalProc, originalSys := procDir, sysDir
+	procDir, sysDir = filepath.Join(dir, "proc"), filepath.Join(dir, "sys")
+	resetSamplers()
+	return dir, func() {
+		procDir, sysDir = originalProc, originalSys
+		resetSamplers()
+		os.RemoveAll(dir)
+	}
+}
+
+// resetSamplers forgets the readings of the real root, a sample shared across the switch would mix both.
+func resetSamplers() {
+	for _, s := range []*sampler{cpuStatSampler, diskStatsSampler, netDevSampler, cgroupCPUSampler, cgroupTreeSampler} {
+		s.Lock()
+		s.last, s.previous = nil, nil
+		s.Unlock()
+	}
+}
+
+func Test_todoapp_cgroup_parseProcCgroup(t *testing.T) {
+	paths := parseProcCgroup("12:pids:/docker/abc\n4:cpu,cpuacct:/docker/abc\n1:name=systemd:/docker/abc\n0::/system.slice/docker-abc.scope\n")
+	Expect(t, paths["cpu"], "/docker/abc")
+	Expect(t, paths["cpuacct"], "/docker/abc")
+	Expect(t, paths["pids"], "/docker/abc")
+	Expect(t, paths[""], "/system.slice/docker-abc.scope")
+}
+
+func Test_todoapp_cgroup_v1(t *testing.T) {
+	// without cgroup namespace, the container sees the host path but has its own cgroup mounted as root
+	_, cleanup := fakeRoot(t, map[string]string{
+		"proc/self/cgroup":                           "5:memory:/docker/abc\n4:cpu,cpuacct:/docker/abc\n3:pids:/docker/abc\n",
+		"sys/fs/cgroup/memory/memory.limit_in_bytes": "268435456\n",
+		"sys/fs/cgroup/memory/memory.usage_in_bytes": "167772160\n",
+		"sys/fs/cgroup/memory/memory.failcnt":        "12\n",
+		"sys/fs/cgroup/memory/memory.oom_control":    "oom_kill_disable 0\nunder_oom 0\noom_kill 2\n",
+		"sys/fs/cgroup/memory/memory.stat":           "cache 1\ntotal_cache 67108864\ntotal_inactive_file 33554432\nhierarchical_memory_limit 9223372036854771712\n",
+		"sys/fs/cgroup/cpu/cpu.cfs_quota_us":         "150000\n",
+		"sys/fs/cgroup/cpu/cpu.cfs_period_us":        "100000\n",
+		"sys/fs/cgroup/cpu/cpu.shares":               "512\n",
+		"sys/fs/cgroup/cpu/cpu.stat":                 "nr_periods 200\nnr_throttled 50\nthrottled_time 2500000000\n",
+		"sys/fs/cgroup/cpuacct/cpuacct.usage":        "12000000000\n",
+		"sys/fs/cgroup/pids/pids.current":            "7\n",
+		"sys/fs/cgroup/pids/pids.max":                "max\n",
+	})
+	defer cleanup()
+
+	fs, err := detectCgroupFS()
+	Expect(t, err, nil)
+	Expect(t, fs.version, 1)
+	paths, _ := ownCgroupPaths()
+	Expect(t, fs.ownDir("memory", paths), filepath.Join(sysDir, "fs", "cgroup", "memory"))
+
+	memory := fs.memory(fs.ownDir("memory", paths))
+	Expect(t, *memory, CgroupMemory{
+		LimitBytes:      256 << 20,
+		UsageBytes:      160 << 20,
+		CacheBytes:      64 << 20,
+		WorkingSetBytes: 128 << 20,
+		UsagePercent:    50,
+		LimitHits:       12,
+		OOMKills:        2,
+	})
+
+	cores, shares, weight := fs.cpuQuota(fs.ownDir("cpu", paths))
+	Expect(t, cores, 1.5)
+	Expect(t, shares, uint64(512))
+	Expect(t, weight, uint64(0))
+	counters := fs.cpuCounters(fs.ownDir("cpu", paths), fs.ownDir("cpuacct", paths))
+	Expect(t, *counters, cgroupCPUCounters{usage: 12e9, periods: 200, throttled: 50, throttledTime: 25e8})
+
+	data, err := cgroup()
+	Expect(t, err, nil)
+	Expect(t, data.Version, 1)
+	Expect(t, data.Path, "/docker/abc")
+	Expect(t, data.Limited, true)
+	Expect(t, data.CPU.QuotaCores, 1.5)
+	Expect(t, data.CPU.UsageSeconds, 12.0)
+	Expect(t, data.CPU.ThrottledSeconds, 2.5)
+	Expect(t, *data.Pids, CgroupPids{Current: 7})
+}
+
+func Test_todoapp_cgroup_v2(t *testing.T) {
+	_, cleanup := fakeRoot(t, map[string]string{
+		"proc/self/cgroup":                               "0::/kubepods/pod1/abc\n",
+		"sys/fs/cgroup/cgroup.controllers":               "cpu memory pids\n",
+		"sys/fs/cgroup/kubepods/memory.max":              "1073741824\n",
+		"sys/fs/cgroup/kubepods/pod1/memory.max":         "536870912\n",
+		"sys/fs/cgroup/kubepods/pod1/abc/memory.max":     "max\n",
+		"sys/fs/cgroup/kubepods/pod1/abc/memory.current": "134217728\n",
+		"sys/fs/cgroup/kubepods/pod1/abc/memory.stat":    "anon 100\nfile 50331648\ninactive_file 33554432\n",
+		"sys/fs/cgroup/kubepods/pod1/abc/memory.events":  "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+		"sys/fs/cgroup/kubepods/pod1/abc/cpu.max":        "50000 100000\n",
+		"sys/fs/cgroup/kubepods/pod1/abc/cpu.weight":     "100\n",
+		"sys/fs/cgroup/kubepods/pod1/abc/cpu.stat":       "usage_usec 3000000\nuser_usec 2000000\nsystem_usec 1000000\nnr_periods 10\nnr_throttled 4\nthrottled_usec 500000\n",
+		"sys/fs/cgroup/kubepods/pod1/abc/pids.current":   "3\n",
+		"sys/fs/cgroup/kubepods/pod1/abc/pids.max":       "100\n",
+	})
+	defer cleanup()
+
+	fs, err := detectCgroupFS()
+	Expect(t, err, nil)
+	Expect(t, fs.version, 2)
+	paths, _ := ownCgroupPaths()
+	dir := fs.ownDir("memory", paths)
+	Expect(t, dir, filepath.Join(sysDir, "fs", "cgroup", "kubepods", "pod1", "abc"))
+
+	// the limit of the pod applies
+	memory := fs.memory(dir)
+	Expect(t, memory.LimitBytes, uint64(512<<20))
+	Expect(t, memory.WorkingSetBytes, uint64(96<<20))
+	Expect(t, memory.UsagePercent, 18.8)
+	Expect(t, memory.LimitHits, uint64(3))
+	Expect(t, memory.OOMKills, uint64(1))
+
+	cores, _, weight := fs.cpuQuota(dir)
+	Expect(t, cores, 0.5)
+	Expect(t, weight, uint64(100))
+
+	data, err := cgroup()
+	Expect(t, err, nil)
+	Expect(t, data.Version, 2)
+	Expect(t, data.Path, "/kubepods/pod1/abc")
+	Expect(t, data.CPU.UsageSeconds, 3.0)
+	Expect(t, data.CPU.Periods, uint64(10))
+	Expect(t, *data.Pids, CgroupPids{Current: 3, Max: 100})
+}
+
+func Test_todoapp_cgroup_cgroupCPUBetween(t *testing.T) {
+	previous := &cgroupCPUCounters{usage: 1e9, periods: 100, throttled: 10}
+	current := &cgroupCPUCounters{usage: 2e9, periods: 120, throttled: 15, throttledTime: 1e9}
+
+	cpu := cgroupCPUBetween(previous, current, 2, 0.5, 8)
+	Expect(t, cpu.UsagePercent, 100.0)
+	Expect(t, cpu.ThrottledPercent, 25.0)
+	Expect(t, cpu.UsageSeconds, 2.0)
+	Expect(t, cpu.ThrottledSeconds, 1.0)
+
+	// without quota, relative to all CPUs
+	Expect(t, cgroupCPUBetween(previous, current, 2, 0, 4).UsagePercent, 12.5)
+	Expect(t, cgroupCPUBetween(current, current, 2, 0, 4).ThrottledPercent, 0.0)
+}
+
+func Test_todoapp_cgroup_None(t *testing.T) {
+	_, cleanup := fakeRoot(t, map[string]string{"proc/self/cgroup": "0::/\n"})
+	defer cleanup()
+
+	data, err := cgroup()
+	Expect(t, err, nil)
+	Expect(t, data.Version, 0)
+	Expect(t, data.Memory == nil, true)
+}
+
+func Test_todoapp_api_GetCgroup(t *testing.T) {
+	var data Cgroup
+	response := getV2(t, "/api/cgroup", &data)
+	Expect(t, response.Code, http.StatusOK)
+	Contain(t, response.Body.String(), `"Version"`)
+}
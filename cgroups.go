@@ -0,0 +1,302 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxCgroupDepth is how deep the tree is shown, like systemd-cgtop --depth. Deeper cgroups are accounted to their ancestor.
+var maxCgroupDepth = 3
+
+// cgroupControllersV1 are the hierarchies walked on cgroup v1, the tree is the union of their cgroups.
+var cgroupControllersV1 = []string{"cpuacct", "memory", "blkio", "pids"}
+
+var cgroupTreeSampler = newSampler(func() (interface{}, error) {
+	fs, err := detectCgroupFS()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := fs.walk()
+	if err != nil {
+		return nil, err
+	}
+	counters := make(map[string]*cgroupCounters)
+	for _, path := range paths {
+		counters[path] = fs.counters(path)
+	}
+	return counters, nil
+})
+
+// CgroupNode is a cgroup of the tree, usage includes that of its descendants.
+type CgroupNode struct {
+	Path                  string
+	Name                  string
+	Depth                 int // 0 for the root cgroup
+	Tasks                 int // processes in the cgroup and its descendants
+	CPUUsageSeconds       float64
+	CPUPercent            float64 // of a single CPU like top, since the previous request
+	MemoryBytes           uint64  // including the page cache, 0 if unknown, like for the v2 root cgroup
+	IOReadBytesPerSecond  float64
+	IOWriteBytesPerSecond float64
+	Pids                  []int // processes in the cgroup, and in its descendants deeper than the tree is shown
+}
+
+type CgroupTree struct {
+	Version int           // 1 or 2, 0 if no cgroup filesystem is mounted
+	Cgroups []*CgroupNode // depth first, parents before their children
+}
+
+// cgroupCounters are the cumulative counters of a cgroup, CPU time in nanoseconds and I/O in bytes.
+type cgroupCounters struct {
+	cpu, read, write uint64
+}
+
+// walk returns the paths of all cgroups, like "/" and "/system.slice/docker.service".
+func (fs *cgroupFS) walk() ([]string, error) {
+	controllers := []string{""}
+	if fs.version == 1 {
+		controllers = cgroupControllersV1
+	}
+
+	seen := make(map[string]bool)
+	for _, controller := range controllers {
+		root, err := filepath.EvalSymlinks(fs.dir(controller, "/")) // cpuacct is usually a link to cpu,cpuacct
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if path != root && (os.IsNotExist(err) || os.IsPermission(err)) { // removed in the meantime, or not ours
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if !info.IsDir() {
+				return nil
+			}
+			relative, _ := filepath.Rel(root, path)
+			seen[filepath.ToSlash(filepath.Join("/", relative))] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var paths []string
+	for path := range seen {
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// counters reads the CPU time and the bytes read and written of a cgroup.
+func (fs *cgroupFS) counters(path string) *cgroupCounters {
+	counters := &cgroupCounters{}
+	if fs.version == 2 {
+		dir := fs.dir("", path)
+		counters.cpu = readCgroupStats(dir, "cpu.stat")["usage_usec"] * 1000
+		counters.read, counters.write = readIOStat(dir)
+		return counters
+	}
+	counters.cpu, _ = readCgroupValue(fs.dir("cpuacct", path), "cpuacct.usage")
+	counters.read, counters.write = readBlkioServiceBytes(fs.dir("blkio", path))
+	return counters
+}
+
+// readIOStat sums the bytes of all devices in io.stat, e.g. "8:0 rbytes=1024 wbytes=4096 rios=1 wios=1".
+func readIOStat(dir string) (read, write uint64) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, "io.stat"))
+	if err != nil {
+		return 0, 0
+	}
+	for _, fields := range configLines(string(content)) {
+		for _, field := range fields[1:] {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			value, _ := strconv.ParseUint(parts[1], 10, 64)
+			switch parts[0] {
+			case "rbytes":
+				read += value
+			case "wbytes":
+				write += value
+			}
+		}
+	}
+	return read, write
+}
+
+// readBlkioServiceBytes sums the bytes of all devices in blkio.throttle.io_service_bytes, e.g. "8:0 Read 1024".
+// Unlike the file without "throttle", it also counts I/O of the multi-queue block layer.
+func readBlkioServiceBytes(dir string) (read, write uint64) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, "blkio.throttle.io_service_bytes"))
+	if err != nil {
+		return 0, 0
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 { // the "Total" line has no device
+			continue
+		}
+		value, _ := strconv.ParseUint(fields[2], 10, 64)
+		switch fields[1] {
+		case "Read":
+			read += value
+		case "Write":
+			write += value
+		}
+	}
+	return read, write
+}
+
+// procs returns the processes in a cgroup, but not those of its descendants.
+func (fs *cgroupFS) procs(path string) []int {
+	controllers := []string{""}
+	if fs.version == 1 {
+		controllers = cgroupControllersV1
+	}
+	for _, controller := range controllers {
+		content, err := ioutil.ReadFile(filepath.Join(fs.dir(controller, path), "cgroup.procs"))
+		if err != nil {
+			continue
+		}
+		pids := []int{}
+		for _, line := range strings.Fields(string(content)) {
+			if pid, err := strconv.Atoi(line); err == nil {
+				pids = append(pids, pid)
+			}
+		}
+		return pids
+	}
+	return []int{}
+}
+
+func (fs *cgroupFS) memoryUsage(path string) uint64 {
+	if fs.version == 2 {
+		usage, _ := readCgroupValue(fs.dir("", path), "memory.current")
+		return usage
+	}
+	usage, _ := readCgroupValue(fs.dir("memory", path), "memory.usage_in_bytes")
+	return usage
+}
+
+// cgroupDepth is the number of path components, 0 for the root cgroup.
+func cgroupDepth(path string) int {
+	if path == "/" {
+		return 0
+	}
+	return strings.Count(path, "/")
+}
+
+// cgroupParent returns the path of the parent of a cgroup, "" for the root cgroup.
+func cgroupParent(path string) string {
+	if path == "/" {
+		return ""
+	}
+	return filepath.ToSlash(filepath.Dir(path))
+}
+
+// buildCgroupTree counts the tasks of the cgroups including their descendants, accounts the processes of cgroups
+// deeper than maxDepth to their ancestor, and returns the cgroups with tasks depth first. The root is always kept.
+func buildCgroupTree(nodes map[string]*CgroupNode, maxDepth int) []*CgroupNode {
+	var paths []string
+	for path := range nodes {
+		paths = append(paths, path)
+	}
+	// deepest first, so the tasks of children are counted before they are added to their parent
+	sort.Slice(paths, func(i, j int) bool {
+		if cgroupDepth(paths[i]) != cgroupDepth(paths[j]) {
+			return cgroupDepth(paths[i]) > cgroupDepth(paths[j])
+		}
+		return paths[i] < paths[j]
+	})
+	for _, node := range nodes {
+		node.Tasks = len(node.Pids)
+	}
+	for _, path := range paths {
+		node := nodes[path]
+		parent, ok := nodes[cgroupParent(path)]
+		if !ok {
+			continue
+		}
+		parent.Tasks += node.Tasks
+		if node.Depth > maxDepth {
+			parent.Pids = append(parent.Pids, node.Pids...)
+		}
+	}
+
+	tree := []*CgroupNode{}
+	for _, path := range paths {
+		if node := nodes[path]; node.Depth <= maxDepth && (node.Tasks > 0 || node.Depth == 0) {
+			sort.Ints(node.Pids)
+			tree = append(tree, node)
+		}
+	}
+	// by path components, as "-" sorts before "/" and would put "/a-b" between "/a" and "/a/b"
+	sort.Slice(tree, func(i, j int) bool {
+		a, b := strings.Split(tree[i].Path, "/"), strings.Split(tree[j].Path, "/")
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return tree
+}
+
+// cgroups samples the counters of all cgroups, rates cover the time since the previous call.
+func cgroups() (*CgroupTree, error) {
+	fs, err := detectCgroupFS()
+	if err != nil { // not on Linux, or in a container without /sys/fs/cgroup
+		return &CgroupTree{Cgroups: []*CgroupNode{}}, nil
+	}
+	previous, current, seconds, err := cgroupTreeSampler.sample()
+	if err != nil {
+		return nil, err
+	}
+	before, counters := previous.(map[string]*cgroupCounters), current.(map[string]*cgroupCounters)
+
+	round := func(v float64) float64 {
+		return math.Round(v*10) / 10
+	}
+	nodes := make(map[string]*CgroupNode)
+	for path, cur := range counters {
+		old, ok := before[path]
+		if !ok { // created since the previous reading
+			old = cur
+		}
+		name := filepath.Base(path)
+		if path == "/" {
+			name = "/"
+		}
+		nodes[path] = &CgroupNode{
+			Path:                  path,
+			Name:                  name,
+			Depth:                 cgroupDepth(path),
+			CPUUsageSeconds:       math.Round(float64(cur.cpu)/1e7) / 100,
+			CPUPercent:            round(rate(old.cpu, cur.cpu, seconds) / 1e7),
+			MemoryBytes:           fs.memoryUsage(path),
+			IOReadBytesPerSecond:  round(rate(old.read, cur.read, seconds)),
+			IOWriteBytesPerSecond: round(rate(old.write, cur.write, seconds)),
+			Pids:                  fs.procs(path),
+		}
+	}
+	return &CgroupTree{Version: fs.version, Cgroups: buildCgroupTree(nodes, maxCgroupDepth)}, nil
+}
@@ -0,0 +1,154 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"net/http"
+	"sort"
+	"testing"
+)
+
+func Test_todoapp_cgroups_readIO(t *testing.T) {
+	_, cleanup := fakeRoot(t, map[string]string{
+		"sys/v2/io.stat":                         "8:0 rbytes=1024 wbytes=4096 rios=1 wios=2 dbytes=0 dios=0\n8:16 rbytes=1024 wbytes=0 rios=1 wios=0\n",
+		"sys/v1/blkio.throttle.io_service_bytes": "8:0 Read 2048\n8:0 Write 512\n8:0 Sync 2560\n8:0 Async 0\n8:0 Total 2560\n8:16 Read 1024\nTotal 3584\n",
+	})
+	defer cleanup()
+
+	read, write := readIOStat(sysDir + "/v2")
+	Expect(t, read, uint64(2048))
+	Expect(t, write, uint64(4096))
+	read, write = readBlkioServiceBytes(sysDir + "/v1")
+	Expect(t, read, uint64(3072))
+	Expect(t, write, uint64(512))
+	read, _ = readIOStat(sysDir + "/missing")
+	Expect(t, read, uint64(0))
+}
+
+func Test_todoapp_cgroups_buildCgroupTree(t *testing.T) {
+	nodes := make(map[string]*CgroupNode)
+	for path, pids := range map[string][]int{
+		"/":                            {1},
+		"/system.slice":                {},
+		"/system.slice/cron.service":   {200},
+		"/system.slice/docker.service": {300, 301},
+		"/system.slice/empty.service":  {},
+		"/system.slice-extra":          {400},
+		"/user.slice/user-1000.slice/session-1.scope/app": {500, 501},
+	} {
+		nodes[path] = &CgroupNode{Path: path, Depth: cgroupDepth(path), Pids: pids}
+	}
+	nodes["/user.slice"] = &CgroupNode{Path: "/user.slice", Depth: 1, Pids: []int{}}
+	nodes["/user.slice/user-1000.slice"] = &CgroupNode{Path: "/user.slice/user-1000.slice", Depth: 2, Pids: []int{}}
+	nodes["/user.slice/user-1000.slice/session-1.scope"] = &CgroupNode{Path: "/user.slice/user-1000.slice/session-1.scope", Depth: 3, Pids: []int{502}}
+
+	tree := buildCgroupTree(nodes, 3)
+	var paths []string
+	for _, node := range tree {
+		paths = append(paths, node.Path)
+	}
+	Expect(t, paths, []string{
+		"/",
+		"/system.slice",
+		"/system.slice/cron.service",
+		"/system.slice/docker.service",
+		"/system.slice-extra",
+		"/user.slice",
+		"/user.slice/user-1000.slice",
+		"/user.slice/user-1000.slice/session-1.scope",
+	})
+	Expect(t, tree[0].Tasks, 8)
+	Expect(t, tree[1].Tasks, 3)
+	Expect(t, tree[5].Tasks, 3)
+
+	// processes of cgroups deeper than shown belong to their ancestor
+	Expect(t, tree[7].Tasks, 3)
+	Expect(t, tree[7].Pids, []int{500, 501, 502})
+	Expect(t, tree[0].Pids, []int{1})
+
+	Expect(t, cgroupDepth("/"), 0)
+	Expect(t, cgroupDepth("/a/b"), 2)
+	Expect(t, cgroupParent("/a/b"), "/a")
+	Expect(t, cgroupParent("/a"), "/")
+}
+
+func Test_todoapp_cgroups_v1(t *testing.T) {
+	_, cleanup := fakeRoot(t, map[string]string{
+		"sys/fs/cgroup/cpuacct/cgroup.procs":                                   "1\n2\n",
+		"sys/fs/cgroup/cpuacct/cpuacct.usage":                                  "9000000000\n",
+		"sys/fs/cgroup/cpuacct/docker/abc/cgroup.procs":                        "300\n",
+		"sys/fs/cgroup/cpuacct/docker/abc/cpuacct.usage":                       "1500000000\n",
+		"sys/fs/cgroup/memory/docker/abc/memory.usage_in_bytes":                "104857600\n",
+		"sys/fs/cgroup/blkio/docker/abc/blkio.throttle.io_service_bytes":       "8:0 Read 4096\n8:0 Write 8192\n",
+		"sys/fs/cgroup/memory/system.slice/cron.service/memory.usage_in_bytes": "1048576\n",
+	})
+	defer cleanup()
+
+	fs, err := detectCgroupFS()
+	Expect(t, err, nil)
+	paths, err := fs.walk()
+	Expect(t, err, nil)
+	sort.Strings(paths)
+	Expect(t, paths, []string{"/", "/docker", "/docker/abc", "/system.slice", "/system.slice/cron.service"})
+
+	Expect(t, *fs.counters("/docker/abc"), cgroupCounters{cpu: 15e8, read: 4096, write: 8192})
+	Expect(t, fs.procs("/docker/abc"), []int{300})
+	Expect(t, fs.memoryUsage("/docker/abc"), uint64(100<<20))
+
+	data, err := cgroups()
+	Expect(t, err, nil)
+	Expect(t, data.Version, 1)
+	// the cron service has no processes
+	Expect(t, len(data.Cgroups), 3)
+	Expect(t, data.Cgroups[2].Path, "/docker/abc")
+	Expect(t, data.Cgroups[2].Name, "abc")
+	Expect(t, data.Cgroups[2].CPUUsageSeconds, 1.5)
+	Expect(t, data.Cgroups[2].MemoryBytes, uint64(100<<20))
+	Expect(t, data.Cgroups[0].Tasks, 3)
+}
+
+func Test_todoapp_cgroups_v2(t *testing.T) {
+	_, cleanup := fakeRoot(t, map[string]string{
+		"sys/fs/cgroup/cgroup.controllers":                        "cpu io memory pids\n",
+		"sys/fs/cgroup/cgroup.procs":                              "1\n",
+		"sys/fs/cgroup/cpu.stat":                                  "usage_usec 10000000\n",
+		"sys/fs/cgroup/system.slice/cgroup.procs":                 "",
+		"sys/fs/cgroup/system.slice/nginx.service/cgroup.procs":   "100\n101\n",
+		"sys/fs/cgroup/system.slice/nginx.service/cpu.stat":       "usage_usec 2500000\nuser_usec 2000000\n",
+		"sys/fs/cgroup/system.slice/nginx.service/memory.current": "52428800\n",
+		"sys/fs/cgroup/system.slice/nginx.service/io.stat":        "8:0 rbytes=4096 wbytes=0 rios=1 wios=0\n",
+	})
+	defer cleanup()
+
+	data, err := cgroups()
+	Expect(t, err, nil)
+	Expect(t, data.Version, 2)
+	Expect(t, len(data.Cgroups), 3)
+	Expect(t, data.Cgroups[0].Name, "/")
+	Expect(t, data.Cgroups[0].MemoryBytes, uint64(0))
+	Expect(t, data.Cgroups[0].CPUUsageSeconds, 10.0)
+	Expect(t, data.Cgroups[2].Path, "/system.slice/nginx.service")
+	Expect(t, data.Cgroups[2].Depth, 2)
+	Expect(t, data.Cgroups[2].Pids, []int{100, 101})
+	Expect(t, data.Cgroups[2].MemoryBytes, uint64(50<<20))
+	Expect(t, data.Cgroups[1].Tasks, 2)
+}
+
+func Test_todoapp_cgroups_None(t *testing.T) {
+	_, cleanup := fakeRoot(t, map[string]string{"proc/self/cgroup": "0::/\n"})
+	defer cleanup()
+
+	data, err := cgroups()
+	Expect(t, err, nil)
+	Expect(t, data.Version, 0)
+	Expect(t, len(data.Cgroups), 0)
+}
+
+func Test_todoapp_api_GetCgroups(t *testing.T) {
+	var data CgroupTree
+	response := getV2(t, "/api/cgroups", &data)
+	Expect(t, response.Code, http.StatusOK)
+	Contain(t, response.Body.String(), `"Cgroups"`)
+}
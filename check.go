@@ -0,0 +1,412 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+)
+
+// Nagios plugin states, they double as exit codes of "dashboard check".
+const (
+	CheckOK = iota
+	CheckWarning
+	CheckCritical
+	CheckUnknown
+)
+
+var checkStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// nagiosRange is a threshold in the Nagios plugin range format, e.g. "10", "10:", "~:10", "10:20" or "@10:20".
+type nagiosRange struct {
+	start, end float64
+	inside     bool
+}
+
+func parseRange(value string) (*nagiosRange, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	r := &nagiosRange{start: 0, end: math.Inf(1)}
+	spec := value
+	if strings.HasPrefix(spec, "@") {
+		r.inside, spec = true, spec[1:]
+	}
+	bounds := strings.SplitN(spec, ":", 2)
+	if len(bounds) == 1 {
+		bounds = []string{"0", bounds[0]}
+	}
+	var err error
+	switch bounds[0] {
+	case "~":
+		r.start = math.Inf(-1)
+	case "":
+	default:
+		if r.start, err = strconv.ParseFloat(bounds[0], 64); err != nil {
+			return nil, fmt.Errorf("invalid threshold [%s]", value)
+		}
+	}
+	if len(bounds[1]) > 0 {
+		if r.end, err = strconv.ParseFloat(bounds[1], 64); err != nil {
+			return nil, fmt.Errorf("invalid threshold [%s]", value)
+		}
+	}
+	if r.start > r.end {
+		return nil, fmt.Errorf("invalid threshold [%s], start is greater than end", value)
+	}
+	return r, nil
+}
+
+// alert reports whether value is outside the range, or inside it for ranges starting with "@".
+func (r *nagiosRange) alert(value float64) bool {
+	if r == nil {
+		return false
+	}
+	outside := value < r.start || value > r.end
+	return outside != r.inside
+}
+
+// PerfData is a single performance data value of a check, formatted as 'label'=value[UOM];[warn];[crit];[min];[max].
+type PerfData struct {
+	Label    string
+	Value    float64
+	Unit     string   `json:",omitempty"`
+	Warning  string   `json:",omitempty"`
+	Critical string   `json:",omitempty"`
+	Min      *float64 `json:",omitempty"`
+	Max      *float64 `json:",omitempty"`
+}
+
+func (p *PerfData) String() string {
+	optional := func(value *float64) string {
+		if value == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*value, 'f', -1, 64)
+	}
+	label := p.Label
+	if strings.ContainsAny(label, " '=") {
+		label = "'" + strings.Replace(label, "'", "''", -1) + "'"
+	}
+	return strings.TrimRight(fmt.Sprintf("%s=%s%s;%s;%s;%s;%s", label, strconv.FormatFloat(p.Value, 'f', -1, 64), p.Unit,
+		p.Warning, p.Critical, optional(p.Min), optional(p.Max)), ";")
+}
+
+func float(value float64) *float64 {
+	return &value
+}
+
+// CheckResult is the outcome of a check, Output is the complete plugin output line.
+type CheckResult struct {
+	Check    string
+	Status   string
+	Code     int
+	Message  string
+	PerfData []*PerfData
+	Output   string
+}
+
+// checkInput are the thresholds and options of a check run.
+type checkInput struct {
+	warning  []*nagiosRange
+	critical []*nagiosRange
+	warn     []string
+	crit     []string
+	options  map[string]string
+	self     int // pid of the process running the check, 0 if it is served over HTTP
+}
+
+// threshold returns the i-th of a comma separated list of thresholds, the last one applies to the rest.
+func (in *checkInput) threshold(i int) (warning, critical *nagiosRange, warn, crit string) {
+	pick := func(ranges []*nagiosRange, specs []string) (*nagiosRange, string) {
+		if len(ranges) == 0 {
+			return nil, ""
+		}
+		j := i
+		if j >= len(ranges) {
+			j = len(ranges) - 1
+		}
+		return ranges[j], specs[j]
+	}
+	warning, warn = pick(in.warning, in.warn)
+	critical, crit = pick(in.critical, in.crit)
+	return
+}
+
+func (in *checkInput) state(i int, value float64) int {
+	warning, critical, _, _ := in.threshold(i)
+	switch {
+	case critical.alert(value):
+		return CheckCritical
+	case warning.alert(value):
+		return CheckWarning
+	}
+	return CheckOK
+}
+
+type check struct {
+	Name     string
+	Warning  string
+	Critical string
+	Options  []string
+	Run      func(in *checkInput) (state int, message string, perfData []*PerfData, err error)
+}
+
+// checks are keyed by the collector they evaluate.
+var checks = map[string]*check{
+	"cpu":  {"LOAD", "1", "2", nil, checkLoad},
+	"mem":  {"MEM", "80", "90", nil, checkMem},
+	"disk": {"DISK", "80", "90", []string{"mount"}, checkDisk},
+	"top":  {"PROCS", "400", "800", []string{"command"}, checkProcs},
+}
+
+// checkLoad compares the load averages divided by the number of processors,
+// thresholds can be given as a list for the 1, 5 and 15 minute averages like "2,1.5,1".
+func checkLoad(in *checkInput) (int, string, []*PerfData, error) {
+	cpu, err := cpuV2()
+	if err != nil {
+		return CheckUnknown, "", nil, err
+	}
+	processors := float64(cpu.Processors)
+	if processors < 1 {
+		processors = 1
+	}
+	state := CheckOK
+	var perfData []*PerfData
+	loads := []float64{cpu.Load1 / processors, cpu.Load5 / processors, cpu.Load15 / processors}
+	for i, load := range loads {
+		if s := in.state(i, load); s > state {
+			state = s
+		}
+		_, _, warn, crit := in.threshold(i)
+		label := []string{"load1", "load5", "load15"}[i]
+		perfData = append(perfData, &PerfData{Label: label, Value: math.Round(load*1000) / 1000, Warning: warn, Critical: crit, Min: float(0)})
+	}
+	return state, fmt.Sprintf("load average per CPU: %.2f, %.2f, %.2f", loads[0], loads[1], loads[2]), perfData, nil
+}
+
+// checkMem compares the percentage of RAM in use, memory the kernel could reclaim counts as available.
+func checkMem(in *checkInput) (int, string, []*PerfData, error) {
+	memory, err := memV2()
+	if err != nil {
+		return CheckUnknown, "", nil, err
+	}
+	ram := memory.RAM
+	if ram.TotalBytes == 0 {
+		return CheckUnknown, "", nil, fmt.Errorf("total memory is unknown")
+	}
+	used := ram.TotalBytes - ram.AvailableBytes
+	percent := float64(used) / float64(ram.TotalBytes) * 100
+	_, _, warn, crit := in.threshold(0)
+	perfData := []*PerfData{
+		{Label: "ram", Value: math.Round(percent*10) / 10, Unit: "%", Warning: warn, Critical: crit, Min: float(0), Max: float(100)},
+		{Label: "ram_used", Value: float64(used), Unit: "B", Min: float(0), Max: float(float64(ram.TotalBytes))},
+		{Label: "swap_used", Value: float64(memory.Swap.UsedBytes), Unit: "B", Min: float(0), Max: float(float64(memory.Swap.TotalBytes))},
+	}
+	message := fmt.Sprintf("%.1f%% used (%s of %s)", percent, humanBytes(used), humanBytes(ram.TotalBytes))
+	return in.state(0, percent), message, perfData, nil
+}
+
+// checkDisk compares the usage percentage of every filesystem, or only the one mounted at the "mount" option.
+func checkDisk(in *checkInput) (int, string, []*PerfData, error) {
+	disks, err := dfV2()
+	if _, partial := err.(Warnings); err != nil && !partial {
+		return CheckUnknown, "", nil, err
+	}
+	if mount := in.options["mount"]; len(mount) > 0 {
+		var selected []*DiskUsageV2
+		for _, disk := range disks {
+			if disk.MountedOn == mount {
+				selected = append(selected, disk)
+			}
+		}
+		if len(selected) == 0 {
+			return CheckUnknown, "", nil, fmt.Errorf("no filesystem mounted at [%s]", mount)
+		}
+		disks = selected
+	}
+	if len(disks) == 0 {
+		return CheckUnknown, "", nil, fmt.Errorf("no filesystems found")
+	}
+
+	state := CheckOK
+	var alerts []string
+	var perfData []*PerfData
+	_, _, warn, crit := in.threshold(0)
+	highest := disks[0]
+	for _, disk := range disks {
+		s := in.state(0, float64(disk.UsagePercentage))
+		if s > state {
+			state = s
+		}
+		if s != CheckOK {
+			alerts = append(alerts, fmt.Sprintf("%s %d%% used", disk.MountedOn, disk.UsagePercentage))
+		}
+		if disk.UsagePercentage > highest.UsagePercentage {
+			highest = disk
+		}
+		perfData = append(perfData, &PerfData{Label: disk.MountedOn, Value: float64(disk.UsagePercentage), Unit: "%",
+			Warning: warn, Critical: crit, Min: float(0), Max: float(100)})
+	}
+	if len(alerts) > 0 {
+		return state, strings.Join(alerts, ", "), perfData, nil
+	}
+	return state, fmt.Sprintf("%d filesystems, highest %s %d%% used", len(disks), highest.MountedOn, highest.UsagePercentage), perfData, nil
+}
+
+// checkProcs compares the number of processes, or only of those whose command line contains the "command" option.
+// A critical threshold of "1:" alerts if no matching process is running. "dashboard check" doesn't count itself, as its
+// command line contains the option, a running dashboard is counted whether it serves the check or not.
+func checkProcs(in *checkInput) (int, string, []*PerfData, error) {
+	top, err := topV2()
+	if err != nil {
+		return CheckUnknown, "", nil, err
+	}
+	command := in.options["command"]
+	count := 0
+	for _, process := range top.Processes {
+		if process.Pid == in.self {
+			continue
+		}
+		if len(command) == 0 || strings.Contains(process.Command, command) {
+			count++
+		}
+	}
+	_, _, warn, crit := in.threshold(0)
+	perfData := []*PerfData{{Label: "procs", Value: float64(count), Warning: warn, Critical: crit, Min: float(0)}}
+	message := fmt.Sprintf("%d processes", count)
+	if len(command) > 0 {
+		message = fmt.Sprintf("%d processes matching [%s]", count, command)
+	}
+	return in.state(0, float64(count)), message, perfData, nil
+}
+
+// runCheck evaluates the check of a collector, problems with the check itself are reported as UNKNOWN.
+// Empty thresholds fall back to the defaults of the check, self is the pid of a command line check or 0.
+func runCheck(c *check, warning, critical string, options map[string]string, self int) *CheckResult {
+	result := &CheckResult{Check: c.Name}
+	finish := func(state int, message string) *CheckResult {
+		result.Code, result.Status, result.Message = state, checkStates[state], message
+		result.Output = fmt.Sprintf("%s %s - %s", c.Name, result.Status, message)
+		if len(result.PerfData) > 0 {
+			var values []string
+			for _, p := range result.PerfData {
+				values = append(values, p.String())
+			}
+			result.Output += " | " + strings.Join(values, " ")
+		}
+		return result
+	}
+
+	if len(warning) == 0 {
+		warning = c.Warning
+	}
+	if len(critical) == 0 {
+		critical = c.Critical
+	}
+	in := &checkInput{options: options, self: self}
+	for _, threshold := range []struct {
+		spec   string
+		ranges *[]*nagiosRange
+		specs  *[]string
+	}{{warning, &in.warning, &in.warn}, {critical, &in.critical, &in.crit}} {
+		for _, spec := range strings.Split(threshold.spec, ",") {
+			r, err := parseRange(trim(spec))
+			if err != nil {
+				return finish(CheckUnknown, err.Error())
+			}
+			*threshold.ranges = append(*threshold.ranges, r)
+			*threshold.specs = append(*threshold.specs, trim(spec))
+		}
+	}
+
+	state, message, perfData, err := c.Run(in)
+	if err != nil {
+		return finish(CheckUnknown, err.Error())
+	}
+	result.PerfData = perfData
+	return finish(state, message)
+}
+
+func checkNames() (names []string) {
+	for _, endpoint := range v1Endpoints {
+		if _, ok := checks[endpoint.Method]; ok {
+			names = append(names, endpoint.Path)
+		}
+	}
+	return names
+}
+
+// CheckHandler serves /api/check/:name, the plugin output line with ?format=text and otherwise the result as JSON.
+// The status is always 200, the state of the check is in the body and the X-Check-Status header.
+func CheckHandler(params martini.Params, req *http.Request, r render.Render, p *permissions, id *Identity) {
+	method, _ := collectorMethod(params["name"])
+	c, ok := checks[method]
+	if !ok {
+		apiError(r, http.StatusNotFound, ErrNotFound, params["name"], fmt.Errorf("no check for [%s], one of: %s", params["name"], strings.Join(checkNames(), ", ")))
+		return
+	}
+	if !p.Collector(id.Role, method) {
+		forbidden(r, id, method, method)
+		return
+	}
+	format, err := negotiateFormat(req)
+	if err != nil {
+		apiError(r, http.StatusNotAcceptable, ErrNotAcceptable, method, err)
+		return
+	}
+
+	query := req.URL.Query()
+	options := make(map[string]string)
+	for _, option := range c.Options {
+		options[option] = query.Get(option)
+	}
+	result := runCheck(c, query.Get("warning"), query.Get("critical"), options, 0)
+
+	r.Header().Set("X-Check-Status", result.Status)
+	if format == "text" {
+		r.Header().Add("Vary", "Accept")
+		r.Header().Set("Content-Type", formats[format])
+		r.Data(http.StatusOK, []byte(result.Output+"\n"))
+		return
+	}
+	renderFormat(r, format, result)
+}
+
+// checkCommand implements "dashboard check <collector>", which prints the plugin output and exits with the state.
+func checkCommand(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	warning := flags.String("w", "", "warning threshold in Nagios range format")
+	critical := flags.String("c", "", "critical threshold in Nagios range format")
+	mount := flags.String("mount", "", "disk: only check the filesystem mounted here")
+	command := flags.String("command", "", "processes: only count processes whose command line contains this")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return CheckUnknown
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(stdout, "UNKNOWN - usage: dashboard check "+commands["check"].Args)
+		return CheckUnknown
+	}
+	method, _ := collectorMethod(positional[0])
+	c, ok := checks[method]
+	if !ok {
+		fmt.Fprintf(stdout, "UNKNOWN - no check for [%s], one of: %s\n", positional[0], strings.Join(checkNames(), ", "))
+		return CheckUnknown
+	}
+
+	result := runCheck(c, *warning, *critical, map[string]string{"mount": *mount, "command": *command}, os.Getpid())
+	fmt.Fprintln(stdout, result.Output)
+	return result.Code
+}
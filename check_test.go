@@ -0,0 +1,172 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_todoapp_check_parseRange(t *testing.T) {
+	tests := []struct {
+		Range  string
+		Value  float64
+		Alerts bool
+	}{
+		{"10", 5, false},
+		{"10", 11, true},
+		{"10", -1, true},
+		{"10:", 5, true},
+		{"10:", 15, false},
+		{"~:10", -100, false},
+		{"~:10", 11, true},
+		{"10:20", 15, false},
+		{"10:20", 21, true},
+		{"@10:20", 15, true},
+		{"@10:20", 5, false},
+		{"1:", 0, true},
+		{"1:", 1, false},
+	}
+	for _, test := range tests {
+		r, err := parseRange(test.Range)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.alert(test.Value) != test.Alerts {
+			t.Errorf("Expected range [%s] alerting on [%v] to be [%v]", test.Range, test.Value, test.Alerts)
+		}
+	}
+
+	r, err := parseRange("")
+	Expect(t, r == nil, true)
+	Expect(t, err, nil)
+	Expect(t, r.alert(100), false)
+
+	for _, invalid := range []string{"x", "10:x", "20:10"} {
+		_, err := parseRange(invalid)
+		NotExpect(t, err, nil)
+	}
+}
+
+func Test_todoapp_check_PerfData(t *testing.T) {
+	Expect(t, (&PerfData{Label: "load1", Value: 0.5, Warning: "1", Critical: "2", Min: float(0)}).String(), "load1=0.5;1;2;0")
+	Expect(t, (&PerfData{Label: "/", Value: 18, Unit: "%", Warning: "80", Critical: "90", Min: float(0), Max: float(100)}).String(), "/=18%;80;90;0;100")
+	Expect(t, (&PerfData{Label: "procs", Value: 3}).String(), "procs=3")
+	Expect(t, (&PerfData{Label: "it's full", Value: 1}).String(), "'it''s full'=1")
+}
+
+func Test_todoapp_check_runCheck(t *testing.T) {
+	var value float64
+	c := &check{"TEST", "10", "20", nil, func(in *checkInput) (int, string, []*PerfData, error) {
+		_, _, warn, crit := in.threshold(0)
+		return in.state(0, value), fmt.Sprintf("value %v", value), []*PerfData{{Label: "value", Value: value, Warning: warn, Critical: crit}}, nil
+	}}
+
+	value = 5
+	result := runCheck(c, "", "", nil, 0)
+	Expect(t, result.Code, CheckOK)
+	Expect(t, result.Output, "TEST OK - value 5 | value=5;10;20")
+
+	value = 15
+	result = runCheck(c, "", "", nil, 0)
+	Expect(t, result.Code, CheckWarning)
+	Expect(t, result.Status, "WARNING")
+
+	value = 25
+	Expect(t, runCheck(c, "", "", nil, 0).Code, CheckCritical)
+	Expect(t, runCheck(c, "30", "40", nil, 0).Code, CheckOK)
+
+	result = runCheck(c, "abc", "", nil, 0)
+	Expect(t, result.Code, CheckUnknown)
+	Expect(t, result.Output, "TEST UNKNOWN - invalid threshold [abc]")
+
+	// a list of thresholds applies in order, the last one to the rest
+	in := &checkInput{}
+	for _, spec := range []string{"3", "2"} {
+		r, _ := parseRange(spec)
+		in.warning, in.warn = append(in.warning, r), append(in.warn, spec)
+	}
+	Expect(t, in.state(0, 2.5), CheckOK)
+	Expect(t, in.state(1, 2.5), CheckWarning)
+	Expect(t, in.state(2, 2.5), CheckWarning)
+}
+
+func Test_todoapp_api_Check(t *testing.T) {
+	var result CheckResult
+	response := getV2(t, "/api/check/disk?warning=100&critical=100", &result)
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, response.Header().Get("X-Check-Status"), "OK")
+	Expect(t, result.Check, "DISK")
+	Expect(t, result.Code, CheckOK)
+	Contain(t, result.Output, "DISK OK - ")
+	NotExpect(t, len(result.PerfData), 0)
+
+	response = getV2(t, "/api/check/processes?critical=@0:100000&format=text", nil)
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, response.Header().Get("Content-Type"), "text/plain; charset=UTF-8")
+	Expect(t, response.Header().Get("X-Check-Status"), "CRITICAL")
+	Contain(t, response.Body.String(), "PROCS CRITICAL - ")
+	Contain(t, response.Body.String(), "| procs=")
+
+	response = getV2(t, "/api/check/cpu?warning=x", &result)
+	Expect(t, response.Header().Get("X-Check-Status"), "UNKNOWN")
+	Expect(t, result.Code, CheckUnknown)
+
+	response = getV2(t, "/api/check/env", nil)
+	Expect(t, response.Code, http.StatusNotFound)
+	Contain(t, response.Body.String(), "no check for [env], one of: cpu, mem, disk, processes")
+}
+
+func Test_todoapp_api_Check_Forbidden(t *testing.T) {
+	os.Setenv("DASHBOARD_PERMISSIONS", "collector:disk=operator")
+	defer os.Unsetenv("DASHBOARD_PERMISSIONS")
+	m, cleanup := setupAuthMartini(t)
+	defer cleanup()
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/api/check/disk", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer s3cr3t-t0k3n")
+
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusForbidden)
+	Contain(t, response.Body.String(), `is not allowed to access [disk]`)
+}
+
+func Test_todoapp_check_Self(t *testing.T) {
+	// the test binary stands in for the dashboard, it is counted when serving the check but not when running it
+	self := filepath.Base(os.Args[0])
+	var result CheckResult
+	getV2(t, "/api/check/processes?command="+self+"&critical=1:", &result)
+	Expect(t, result.Code, CheckOK)
+
+	code, out, _ := runCLI("check", "processes", "-command", self, "-c", "1:")
+	Expect(t, code, CheckCritical)
+	Contain(t, out, "PROCS CRITICAL - 0 processes matching ["+self+"]")
+}
+
+func Test_todoapp_cli_Check(t *testing.T) {
+	code, out, _ := runCLI("check", "disk", "-w", "100", "-c", "100")
+	Expect(t, code, CheckOK)
+	Contain(t, out, "DISK OK - ")
+
+	code, out, _ = runCLI("check", "processes", "-command", "no-such-process-anywhere", "-c", "1:")
+	Expect(t, code, CheckCritical)
+	Contain(t, out, "PROCS CRITICAL - 0 processes matching [no-such-process-anywhere]")
+
+	code, out, _ = runCLI("check", "mem", "-w", "x")
+	Expect(t, code, CheckUnknown)
+	Contain(t, out, "MEM UNKNOWN - invalid threshold [x]")
+
+	code, out, _ = runCLI("check", "bogus")
+	Expect(t, code, CheckUnknown)
+	Contain(t, out, "UNKNOWN - no check for [bogus]")
+}
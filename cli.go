@@ -0,0 +1,216 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// stdout and stderr of the subcommands, replaced in tests.
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+type command struct {
+	Args string
+	Help string
+	Run  func(args []string) int
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"serve":    {"", "start the web server, the default without a command", serveCommand},
+		"check":    {"<collector> [-w range] [-c range] [-mount m] [-command c]", "Nagios plugin check of cpu, mem, disk or processes", checkCommand},
+		"get":      {"<collector> [-format f] [-v2] [-reveal]", "print a collector", getCommand},
+		"snapshot": {"[-collectors c,...] [-format f] [-save]", "print a snapshot or store it in DASHBOARD_SNAPSHOT_DIR", snapshotCommand},
+		"report":   {"[-o file]", "write the HTML report", reportCommand},
+		"tui":      {"[-url u] [-token t] [-insecure] [-interval d]", "full-screen terminal view of this host or a remote dashboard", tuiCommand},
+		"help":     {"", "show this help", helpCommand},
+	}
+}
+
+// run dispatches to a subcommand and returns its exit code, without arguments the web server is started.
+func run(args []string) int {
+	if len(args) == 0 {
+		return serveCommand(nil)
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command [%s]\n\n", args[0])
+		helpCommand(nil)
+		return 2
+	}
+	return cmd.Run(args[1:])
+}
+
+// parseArgs parses flags given before, between or after the positional arguments.
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// collectorMethod resolves a collector by its API path or its method name, e.g. "processes" or "top".
+func collectorMethod(name string) (string, bool) {
+	for _, endpoint := range v1Endpoints {
+		if endpoint.Path == name || endpoint.Method == name {
+			return endpoint.Method, endpoint.Method != "headers"
+		}
+	}
+	return "", false
+}
+
+func collectorNames() (names []string) {
+	for _, endpoint := range v1Endpoints {
+		if endpoint.Method != "headers" {
+			names = append(names, endpoint.Path)
+		}
+	}
+	return names
+}
+
+func helpCommand([]string) int {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(stderr, "usage: dashboard <command> [flags]")
+	fmt.Fprintln(stderr)
+	w := tabwriter.NewWriter(stderr, 0, 8, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s %s\t%s\n", name, commands[name].Args, commands[name].Help)
+	}
+	w.Flush()
+	fmt.Fprintln(stderr)
+	fmt.Fprintln(stderr, "collectors: "+strings.Join(collectorNames(), ", "))
+	return 0
+}
+
+func serveCommand(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	serve(setupMartini())
+	return 0
+}
+
+// getCommand prints a single collector, the same output as /api/<collector> or /api/v2/<collector>.
+func getCommand(args []string) int {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "json", "output format: json, csv, tsv, yaml, ndjson or text")
+	v2 := flags.Bool("v2", false, "print the /api/v2 payload")
+	reveal := flags.Bool("reveal", false, "do not redact secrets")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(stderr, "usage: dashboard get "+commands["get"].Args)
+		return 2
+	}
+	method, ok := collectorMethod(positional[0])
+	if !ok {
+		fmt.Fprintf(stderr, "unknown collector [%s], one of: %s\n", positional[0], strings.Join(collectorNames(), ", "))
+		return 2
+	}
+	f, err := parseFormat(*format)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	collect := data
+	if *v2 {
+		collect = dataV2
+	}
+	payload, err := collect(method)
+	warnings, err := collectorError(err)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	for _, warning := range warnings {
+		fmt.Fprintln(stderr, "warning: "+warning)
+	}
+	if !*reveal {
+		payload = setupRedactor().Redact(payload)
+	}
+	return printFormat(f, payload)
+}
+
+// snapshotCommand prints a snapshot of the chosen collectors, or stores it in DASHBOARD_SNAPSHOT_DIR for later diffs.
+func snapshotCommand(args []string) int {
+	flags := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	collectors := flags.String("collectors", strings.Join(snapshotCollectors, ","), "comma separated collectors to include")
+	format := flags.String("format", "json", "output format: json, csv, tsv, yaml, ndjson or text")
+	save := flags.Bool("save", false, "store the snapshot in DASHBOARD_SNAPSHOT_DIR instead of printing it")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	f, err := parseFormat(*format)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	var methods []string
+	for _, name := range strings.Split(*collectors, ",") {
+		method, ok := collectorMethod(trim(name))
+		if !ok || !containsString(snapshotCollectors, method) {
+			fmt.Fprintf(stderr, "collector [%s] is not part of snapshots, one of: %s\n", trim(name), strings.Join(snapshotCollectors, ", "))
+			return 2
+		}
+		methods = append(methods, method)
+	}
+
+	rd := setupRedactor()
+	snapshot := collectSnapshot(rd, methods)
+	if *save {
+		if len(os.Getenv("DASHBOARD_SNAPSHOT_DIR")) == 0 {
+			fmt.Fprintln(stderr, "DASHBOARD_SNAPSHOT_DIR must be set to store snapshots")
+			return 2
+		}
+		if err := setupSnapshots(rd).Save(snapshot); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		fmt.Fprintln(stdout, snapshot.ID)
+		return 0
+	}
+	return printFormat(f, snapshot)
+}
+
+func printFormat(format string, data interface{}) int {
+	out, err := encodeFormat(format, data)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	stdout.Write(out)
+	return 0
+}
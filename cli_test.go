@@ -0,0 +1,111 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func runCLI(args ...string) (int, string, string) {
+	var out, errOut bytes.Buffer
+	stdout, stderr = &out, &errOut
+	defer func() {
+		stdout, stderr = os.Stdout, os.Stderr
+	}()
+	code := run(args)
+	return code, out.String(), errOut.String()
+}
+
+func Test_todoapp_cli_Help(t *testing.T) {
+	code, _, errOut := runCLI("help")
+	Expect(t, code, 0)
+	Contain(t, errOut, "usage: dashboard <command> [flags]")
+	Contain(t, errOut, "snapshot [-collectors c,...]")
+	Contain(t, errOut, "collectors: hostname, ip, addresses, cpu")
+
+	code, _, errOut = runCLI("bogus")
+	Expect(t, code, 2)
+	Contain(t, errOut, "unknown command [bogus]")
+}
+
+func Test_todoapp_cli_Get(t *testing.T) {
+	code, out, _ := runCLI("get", "cpu")
+	Expect(t, code, 0)
+	var cpu CPU
+	if err := json.Unmarshal([]byte(out), &cpu); err != nil {
+		t.Fatal(err)
+	}
+	NotExpect(t, cpu.Processors, 0)
+
+	// flags may follow the collector, which can be given by path or method
+	code, out, _ = runCLI("get", "processes", "-v2", "--format", "csv")
+	Expect(t, code, 0)
+	Contain(t, out, "User,Pid,CpuPercent,MemPercent,VszBytes,RssBytes")
+	code, _, _ = runCLI("get", "top", "-format=text")
+	Expect(t, code, 0)
+
+	code, _, errOut := runCLI("get", "headers")
+	Expect(t, code, 2)
+	Contain(t, errOut, "unknown collector [headers]")
+
+	code, _, errOut = runCLI("get", "cpu", "-format", "xml")
+	Expect(t, code, 2)
+	Contain(t, errOut, "unsupported format [xml]")
+
+	code, _, _ = runCLI("get")
+	Expect(t, code, 2)
+}
+
+func Test_todoapp_cli_Get_Redacted(t *testing.T) {
+	os.Setenv("DASHBOARD_TEST_SECRET", "hunter2")
+	defer os.Unsetenv("DASHBOARD_TEST_SECRET")
+
+	_, out, _ := runCLI("get", "env", "-format", "tsv")
+	Contain(t, out, "DASHBOARD_TEST_SECRET\t")
+	NotContain(t, out, "hunter2")
+
+	_, out, _ = runCLI("get", "env", "-format", "tsv", "-reveal")
+	Contain(t, out, "DASHBOARD_TEST_SECRET\thunter2")
+}
+
+func Test_todoapp_cli_Snapshot(t *testing.T) {
+	code, out, _ := runCLI("snapshot", "--collectors", "cpu,mem", "--format", "json")
+	Expect(t, code, 0)
+	var snapshot Snapshot
+	if err := json.Unmarshal([]byte(out), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, snapshot.Hostname, currentHostname)
+	Expect(t, snapshot.CPU != nil, true)
+	Expect(t, snapshot.Disks == nil, true)
+
+	code, _, errOut := runCLI("snapshot", "--collectors", "cpu,hostname")
+	Expect(t, code, 2)
+	Contain(t, errOut, "collector [hostname] is not part of snapshots")
+
+	code, _, errOut = runCLI("snapshot", "-save")
+	Expect(t, code, 2)
+	Contain(t, errOut, "DASHBOARD_SNAPSHOT_DIR must be set")
+
+	dir, err := ioutil.TempDir("", "dashboard-snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("DASHBOARD_SNAPSHOT_DIR", dir)
+	defer os.Unsetenv("DASHBOARD_SNAPSHOT_DIR")
+
+	code, out, _ = runCLI("snapshot", "-collectors", "disk", "-save")
+	Expect(t, code, 0)
+	stored, err := setupSnapshots(setupRedactor()).Load(Trim(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	NotExpect(t, len(stored.Disks), 0)
+}
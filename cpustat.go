@@ -0,0 +1,168 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var cpuStatSampler = newSampler(func() (interface{}, error) {
+	content, err := ioutil.ReadFile(procDir + "/stat")
+	if err != nil {
+		return nil, err
+	}
+	return parseProcStat(string(content))
+})
+
+// CPUUsage is the share of time a CPU spent in each state, in percent. Guest time is included in User,
+// Busy is everything but Idle and IOWait.
+type CPUUsage struct {
+	Name    string
+	User    float64
+	Nice    float64
+	System  float64
+	IOWait  float64
+	IRQ     float64
+	SoftIRQ float64
+	Steal   float64
+	Idle    float64
+	Busy    float64
+}
+
+type CPUStat struct {
+	IntervalSeconds           float64
+	Total                     *CPUUsage
+	Cores                     []*CPUUsage
+	ContextSwitchesPerSecond  float64
+	InterruptsPerSecond       float64
+	ProcessesCreatedPerSecond float64
+	RunningProcesses          int
+	BlockedProcesses          int
+}
+
+// cpuTicks are the cumulative times of a "cpu" line in /proc/stat, in clock ticks.
+type cpuTicks struct {
+	name                                                  string
+	user, nice, system, idle, iowait, irq, softirq, steal uint64
+}
+
+func (t *cpuTicks) total() uint64 {
+	return t.user + t.nice + t.system + t.idle + t.iowait + t.irq + t.softirq + t.steal
+}
+
+type procStat struct {
+	cpus             []*cpuTicks
+	contextSwitches  uint64
+	interrupts       uint64
+	processes        uint64
+	running, blocked int
+}
+
+func parseProcStat(content string) (*procStat, error) {
+	stat := &procStat{}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024) // the intr line lists every interrupt
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, _ := strconv.ParseUint(fields[1], 10, 64)
+		switch {
+		case strings.HasPrefix(fields[0], "cpu"):
+			if len(fields) < 5 {
+				return nil, fmt.Errorf("invalid /proc/stat line [%s]", scanner.Text())
+			}
+			ticks := &cpuTicks{name: fields[0]}
+			for i, target := range []*uint64{&ticks.user, &ticks.nice, &ticks.system, &ticks.idle, &ticks.iowait, &ticks.irq, &ticks.softirq, &ticks.steal} {
+				if i+1 < len(fields) {
+					*target, _ = strconv.ParseUint(fields[i+1], 10, 64)
+				}
+			}
+			stat.cpus = append(stat.cpus, ticks)
+		case fields[0] == "ctxt":
+			stat.contextSwitches = value
+		case fields[0] == "intr":
+			stat.interrupts = value
+		case fields[0] == "processes":
+			stat.processes = value
+		case fields[0] == "procs_running":
+			stat.running = int(value)
+		case fields[0] == "procs_blocked":
+			stat.blocked = int(value)
+		}
+	}
+	if len(stat.cpus) == 0 {
+		return nil, fmt.Errorf("no cpu lines in /proc/stat")
+	}
+	return stat, scanner.Err()
+}
+
+func cpuUsage(previous, current *cpuTicks) *CPUUsage {
+	usage := &CPUUsage{Name: current.name}
+	elapsed := float64(current.total()) - float64(previous.total())
+	if elapsed <= 0 {
+		usage.Idle = 100
+		return usage
+	}
+	percent := func(previous, current uint64) float64 {
+		if current < previous {
+			return 0
+		}
+		return math.Round(float64(current-previous)/elapsed*1000) / 10
+	}
+	usage.User = percent(previous.user, current.user)
+	usage.Nice = percent(previous.nice, current.nice)
+	usage.System = percent(previous.system, current.system)
+	usage.IOWait = percent(previous.iowait, current.iowait)
+	usage.IRQ = percent(previous.irq, current.irq)
+	usage.SoftIRQ = percent(previous.softirq, current.softirq)
+	usage.Steal = percent(previous.steal, current.steal)
+	usage.Idle = percent(previous.idle, current.idle)
+	usage.Busy = math.Round((100-usage.Idle-usage.IOWait)*10) / 10
+	return usage
+}
+
+func cpuStatBetween(previous, current *procStat, seconds float64) *CPUStat {
+	stat := &CPUStat{
+		IntervalSeconds:           math.Round(seconds*1000) / 1000,
+		ContextSwitchesPerSecond:  math.Round(rate(previous.contextSwitches, current.contextSwitches, seconds)),
+		InterruptsPerSecond:       math.Round(rate(previous.interrupts, current.interrupts, seconds)),
+		ProcessesCreatedPerSecond: math.Round(rate(previous.processes, current.processes, seconds)*10) / 10,
+		RunningProcesses:          current.running,
+		BlockedProcesses:          current.blocked,
+		Cores:                     []*CPUUsage{},
+	}
+	before := make(map[string]*cpuTicks)
+	for _, ticks := range previous.cpus {
+		before[ticks.name] = ticks
+	}
+	for _, ticks := range current.cpus {
+		old, ok := before[ticks.name]
+		if !ok { // hotplugged since the previous reading
+			old = ticks
+		}
+		if ticks.name == "cpu" {
+			stat.Total = cpuUsage(old, ticks)
+		} else {
+			stat.Cores = append(stat.Cores, cpuUsage(old, ticks))
+		}
+	}
+	return stat
+}
+
+// cpuStat samples /proc/stat, usage and rates cover the time since the previous call.
+func cpuStat() (*CPUStat, error) {
+	previous, current, seconds, err := cpuStatSampler.sample()
+	if err != nil {
+		return nil, err
+	}
+	return cpuStatBetween(previous.(*procStat), current.(*procStat), seconds), nil
+}
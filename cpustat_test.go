@@ -0,0 +1,169 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const procStatBefore = `cpu  1000 0 500 8000 300 100 100 0 0 0
+cpu0 500 0 250 4000 150 50 50 0 0 0
+cpu1 500 0 250 4000 150 50 50 0 0 0
+intr 50000 10 20 30
+ctxt 100000
+btime 1700000000
+processes 2000
+procs_running 2
+procs_blocked 0
+softirq 1000 1 2 3
+`
+
+const procStatAfter = `cpu  1300 0 600 8400 400 100 200 0 0 0
+cpu0 900 0 100 4150 150 50 50 0 0 0
+cpu1 500 0 250 4000 150 50 50 0 0 0
+cpu2 100 0 100 700 100 0 0 0 0 0
+intr 52000 10 20 30
+ctxt 104000
+btime 1700000000
+processes 2010
+procs_running 3
+procs_blocked 1
+softirq 1100 1 2 3
+`
+
+func Test_todoapp_cpustat_parseProcStat(t *testing.T) {
+	stat, err := parseProcStat(procStatBefore)
+	Expect(t, err, nil)
+	Expect(t, len(stat.cpus), 3)
+	Expect(t, stat.cpus[0].name, "cpu")
+	Expect(t, stat.cpus[1].user, uint64(500))
+	Expect(t, stat.cpus[1].iowait, uint64(150))
+	Expect(t, stat.cpus[0].total(), uint64(10000))
+	Expect(t, stat.contextSwitches, uint64(100000))
+	Expect(t, stat.interrupts, uint64(50000))
+	Expect(t, stat.running, 2)
+
+	_, err = parseProcStat("ctxt 100\n")
+	NotExpect(t, err, nil)
+	_, err = parseProcStat("cpu 1 2\n")
+	NotExpect(t, err, nil)
+}
+
+func Test_todoapp_cpustat_cpuStatBetween(t *testing.T) {
+	before, _ := parseProcStat(procStatBefore)
+	after, _ := parseProcStat(procStatAfter)
+	stat := cpuStatBetween(before, after, 2)
+
+	Expect(t, stat.Total.Name, "cpu")
+	Expect(t, stat.Total.User, 30.0)
+	Expect(t, stat.Total.System, 10.0)
+	Expect(t, stat.Total.IOWait, 10.0)
+	Expect(t, stat.Total.SoftIRQ, 10.0)
+	Expect(t, stat.Total.Idle, 40.0)
+	Expect(t, stat.Total.Busy, 50.0)
+	Expect(t, stat.ContextSwitchesPerSecond, 2000.0)
+	Expect(t, stat.InterruptsPerSecond, 1000.0)
+	Expect(t, stat.ProcessesCreatedPerSecond, 5.0)
+	Expect(t, stat.RunningProcesses, 3)
+	Expect(t, stat.BlockedProcesses, 1)
+
+	Expect(t, len(stat.Cores), 3)
+	// counters going backwards count as 0
+	Expect(t, stat.Cores[0].User, 100.0)
+	Expect(t, stat.Cores[0].System, 0.0)
+	// no ticks since the previous reading, and a core hotplugged in between
+	Expect(t, stat.Cores[1].Idle, 100.0)
+	Expect(t, stat.Cores[2].Name, "cpu2")
+	Expect(t, stat.Cores[2].Idle, 100.0)
+}
+
+func Test_todoapp_sampler(t *testing.T) {
+	original := sampleInterval
+	defer func() {
+		sampleInterval = original
+	}()
+	sampleInterval = 10 * time.Millisecond
+
+	var counter uint64
+	var fail bool
+	s := newSampler(func() (interface{}, error) {
+		if fail {
+			return nil, errors.New("read failed")
+		}
+		counter += 10
+		return counter, nil
+	})
+
+	// the first sample reads twice, sampleInterval apart
+	previous, current, seconds, err := s.sample()
+	Expect(t, err, nil)
+	Expect(t, previous, uint64(10))
+	Expect(t, current, uint64(20))
+	Expect(t, seconds >= 0.01, true)
+
+	// later samples continue from the previous reading
+	time.Sleep(sampleInterval)
+	previous, current, _, _ = s.sample()
+	Expect(t, previous, uint64(20))
+	Expect(t, current, uint64(30))
+
+	fail = true
+	time.Sleep(sampleInterval)
+	_, _, _, err = s.sample()
+	NotExpect(t, err, nil)
+
+	Expect(t, rate(100, 300, 2), 100.0)
+	Expect(t, rate(300, 100, 2), 0.0)
+	Expect(t, rate(100, 300, 0), 0.0)
+}
+
+func Test_todoapp_sampler_BackToBack(t *testing.T) {
+	original := sampleInterval
+	defer func() {
+		sampleInterval = original
+	}()
+	sampleInterval = 50 * time.Millisecond
+
+	var counter uint64
+	s := newSampler(func() (interface{}, error) {
+		counter += 10
+		return counter, nil
+	})
+
+	// a second caller right after the first gets the same sample, instead of a rate over no time at all
+	first, firstCurrent, firstSeconds, _ := s.sample()
+	second, secondCurrent, secondSeconds, err := s.sample()
+	Expect(t, err, nil)
+	Expect(t, second, first)
+	Expect(t, secondCurrent, firstCurrent)
+	Expect(t, secondSeconds, firstSeconds)
+	Expect(t, secondSeconds >= 0.05, true)
+	Expect(t, counter, uint64(20))
+
+	// the baseline moves on once the window has passed
+	time.Sleep(sampleInterval)
+	previous, current, seconds, _ := s.sample()
+	Expect(t, previous, uint64(20))
+	Expect(t, current, uint64(30))
+	Expect(t, seconds >= 0.05, true)
+}
+
+func Test_todoapp_api_GetCPUUsage(t *testing.T) {
+	var data CPUStat
+	response := getV2(t, "/api/cpu_usage", &data)
+	Expect(t, response.Code, http.StatusOK)
+
+	Expect(t, data.Total != nil, true)
+	Expect(t, data.Total.Name, "cpu")
+	NotExpect(t, len(data.Cores), 0)
+	Expect(t, data.IntervalSeconds > 0, true)
+
+	response = getV2(t, "/api/v2/cpu_usage", &data)
+	Expect(t, response.Code, http.StatusOK)
+	Contain(t, response.Body.String(), `"ContextSwitchesPerSecond"`)
+}
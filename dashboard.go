@@ -19,6 +19,9 @@ var (
 	logPrefix          = "[dashboard] "
 	productionHostname = "jamesclonk.io"
 	currentHostname    = ""
+	version            = "dev" // set at build time with -ldflags "-X main.version=..."
+
+	errUnknownMethod = errors.New("unknown method")
 )
 
 type view struct {
@@ -44,24 +47,37 @@ func init() {
 }
 
 func main() {
-	m := setupMartini()
-	m.Run()
+	os.Exit(run(os.Args[1:]))
 }
 
 func setupMartini() *martini.Martini {
 	r := martini.NewRouter()
 	m := martini.New()
 	m.Use(martini.Recovery())
+	m.Use(RequestID())
 	m.Use(martini.Static("assets", martini.StaticOptions{SkipLogging: true})) // skip logging on static content
 	m.Use(martini.Logger())
 	m.Use(render.Renderer(render.Options{
 		Directory:  "templates",
 		Layout:     "layout",
 		Extensions: []string{".html"},
-		Delims:     render.Delims{"{[{", "}]}"},
+		Delims:     render.Delims{Left: "{[{", Right: "}]}"},
 		IndentJSON: true,
 	}))
 	m.Map(log.New(os.Stdout, logPrefix, logFlags))
+
+	roles, permissions := setupRoles()
+	m.Map(permissions)
+	rd := setupRedactor()
+	m.Map(rd)
+	m.Map(setupSnapshots(rd))
+	m.Map(setupFleet())
+
+	a := setupAuth(roles)
+	m.Map(a)
+	m.Use(Authentication(a))
+
+	m.MapTo(r, (*martini.Routes)(nil))
 	m.Action(r.Handle)
 
 	setupRoutes(r)
@@ -74,29 +90,98 @@ func setupRoutes(r martini.Router) {
 	r.Get("/", func(r render.Render) {
 		r.HTML(http.StatusOK, "index", View("Dashboard"))
 	})
-	r.NotFound(func(r render.Render) {
-		r.HTML(http.StatusNotFound, "404", View("404 - Not Found"))
-	})
+	r.NotFound(NotFoundHandler)
+
+	// login
+	r.Get("/login", LoginHandler)
+	r.Post("/login", LoginFormHandler)
+	r.Get("/logout", LogoutHandler)
+	r.Get("/login/oidc", OIDCLoginHandler)
+	r.Get("/login/oidc/callback", OIDCCallbackHandler)
 
 	// api
-	r.Get("/api/hostname", DataHandler("hostname"))
-	r.Get("/api/ip", DataHandler("ip"))
-	r.Get("/api/cpu", DataHandler("cpu"))
-	r.Get("/api/mem", DataHandler("mem"))
-	r.Get("/api/disk", DataHandler("disk"))
-	r.Get("/api/processes", DataHandler("top"))
-	r.Get("/api/logged_on", DataHandler("logged_on"))
-	r.Get("/api/users", DataHandler("passwd"))
-	r.Get("/api/network", DataHandler("network"))
-	r.Get("/api/env", DataHandler("env"))
-	r.Get("/api/headers", DataHandler("headers"))
+	for _, endpoint := range v1Endpoints {
+		r.Get("/api/"+endpoint.Path, DataHandler(endpoint.Method))
+	}
+	r.Get("/api/permissions", PermissionsHandler)
+	r.Get("/api/openapi.json", OpenAPIHandler)
+	r.Get("/api/bundle", BundleHandler)
+	r.Get("/report", ReportHandler)
+	r.Get("/api/check/:name", CheckHandler)
+	r.Get("/explorer", ExplorerHandler)
+	r.Get("/api/containers/:id", ContainerHandler)
+	r.Get("/containers/:id", ContainerPageHandler)
 
 	r.Get("/api/debug/:method", DebugHandler)
+
+	// snapshots
+	r.Get("/api/snapshots", SnapshotsHandler)
+	r.Post("/api/snapshots", TakeSnapshotHandler)
+	r.Get("/api/snapshots/:id", SnapshotHandler)
+	r.Get("/api/diff", DiffHandler)
+	r.Get("/diff", DiffPageHandler)
+
+	// api v2
+	for _, endpoint := range v2Endpoints {
+		r.Get("/api/v2/"+endpoint.Path, DataV2Handler(endpoint.Method))
+	}
+	r.Get("/api/v2/schemas", SchemasHandler)
+	r.Get("/api/v2/schemas/:name", SchemaHandler)
 }
 
-func DebugHandler(params martini.Params, r render.Render) {
+// apiEndpoint maps an API path to its collector and the type of its payload, which schemas are generated from.
+type apiEndpoint struct {
+	Path    string
+	Method  string
+	Payload interface{}
+	Summary string
+}
+
+var v1Endpoints = []apiEndpoint{
+	{"hostname", "hostname", Host{}, "Hostname of the machine"},
+	{"ip", "ip", []string{}, "IP addresses the hostname resolves to"},
+	{"addresses", "addresses", Addresses{}, "All interface addresses classified as loopback, link-local, private, ula or public with reverse DNS names, the primary address by default route and optionally the external address"},
+	{"cpu", "cpu", CPU{}, "CPU model, speed and load averages"},
+	{"cpu_usage", "cpustat", CPUStat{}, "Utilization by state overall and per core, context switches and interrupts per second"},
+	{"mem", "mem", Memory{}, "RAM and swap usage in megabytes and human readable form"},
+	{"cgroup", "cgroup", Cgroup{}, "cgroup v1 or v2 of the dashboard, usually its container: memory limit, usage and OOM kills, CPU quota, shares, usage and throttling, pids"},
+	{"disk", "disk", []*DiskUsage{}, "Filesystem usage as reported by df"},
+	{"disk_io", "diskio", []*DiskIO{}, "Operations and bytes per second, latency, queue depth and utilization of block devices and their mountpoints"},
+	{"processes", "top", Top{}, "top header and processes as reported by ps"},
+	{"cgroups", "cgroups", CgroupTree{}, "Tree of all cgroups with their tasks, CPU, memory and I/O usage and member processes, like systemd-cgtop"},
+	{"containers", "docker", DockerContainers{}, "Docker containers with state, restart count, port mappings and live CPU, memory, network and block I/O stats"},
+	{"images", "docker_images", []*DockerImage{}, "Docker images with tags, size and the number of containers using them"},
+	{"kubernetes", "kubernetes", Kubernetes{}, "Pod name, namespace, node, labels, annotations, resource requests and limits and service account from the downward API"},
+	{"pods", "kubernetes_pods", []*KubernetesPod{}, "Pods in the namespace of the dashboard from the in-cluster Kubernetes API, if enabled"},
+	{"routing", "routing", Routing{}, "Routing table, default gateways, ARP and NDP neighbours, DNS resolver configuration, /etc/hosts and nsswitch order"},
+	{"sockets", "sockets", Sockets{}, "Listening sockets and established connections with their owning process, and socket counts by state"},
+	{"logged_on", "logged_on", []*LoggedOn{}, "Logged on users as reported by w"},
+	{"users", "passwd", []*User{}, "Accounts from /etc/passwd"},
+	{"network", "network", []*If{}, "Addresses of all network interfaces"},
+	{"network_traffic", "netstat", []*InterfaceStat{}, "Throughput, errors and drops per second, link speed, MTU, MAC and state of all network interfaces"},
+	{"env", "env", []*Env{}, "Environment variables of the dashboard, secrets are redacted"},
+	{"headers", "headers", http.Header{}, "Headers of the request as received by the dashboard"},
+}
+
+func DebugHandler(params martini.Params, r render.Render, req *http.Request, p *permissions, rd *redactor, id *Identity) {
 	method := params["method"]
+	if !p.Action(id.Role, "debug") {
+		forbidden(r, id, method, "debug")
+		return
+	}
+	if !p.Collector(id.Role, method) {
+		forbidden(r, id, method, method)
+		return
+	}
+	if reveal(req) && !mayReveal(p, id) {
+		forbidden(r, id, method, "reveal")
+		return
+	}
+
 	data, err := data(method)
+	if !reveal(req) {
+		data = rd.Redact(data)
+	}
 	view := View("Debug")
 	view.Error = err
 	view.Data = data
@@ -109,45 +194,107 @@ func data(method string) (data interface{}, err error) {
 		data, err = hostname()
 	case "ip":
 		data, err = ip(currentHostname)
+	case "addresses":
+		data, err = addresses(currentHostname)
 	case "cpu":
 		data, err = cpu()
+	case "cpustat":
+		data, err = cpuStat()
 	case "mem":
 		data, err = mem()
+	case "cgroup":
+		data, err = cgroup()
+	case "cgroups":
+		data, err = cgroups()
+	case "docker":
+		data, err = dockerContainers()
+	case "docker_images":
+		data, err = dockerImages()
+	case "kubernetes":
+		data, err = kubernetes()
+	case "kubernetes_pods":
+		data, err = kubernetesPods()
 	case "disk":
 		data, err = df()
+	case "diskio":
+		data, err = diskIO()
 	case "top":
 		data, err = top()
+	case "sockets":
+		data, err = sockets()
 	case "logged_on":
 		data, err = w()
 	case "passwd":
 		data, err = passwd()
 	case "network":
 		data, err = network()
+	case "netstat":
+		data, err = netStat()
+	case "routing":
+		data, err = routing()
 	case "env":
 		data = env()
 	default:
-		data, err = nil, errors.New("unknown method")
+		data, err = nil, errUnknownMethod
 	}
 	return
 }
 
-func DataHandler(method string) func(r render.Render, req *http.Request) {
-	return func(r render.Render, req *http.Request) {
+func DataHandler(method string) func(r render.Render, req *http.Request, p *permissions, rd *redactor, id *Identity) {
+	return collectorHandler(method, func() (interface{}, error) {
 		data, err := data(method)
+		if method == "mem" { // exclude 'mem' for now.. there's some syntax problem on CF with it
+			err = nil
+		}
+		return data, err
+	})
+}
+
+// DataV2Handler serves the /api/v2 payloads, with integer byte counts and ids, parsed fields and RFC 3339 timestamps.
+func DataV2Handler(method string) func(r render.Render, req *http.Request, p *permissions, rd *redactor, id *Identity) {
+	return collectorHandler(method, func() (interface{}, error) {
+		return dataV2(method)
+	})
+}
+
+func collectorHandler(method string, collect func() (interface{}, error)) func(r render.Render, req *http.Request, p *permissions, rd *redactor, id *Identity) {
+	return func(r render.Render, req *http.Request, p *permissions, rd *redactor, id *Identity) {
+		if !p.Collector(id.Role, method) {
+			forbidden(r, id, method, method)
+			return
+		}
+		if reveal(req) && !mayReveal(p, id) {
+			forbidden(r, id, method, "reveal")
+			return
+		}
+		format, err := negotiateFormat(req)
+		if err != nil {
+			apiError(r, http.StatusNotAcceptable, ErrNotAcceptable, method, err)
+			return
+		}
+
+		data, err := collect()
 
 		if method == "headers" {
 			data = req.Header
 			err = nil
 		}
 
-		if err != nil && method != "mem" { // exclude 'mem' for now.. there's some syntax problem on CF with it
-			view := View("500 - Internal Server Error")
-			view.Error = err
-			r.HTML(http.StatusInternalServerError, "500", view)
+		warnings, err := collectorError(err)
+		if err == errUnknownMethod {
+			apiError(r, http.StatusNotFound, ErrUnknownCollector, method, err)
+			return
+		}
+		if err != nil {
+			apiError(r, http.StatusInternalServerError, ErrCollectorFailed, method, err)
 			return
 		}
+		writeWarnings(r, warnings)
 
-		r.JSON(http.StatusOK, data)
+		if !reveal(req) {
+			data = rd.Redact(data)
+		}
+		renderFormat(r, format, data)
 	}
 }
 
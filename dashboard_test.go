@@ -7,12 +7,15 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
 )
 
 func init() {
@@ -103,12 +106,92 @@ func Test_todoapp_500(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	req.Header.Set("X-Request-Id", "test-request-1")
+
 	m.ServeHTTP(response, req)
 	Expect(t, response.Code, http.StatusInternalServerError)
+	Expect(t, response.Header().Get("Content-Type"), "application/json; charset=UTF-8")
+
+	var data struct {
+		Error *APIError
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, data.Error.Code, ErrCollectorFailed)
+	Expect(t, data.Error.Collector, "ip")
+	Expect(t, data.Error.RequestID, "test-request-1")
+	Contain(t, data.Error.Message, `lookup will_cause_error`)
+}
+
+func Test_todoapp_api_404(t *testing.T) {
+	m := setupMartini()
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/api/unknown", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusNotFound)
 
 	body := response.Body.String()
-	Contain(t, body, `<h1>500 - Internal Server Error</h1>`)
-	Contain(t, body, `<h4>lookup will_cause_error: no such host</h4>`)
+	Contain(t, body, `"Code": "not_found"`)
+	Contain(t, body, `"Message": "no such endpoint [/api/unknown]"`)
+}
+
+func Test_todoapp_api_Warnings(t *testing.T) {
+	m := setupMartini()
+	r := martini.NewRouter()
+	m.Action(r.Handle)
+	r.Get("/api/partial", func(r render.Render) {
+		data, err := []string{"complete"}, error(Warnings{"df: /secret: Permission denied"})
+		warnings, err := collectorError(err)
+		Expect(t, err, nil)
+		writeWarnings(r, warnings)
+		r.JSON(http.StatusOK, data)
+	})
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/api/partial", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, response.Header().Get("Warning"), `199 dashboard "df: /secret: Permission denied"`)
+	Contain(t, response.Body.String(), `"complete"`)
+}
+
+func Test_todoapp_dfRows_Partial(t *testing.T) {
+	// a df that can not read one mount: it exits non-zero, but reports all the others
+	dir, err := ioutil.TempDir("", "dashboard-df")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := `#!/bin/sh
+echo "Filesystem     Size  Used Avail Use% Mounted on"
+echo "/dev/sda1       20G  5.0G   15G  25% /"
+echo "tmpfs          1.0G     0  1.0G   0% /tmp"
+echo "df: /secret: Permission denied" >&2
+exit 1
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "df"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	rows, err := dfRows("-h")
+	Expect(t, err, error(Warnings{"df: /secret: Permission denied"}))
+	Expect(t, rows, [][]string{
+		{"/dev/sda1", "20G", "5.0G", "15G", "25%", "/"},
+		{"tmpfs", "1.0G", "0", "1.0G", "0%", "/tmp"},
+	})
 }
 
 func Test_todoapp_api_GetHostname(t *testing.T) {
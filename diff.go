@@ -0,0 +1,219 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/martini-contrib/render"
+)
+
+// defaultThreshold is the relative change of a metric that counts as significant.
+const defaultThreshold = 0.1
+
+// SnapshotDiff is the structured difference between two snapshots.
+// Sections of collectors the reader may not access, or that are missing in either snapshot, are left out.
+type SnapshotDiff struct {
+	From       *SnapshotRef
+	To         *SnapshotRef
+	Threshold  float64
+	Processes  *SetDiff        `json:",omitempty"`
+	Users      *SetDiff        `json:",omitempty"`
+	Mounts     *SetDiff        `json:",omitempty"`
+	Interfaces *SetDiff        `json:",omitempty"`
+	Env        *SetDiff        `json:",omitempty"`
+	Metrics    []*MetricChange `json:",omitempty"`
+}
+
+// SetDiff lists the keys only present in one of the snapshots, and the keys whose value changed.
+type SetDiff struct {
+	Added   []string
+	Removed []string
+	Changed []*ValueChange
+}
+
+type ValueChange struct {
+	Key  string
+	From string
+	To   string
+}
+
+// MetricChange is a metric that changed by at least the threshold, Change is relative to From.
+// A metric growing from zero has no relative change, Change is null for it.
+type MetricChange struct {
+	Name   string
+	From   float64
+	To     float64
+	Change *float64
+}
+
+func diffSets(from, to map[string]string) *SetDiff {
+	d := &SetDiff{Added: []string{}, Removed: []string{}, Changed: []*ValueChange{}}
+	for key, value := range to {
+		if old, ok := from[key]; !ok {
+			d.Added = append(d.Added, key)
+		} else if old != value {
+			d.Changed = append(d.Changed, &ValueChange{key, old, value})
+		}
+	}
+	for key := range from {
+		if _, ok := to[key]; !ok {
+			d.Removed = append(d.Removed, key)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Slice(d.Changed, func(i, j int) bool { return d.Changed[i].Key < d.Changed[j].Key })
+	return d
+}
+
+// processes are compared by user and command line, their pids change with every restart.
+func processSet(processes []*ProcessV2) map[string]string {
+	set := make(map[string]string)
+	for _, process := range processes {
+		set[process.User+" "+process.Command] = ""
+	}
+	return set
+}
+
+func userSet(users []*UserV2) map[string]string {
+	set := make(map[string]string)
+	for _, user := range users {
+		set[user.Name] = fmt.Sprintf("uid=%d gid=%d home=%s shell=%s", user.Uid, user.Gid, user.Home, user.Shell)
+	}
+	return set
+}
+
+func mountSet(disks []*DiskUsageV2) map[string]string {
+	set := make(map[string]string)
+	for _, disk := range disks {
+		set[disk.MountedOn] = disk.Filesystem
+	}
+	return set
+}
+
+func interfaceSet(interfaces []*IfV2) map[string]string {
+	set := make(map[string]string)
+	for _, i := range interfaces {
+		set[fmt.Sprintf("%s %s/%d", i.Name, i.Address, i.PrefixLength)] = i.Family
+	}
+	return set
+}
+
+func envSet(env []*Env) map[string]string {
+	set := make(map[string]string)
+	for _, e := range env {
+		set[e.Key] = e.Value
+	}
+	return set
+}
+
+// metrics flattens the numeric values of a snapshot worth comparing.
+func metrics(s *Snapshot) map[string]float64 {
+	m := make(map[string]float64)
+	if s.CPU != nil {
+		m["cpu.load1"] = s.CPU.Load1
+		m["cpu.load5"] = s.CPU.Load5
+		m["cpu.load15"] = s.CPU.Load15
+		m["cpu.processes"] = float64(s.CPU.TotalProcesses)
+	}
+	if s.Memory != nil {
+		m["mem.ram.used_bytes"] = float64(s.Memory.RAM.UsedBytes)
+		m["mem.ram.available_bytes"] = float64(s.Memory.RAM.AvailableBytes)
+		m["mem.swap.used_bytes"] = float64(s.Memory.Swap.UsedBytes)
+	}
+	for _, disk := range s.Disks {
+		m["disk."+disk.MountedOn+".used_bytes"] = float64(disk.UsedBytes)
+		m["disk."+disk.MountedOn+".usage_percentage"] = float64(disk.UsagePercentage)
+	}
+	return m
+}
+
+func diffSnapshots(from, to *Snapshot, threshold float64) *SnapshotDiff {
+	d := &SnapshotDiff{From: from.Ref(), To: to.Ref(), Threshold: threshold, Metrics: []*MetricChange{}}
+
+	sections := []struct {
+		target   **SetDiff
+		from, to map[string]string
+		present  bool
+	}{
+		{&d.Processes, processSet(from.Processes), processSet(to.Processes), from.Processes != nil && to.Processes != nil},
+		{&d.Users, userSet(from.Users), userSet(to.Users), from.Users != nil && to.Users != nil},
+		{&d.Mounts, mountSet(from.Disks), mountSet(to.Disks), from.Disks != nil && to.Disks != nil},
+		{&d.Interfaces, interfaceSet(from.Interfaces), interfaceSet(to.Interfaces), from.Interfaces != nil && to.Interfaces != nil},
+		{&d.Env, envSet(from.Env), envSet(to.Env), from.Env != nil && to.Env != nil},
+	}
+	for _, section := range sections {
+		if section.present {
+			*section.target = diffSets(section.from, section.to)
+		}
+	}
+
+	fromMetrics, toMetrics := metrics(from), metrics(to)
+	for name, old := range fromMetrics {
+		value, ok := toMetrics[name]
+		if !ok || old == value {
+			continue
+		}
+		if old == 0 {
+			d.Metrics = append(d.Metrics, &MetricChange{name, old, value, nil})
+			continue
+		}
+		change := (value - old) / math.Abs(old)
+		if math.Abs(change) >= threshold {
+			d.Metrics = append(d.Metrics, &MetricChange{name, old, value, &change})
+		}
+	}
+	sort.Slice(d.Metrics, func(i, j int) bool { return d.Metrics[i].Name < d.Metrics[j].Name })
+	return d
+}
+
+// DiffHandler compares two snapshots given as ?from= and ?to=, which default to the oldest stored snapshot and the current state.
+// ?threshold= sets the relative change of metrics to report, e.g. 0.25 for 25%.
+func DiffHandler(req *http.Request, store *snapshotStore, f *fleet, p *permissions, rd *redactor, id *Identity, r render.Render) {
+	query := req.URL.Query()
+	fromID, toID := query.Get("from"), query.Get("to")
+	if len(fromID) == 0 {
+		refs := store.List()
+		if len(refs) == 0 {
+			apiError(r, http.StatusNotFound, ErrNotFound, "", fmt.Errorf("no snapshots stored yet"))
+			return
+		}
+		fromID = refs[0].ID
+	}
+	if len(toID) == 0 {
+		toID = "current"
+	}
+	threshold := defaultThreshold
+	if value := query.Get("threshold"); len(value) > 0 {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 {
+			apiError(r, http.StatusBadRequest, ErrBadRequest, "", fmt.Errorf("invalid threshold [%s]", value))
+			return
+		}
+		threshold = parsed
+	}
+
+	from, err := resolveSnapshot(fromID, store, f, rd)
+	if err != nil {
+		snapshotError(r, fromID, err)
+		return
+	}
+	to, err := resolveSnapshot(toID, store, f, rd)
+	if err != nil {
+		snapshotError(r, toID, err)
+		return
+	}
+	r.JSON(http.StatusOK, diffSnapshots(from.filter(p, id.Role), to.filter(p, id.Role), threshold))
+}
+
+// DiffPageHandler serves the page comparing snapshots, which works entirely off the snapshot and diff API.
+func DiffPageHandler(r render.Render) {
+	r.HTML(http.StatusOK, "diff", View("Snapshot Diff"), render.HTMLOptions{})
+}
@@ -0,0 +1,191 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sectorSize is the unit of /proc/diskstats, independent of the sector size of the device.
+const sectorSize = 512
+
+var diskStatsSampler = newSampler(func() (interface{}, error) {
+	content, err := ioutil.ReadFile(procDir + "/diskstats")
+	if err != nil {
+		return nil, err
+	}
+	return parseDiskStats(string(content))
+})
+
+// DiskIO is the activity of a block device, rates cover the time since the previous request.
+type DiskIO struct {
+	Device              string
+	Mountpoints         []string
+	SizeBytes           uint64
+	Rotational          bool
+	ReadsPerSecond      float64
+	WritesPerSecond     float64
+	ReadBytesPerSecond  float64
+	WriteBytesPerSecond float64
+	ReadLatencyMs       float64 // average time a read took, including the time in the queue
+	WriteLatencyMs      float64
+	QueueDepth          float64 // average number of requests in flight
+	InFlight            uint64
+	UtilizationPercent  float64 // share of time the device was busy
+}
+
+// diskStats is a line of /proc/diskstats.
+type diskStats struct {
+	name                             string
+	reads, readSectors, readTicks    uint64
+	writes, writeSectors, writeTicks uint64
+	inFlight, ioTicks, weightedTicks uint64
+}
+
+func (d *diskStats) idle() bool {
+	return d.reads == 0 && d.writes == 0 && d.inFlight == 0
+}
+
+func parseDiskStats(content string) ([]*diskStats, error) {
+	var devices []*diskStats
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 14 {
+			return nil, fmt.Errorf("invalid /proc/diskstats line [%s]", scanner.Text())
+		}
+		values := make([]uint64, 11)
+		for i := range values {
+			values[i], _ = strconv.ParseUint(fields[i+3], 10, 64)
+		}
+		devices = append(devices, &diskStats{
+			name:          fields[2],
+			reads:         values[0],
+			readSectors:   values[2],
+			readTicks:     values[3],
+			writes:        values[4],
+			writeSectors:  values[6],
+			writeTicks:    values[7],
+			inFlight:      values[8],
+			ioTicks:       values[9],
+			weightedTicks: values[10],
+		})
+	}
+	return devices, scanner.Err()
+}
+
+// readSysBlock returns an attribute of /sys/class/block/<name>, which has partitions next to whole devices.
+// Partitions have no queue, their queue attributes are taken from the parent device.
+func readSysBlock(name, attribute string) string {
+	path := filepath.Join(sysDir, "class", "block", name)
+	if strings.HasPrefix(attribute, "queue/") {
+		if _, err := ioutil.ReadFile(filepath.Join(path, "partition")); err == nil {
+			if resolved, err := filepath.EvalSymlinks(path); err == nil {
+				path = filepath.Dir(resolved)
+			}
+		}
+	}
+	content, err := ioutil.ReadFile(filepath.Join(path, attribute))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// mountpoints maps device names to where df reports them mounted, following links like /dev/mapper/* to /dev/dm-*.
+func mountpoints(disks []*DiskUsageV2) map[string][]string {
+	mounts := make(map[string][]string)
+	for _, disk := range disks {
+		if !strings.HasPrefix(disk.Filesystem, "/dev/") {
+			continue
+		}
+		device := disk.Filesystem
+		if resolved, err := filepath.EvalSymlinks(device); err == nil {
+			device = resolved
+		}
+		name := filepath.Base(device)
+		mounts[name] = append(mounts[name], disk.MountedOn)
+	}
+	return mounts
+}
+
+func diskIOBetween(previous, current []*diskStats, seconds float64, mounts map[string][]string) []*DiskIO {
+	before := make(map[string]*diskStats)
+	for _, dev := range previous {
+		before[dev.name] = dev
+	}
+	round := func(v float64) float64 {
+		return math.Round(v*10) / 10
+	}
+	latency := func(previousTicks, ticks, previousCount, count uint64) float64 {
+		if count <= previousCount || ticks < previousTicks {
+			return 0
+		}
+		return round(float64(ticks-previousTicks) / float64(count-previousCount))
+	}
+
+	stats := []*DiskIO{}
+	for _, dev := range current {
+		if dev.idle() && len(mounts[dev.name]) == 0 { // e.g. unused loop and ram devices
+			continue
+		}
+		old, ok := before[dev.name]
+		if !ok { // appeared since the previous reading
+			old = dev
+		}
+		stat := &DiskIO{
+			Device:              dev.name,
+			Mountpoints:         mounts[dev.name],
+			Rotational:          readSysBlock(dev.name, "queue/rotational") == "1",
+			ReadsPerSecond:      round(rate(old.reads, dev.reads, seconds)),
+			WritesPerSecond:     round(rate(old.writes, dev.writes, seconds)),
+			ReadBytesPerSecond:  round(rate(old.readSectors, dev.readSectors, seconds) * sectorSize),
+			WriteBytesPerSecond: round(rate(old.writeSectors, dev.writeSectors, seconds) * sectorSize),
+			ReadLatencyMs:       latency(old.readTicks, dev.readTicks, old.reads, dev.reads),
+			WriteLatencyMs:      latency(old.writeTicks, dev.writeTicks, old.writes, dev.writes),
+			QueueDepth:          math.Round(rate(old.weightedTicks, dev.weightedTicks, seconds)/10) / 100,
+			InFlight:            dev.inFlight,
+			UtilizationPercent:  round(math.Min(rate(old.ioTicks, dev.ioTicks, seconds)/10, 100)),
+		}
+		if stat.Mountpoints == nil {
+			stat.Mountpoints = []string{}
+		}
+		if sectors, err := strconv.ParseUint(readSysBlock(dev.name, "size"), 10, 64); err == nil {
+			stat.SizeBytes = sectors * sectorSize
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// diskIO samples /proc/diskstats, rates cover the time since the previous call.
+// Failing to map devices to mountpoints is only a warning.
+func diskIO() ([]*DiskIO, error) {
+	previous, current, seconds, err := diskStatsSampler.sample()
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings Warnings
+	disks, err := dfV2()
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("mountpoints: %v", err))
+	}
+
+	stats := diskIOBetween(previous.([]*diskStats), current.([]*diskStats), seconds, mountpoints(disks))
+	if len(warnings) > 0 {
+		return stats, warnings
+	}
+	return stats, nil
+}
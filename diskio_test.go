@@ -0,0 +1,119 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const diskStatsBefore = `   7       0 loop0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0
+   8       0 sda 1000 0 80000 2000 500 0 40000 5000 0 3000 7000 0 0 0 0 0 0
+   8       1 sda1 900 0 70000 1800 500 0 40000 5000 0 2900 6800 0 0 0 0 0 0
+`
+
+const diskStatsAfter = `   7       0 loop0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0
+   8       0 sda 1200 0 84000 2400 600 0 50240 6000 2 4000 9000 0 0 0 0 0 0
+   8       1 sda1 1100 0 74000 2200 600 0 50240 6000 2 3900 8800 0 0 0 0 0 0
+`
+
+func Test_todoapp_diskio_parseDiskStats(t *testing.T) {
+	devices, err := parseDiskStats(diskStatsBefore)
+	Expect(t, err, nil)
+	Expect(t, len(devices), 3)
+	Expect(t, devices[0].idle(), true)
+	Expect(t, devices[1].name, "sda")
+	Expect(t, devices[1].reads, uint64(1000))
+	Expect(t, devices[1].writeSectors, uint64(40000))
+	Expect(t, devices[1].weightedTicks, uint64(7000))
+
+	_, err = parseDiskStats("8 0 sda 1 2 3\n")
+	NotExpect(t, err, nil)
+}
+
+func Test_todoapp_diskio_diskIOBetween(t *testing.T) {
+	original := sysDir
+	defer func() {
+		sysDir = original
+	}()
+	dir, err := ioutil.TempDir("", "dashboard-sys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sysDir = dir
+
+	// /sys/class/block links to the devices, partitions are below their disk
+	sda := filepath.Join(dir, "devices", "sda")
+	files := map[string]string{
+		filepath.Join(sda, "size"):                "2000\n",
+		filepath.Join(sda, "queue", "rotational"): "1\n",
+		filepath.Join(sda, "sda1", "size"):        "1000\n",
+		filepath.Join(sda, "sda1", "partition"):   "1\n",
+	}
+	for file, content := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "class", "block"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Symlink(sda, filepath.Join(dir, "class", "block", "sda"))
+	os.Symlink(filepath.Join(sda, "sda1"), filepath.Join(dir, "class", "block", "sda1"))
+
+	before, _ := parseDiskStats(diskStatsBefore)
+	after, _ := parseDiskStats(diskStatsAfter)
+	mounts := mountpoints([]*DiskUsageV2{
+		{Filesystem: "/dev/sda1", MountedOn: "/"},
+		{Filesystem: "/dev/sda1", MountedOn: "/var/lib/docker"},
+		{Filesystem: "tmpfs", MountedOn: "/tmp"},
+	})
+	stats := diskIOBetween(before, after, 2, mounts)
+
+	// idle devices that are not mounted are left out
+	Expect(t, len(stats), 2)
+	disk := stats[0]
+	Expect(t, disk.Device, "sda")
+	Expect(t, disk.Mountpoints, []string{})
+	Expect(t, disk.SizeBytes, uint64(2000*512))
+	Expect(t, disk.Rotational, true)
+	Expect(t, disk.ReadsPerSecond, 100.0)
+	Expect(t, disk.WritesPerSecond, 50.0)
+	Expect(t, disk.ReadBytesPerSecond, 1024000.0)
+	Expect(t, disk.WriteBytesPerSecond, 2621440.0)
+	Expect(t, disk.ReadLatencyMs, 2.0)
+	Expect(t, disk.WriteLatencyMs, 10.0)
+	Expect(t, disk.QueueDepth, 1.0)
+	Expect(t, disk.InFlight, uint64(2))
+	Expect(t, disk.UtilizationPercent, 50.0)
+
+	partition := stats[1]
+	Expect(t, partition.Device, "sda1")
+	Expect(t, partition.Mountpoints, []string{"/", "/var/lib/docker"})
+	Expect(t, partition.SizeBytes, uint64(1000*512))
+	Expect(t, partition.Rotational, true)
+}
+
+func Test_todoapp_api_GetDiskIO(t *testing.T) {
+	var data []*DiskIO
+	response := getV2(t, "/api/disk_io", &data)
+	Expect(t, response.Code, http.StatusOK)
+
+	NotExpect(t, len(data), 0)
+	var mounted bool
+	for _, disk := range data {
+		if len(disk.Mountpoints) > 0 {
+			mounted = true
+		}
+	}
+	Expect(t, mounted, true)
+}
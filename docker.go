@@ -0,0 +1,531 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+)
+
+var (
+	defaultDockerSocket = "/var/run/docker.sock"
+	dockerTimeout       = 10 * time.Second // stats take about 2 seconds, docker samples them twice
+
+	errNoContainer = errors.New("no such container")
+	rxContainerID  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+)
+
+type DockerContainer struct {
+	ID                   string
+	Name                 string
+	Image                string
+	Command              string
+	Created              time.Time
+	State                string // created, running, paused, restarting, exited or dead
+	Status               string // e.g. "Up 2 hours (healthy)"
+	RestartCount         int
+	Ports                []string // e.g. "0.0.0.0:8080->80/tcp"
+	CPUPercent           float64  // of a single CPU like top, only for running containers
+	MemoryBytes          uint64   // without inactive page cache, like docker stats
+	MemoryLimitBytes     uint64
+	MemoryPercent        float64
+	NetworkReceiveBytes  uint64
+	NetworkTransmitBytes uint64
+	BlockReadBytes       uint64
+	BlockWriteBytes      uint64
+	Pids                 uint64
+}
+
+type DockerContainers struct {
+	Available  bool // whether the Docker Engine API socket exists
+	Version    string
+	Containers []*DockerContainer // running ones first
+}
+
+type DockerImage struct {
+	ID         string
+	Tags       []string
+	Created    time.Time
+	SizeBytes  uint64
+	Containers int // using the image, -1 if unknown
+}
+
+type DockerMount struct {
+	Type        string
+	Source      string
+	Destination string
+	ReadOnly    bool
+}
+
+// DockerContainerDetail is the container detail page, environment variables are redacted like those of the env collector.
+type DockerContainerDetail struct {
+	Container     *DockerContainer
+	Entrypoint    []string
+	Cmd           []string
+	WorkingDir    string
+	User          string
+	Env           []*Env
+	Labels        map[string]string
+	Mounts        []*DockerMount
+	Networks      map[string]string // IP address per network
+	RestartPolicy string
+	Health        string
+	StartedAt     time.Time
+	FinishedAt    time.Time
+	ExitCode      int
+	OOMKilled     bool
+	Pid           int
+}
+
+// the parts of the Docker Engine API responses we use, see https://docs.docker.com/engine/api/
+type (
+	dockerPort struct {
+		IP          string
+		PrivatePort int
+		PublicPort  int
+		Type        string
+	}
+
+	dockerContainerJSON struct {
+		ID      string `json:"Id"`
+		Names   []string
+		Image   string
+		Command string
+		Created int64
+		State   string
+		Status  string
+		Ports   []dockerPort
+	}
+
+	dockerImageJSON struct {
+		ID         string `json:"Id"`
+		RepoTags   []string
+		Created    int64
+		Size       int64
+		Containers int64
+	}
+
+	dockerInspectJSON struct {
+		ID           string `json:"Id"`
+		Name         string
+		Created      time.Time
+		RestartCount int
+		State        struct {
+			Status     string
+			Pid        int
+			ExitCode   int
+			OOMKilled  bool
+			StartedAt  time.Time
+			FinishedAt time.Time
+			Health     *struct {
+				Status string
+			}
+		}
+		Config struct {
+			Image      string
+			Entrypoint []string
+			Cmd        []string
+			WorkingDir string
+			User       string
+			Env        []string
+			Labels     map[string]string
+		}
+		HostConfig struct {
+			RestartPolicy struct {
+				Name string
+			}
+		}
+		Mounts []struct {
+			Type        string
+			Source      string
+			Destination string
+			RW          bool
+		}
+		NetworkSettings struct {
+			Networks map[string]struct{ IPAddress string }
+		}
+	}
+
+	dockerCPUStats struct {
+		CPUUsage struct {
+			TotalUsage  uint64   `json:"total_usage"`
+			PercpuUsage []uint64 `json:"percpu_usage"`
+		} `json:"cpu_usage"`
+		SystemUsage uint64 `json:"system_cpu_usage"`
+		OnlineCPUs  int    `json:"online_cpus"`
+	}
+
+	dockerStatsJSON struct {
+		CPUStats    dockerCPUStats `json:"cpu_stats"`
+		PreCPUStats dockerCPUStats `json:"precpu_stats"`
+		MemoryStats struct {
+			Usage uint64            `json:"usage"`
+			Limit uint64            `json:"limit"`
+			Stats map[string]uint64 `json:"stats"`
+		} `json:"memory_stats"`
+		Networks map[string]struct {
+			RxBytes uint64 `json:"rx_bytes"`
+			TxBytes uint64 `json:"tx_bytes"`
+		} `json:"networks"`
+		BlkioStats struct {
+			IOServiceBytesRecursive []struct {
+				Op    string `json:"op"`
+				Value uint64 `json:"value"`
+			} `json:"io_service_bytes_recursive"`
+		} `json:"blkio_stats"`
+		PidsStats struct {
+			Current uint64 `json:"current"`
+		} `json:"pids_stats"`
+	}
+)
+
+// dockerSocket is the Docker Engine API socket, DASHBOARD_DOCKER_SOCKET is read at call time like DASHBOARD_EXTERNAL_IP_URL.
+func dockerSocket() string {
+	return envOrDefault("DASHBOARD_DOCKER_SOCKET", defaultDockerSocket)
+}
+
+// dockerClient talks HTTP to the Docker Engine over its unix socket, the host part of request URLs is ignored.
+type dockerClient struct {
+	http *http.Client
+}
+
+func newDockerClient(socket string) *dockerClient {
+	return &dockerClient{http: &http.Client{
+		Timeout: dockerTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}}
+}
+
+// get decodes the JSON answer of an API path, errors carry the message Docker answers with.
+func (c *dockerClient) get(path string, v interface{}) error {
+	response, err := c.http.Get("http://docker" + path)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound && strings.HasPrefix(path, "/containers/") {
+		return errNoContainer
+	}
+	if response.StatusCode != http.StatusOK {
+		var body struct{ Message string }
+		json.NewDecoder(response.Body).Decode(&body)
+		return fmt.Errorf("docker %s returned status %d: %s", path, response.StatusCode, body.Message)
+	}
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+// dockerPorts formats port mappings like docker ps, unpublished ports only as "80/tcp".
+func dockerPorts(ports []dockerPort) []string {
+	sort.SliceStable(ports, func(i, j int) bool {
+		if ports[i].PrivatePort != ports[j].PrivatePort {
+			return ports[i].PrivatePort < ports[j].PrivatePort
+		}
+		return ports[i].IP < ports[j].IP
+	})
+	result := []string{}
+	for _, port := range ports {
+		if port.PublicPort == 0 {
+			result = append(result, fmt.Sprintf("%d/%s", port.PrivatePort, port.Type))
+			continue
+		}
+		result = append(result, fmt.Sprintf("%s->%d/%s", net.JoinHostPort(port.IP, fmt.Sprint(port.PublicPort)), port.PrivatePort, port.Type))
+	}
+	return result
+}
+
+func dockerContainer(c *dockerContainerJSON) *DockerContainer {
+	container := &DockerContainer{
+		ID:      c.ID,
+		Image:   c.Image,
+		Command: c.Command,
+		Created: time.Unix(c.Created, 0).UTC(),
+		State:   c.State,
+		Status:  c.Status,
+		Ports:   dockerPorts(c.Ports),
+	}
+	if len(c.Names) > 0 {
+		container.Name = strings.TrimPrefix(c.Names[0], "/")
+	}
+	return container
+}
+
+// addStats computes the numbers docker stats shows. The CPU usage is that between the two samples Docker takes,
+// memory usage leaves out inactive page cache, "total_inactive_file" on cgroup v1 and "inactive_file" on v2.
+func (c *DockerContainer) addStats(stats *dockerStatsJSON) {
+	cpus := stats.CPUStats.OnlineCPUs
+	if cpus == 0 {
+		cpus = len(stats.CPUStats.CPUUsage.PercpuUsage)
+	}
+	cpu, system := stats.CPUStats, stats.PreCPUStats
+	if system.SystemUsage > 0 && cpu.SystemUsage > system.SystemUsage && cpu.CPUUsage.TotalUsage >= system.CPUUsage.TotalUsage {
+		share := float64(cpu.CPUUsage.TotalUsage-system.CPUUsage.TotalUsage) / float64(cpu.SystemUsage-system.SystemUsage)
+		c.CPUPercent = math.Round(share*float64(cpus)*1000) / 10
+	}
+
+	memory := stats.MemoryStats
+	c.MemoryBytes, c.MemoryLimitBytes = memory.Usage, memory.Limit
+	for _, key := range []string{"total_inactive_file", "inactive_file"} {
+		if inactive, ok := memory.Stats[key]; ok && inactive < c.MemoryBytes {
+			c.MemoryBytes -= inactive
+			break
+		}
+	}
+	if c.MemoryLimitBytes > 0 {
+		c.MemoryPercent = math.Round(float64(c.MemoryBytes)/float64(c.MemoryLimitBytes)*1000) / 10
+	}
+
+	for _, network := range stats.Networks {
+		c.NetworkReceiveBytes += network.RxBytes
+		c.NetworkTransmitBytes += network.TxBytes
+	}
+	for _, entry := range stats.BlkioStats.IOServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			c.BlockReadBytes += entry.Value
+		case "write":
+			c.BlockWriteBytes += entry.Value
+		}
+	}
+	c.Pids = stats.PidsStats.Current
+}
+
+// dockerContainers lists all containers, with restart counts and the stats of running containers fetched concurrently.
+// Containers whose details can not be fetched, e.g. because they were just removed, are reported as warnings.
+func dockerContainers() (*DockerContainers, error) {
+	socket := dockerSocket()
+	if _, err := os.Stat(socket); os.IsNotExist(err) { // Docker is optional
+		return &DockerContainers{Containers: []*DockerContainer{}}, nil
+	}
+	client := newDockerClient(socket)
+
+	var version struct{ Version string }
+	if err := client.get("/version", &version); err != nil {
+		return nil, err
+	}
+	var list []*dockerContainerJSON
+	if err := client.get("/containers/json?all=1", &list); err != nil {
+		return nil, err
+	}
+
+	data := &DockerContainers{Available: true, Version: version.Version, Containers: []*DockerContainer{}}
+	var warnings Warnings
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range list {
+		container := dockerContainer(c)
+		data.Containers = append(data.Containers, container)
+
+		wg.Add(1)
+		go func(container *DockerContainer) {
+			defer wg.Done()
+			fail := func(err error) {
+				lock.Lock()
+				defer lock.Unlock()
+				warnings = append(warnings, fmt.Sprintf("container %s: %v", container.Name, err))
+			}
+
+			var inspect dockerInspectJSON
+			if err := client.get("/containers/"+container.ID+"/json", &inspect); err != nil {
+				fail(err)
+				return
+			}
+			container.RestartCount = inspect.RestartCount
+			if container.State != "running" {
+				return
+			}
+			var stats dockerStatsJSON
+			if err := client.get("/containers/"+container.ID+"/stats?stream=false", &stats); err != nil {
+				fail(err)
+				return
+			}
+			container.addStats(&stats)
+		}(container)
+	}
+	wg.Wait()
+
+	sort.SliceStable(data.Containers, func(i, j int) bool {
+		a, b := data.Containers[i], data.Containers[j]
+		if (a.State == "running") != (b.State == "running") {
+			return a.State == "running"
+		}
+		return a.Name < b.Name
+	})
+	if len(warnings) > 0 {
+		sort.Strings(warnings)
+		return data, warnings
+	}
+	return data, nil
+}
+
+func dockerImages() ([]*DockerImage, error) {
+	socket := dockerSocket()
+	if _, err := os.Stat(socket); os.IsNotExist(err) {
+		return []*DockerImage{}, nil
+	}
+
+	var list []*dockerImageJSON
+	if err := newDockerClient(socket).get("/images/json", &list); err != nil {
+		return nil, err
+	}
+	images := []*DockerImage{}
+	for _, image := range list {
+		tags := []string{}
+		for _, tag := range image.RepoTags {
+			if tag != "<none>:<none>" { // dangling images
+				tags = append(tags, tag)
+			}
+		}
+		images = append(images, &DockerImage{
+			ID:         image.ID,
+			Tags:       tags,
+			Created:    time.Unix(image.Created, 0).UTC(),
+			SizeBytes:  uint64(image.Size),
+			Containers: int(image.Containers),
+		})
+	}
+	sort.SliceStable(images, func(i, j int) bool {
+		return images[i].Created.After(images[j].Created)
+	})
+	return images, nil
+}
+
+// dockerContainerDetail inspects a single container by id or name, running containers include their stats.
+func dockerContainerDetail(id string) (*DockerContainerDetail, error) {
+	socket := dockerSocket()
+	if _, err := os.Stat(socket); os.IsNotExist(err) {
+		return nil, errNoContainer
+	}
+	client := newDockerClient(socket)
+
+	var inspect dockerInspectJSON
+	if err := client.get("/containers/"+url.PathEscape(id)+"/json", &inspect); err != nil {
+		return nil, err
+	}
+
+	// inspect has no "docker ps" view of the container, the list filtered by id has
+	var list []*dockerContainerJSON
+	filters := url.QueryEscape(fmt.Sprintf(`{"id":[%q]}`, inspect.ID))
+	if err := client.get("/containers/json?all=1&filters="+filters, &list); err != nil {
+		return nil, err
+	}
+	container := &DockerContainer{ID: inspect.ID, Name: strings.TrimPrefix(inspect.Name, "/"), Image: inspect.Config.Image, Created: inspect.Created, State: inspect.State.Status, Ports: []string{}}
+	if len(list) > 0 {
+		container = dockerContainer(list[0])
+	}
+	container.RestartCount = inspect.RestartCount
+
+	detail := &DockerContainerDetail{
+		Container:     container,
+		Entrypoint:    inspect.Config.Entrypoint,
+		Cmd:           inspect.Config.Cmd,
+		WorkingDir:    inspect.Config.WorkingDir,
+		User:          inspect.Config.User,
+		Env:           []*Env{},
+		Labels:        inspect.Config.Labels,
+		Mounts:        []*DockerMount{},
+		Networks:      make(map[string]string),
+		RestartPolicy: inspect.HostConfig.RestartPolicy.Name,
+		StartedAt:     inspect.State.StartedAt,
+		FinishedAt:    inspect.State.FinishedAt,
+		ExitCode:      inspect.State.ExitCode,
+		OOMKilled:     inspect.State.OOMKilled,
+		Pid:           inspect.State.Pid,
+	}
+	if detail.Labels == nil {
+		detail.Labels = make(map[string]string)
+	}
+	if inspect.State.Health != nil {
+		detail.Health = inspect.State.Health.Status
+	}
+	for _, variable := range inspect.Config.Env {
+		values := strings.SplitN(variable, "=", 2)
+		if len(values) == 2 {
+			detail.Env = append(detail.Env, &Env{values[0], values[1]})
+		}
+	}
+	for _, mount := range inspect.Mounts {
+		detail.Mounts = append(detail.Mounts, &DockerMount{Type: mount.Type, Source: mount.Source, Destination: mount.Destination, ReadOnly: !mount.RW})
+	}
+	for name, network := range inspect.NetworkSettings.Networks {
+		detail.Networks[name] = network.IPAddress
+	}
+
+	if container.State == "running" {
+		var stats dockerStatsJSON
+		if err := client.get("/containers/"+inspect.ID+"/stats?stream=false", &stats); err != nil {
+			return detail, Warnings{fmt.Sprintf("stats: %v", err)}
+		}
+		container.addStats(&stats)
+	}
+	return detail, nil
+}
+
+// ContainerHandler serves /api/containers/:id, the detail of a Docker container to users allowed to see the docker collector.
+// Its environment variables are left out unless they may see the env collector as well.
+func ContainerHandler(params martini.Params, req *http.Request, r render.Render, p *permissions, rd *redactor, id *Identity) {
+	if !p.Collector(id.Role, "docker") {
+		forbidden(r, id, "docker", "docker")
+		return
+	}
+	if reveal(req) && !mayReveal(p, id) {
+		forbidden(r, id, "docker", "reveal")
+		return
+	}
+	format, err := negotiateFormat(req)
+	if err != nil {
+		apiError(r, http.StatusNotAcceptable, ErrNotAcceptable, "docker", err)
+		return
+	}
+	if !rxContainerID.MatchString(params["id"]) {
+		apiError(r, http.StatusBadRequest, ErrBadRequest, "docker", fmt.Errorf("invalid container id [%s]", params["id"]))
+		return
+	}
+
+	detail, err := dockerContainerDetail(params["id"])
+	warnings, err := collectorError(err)
+	if err == errNoContainer {
+		apiError(r, http.StatusNotFound, ErrNotFound, "docker", fmt.Errorf("no such container [%s]", params["id"]))
+		return
+	}
+	if err != nil {
+		apiError(r, http.StatusBadGateway, ErrUpstreamFailed, "docker", err)
+		return
+	}
+	writeWarnings(r, warnings)
+	if !p.Collector(id.Role, "env") { // the environment of a container is as sensitive as that of the dashboard
+		detail.Env = []*Env{}
+	}
+
+	if !reveal(req) {
+		renderFormat(r, format, rd.Redact(detail))
+		return
+	}
+	renderFormat(r, format, detail)
+}
+
+// ContainerPageHandler serves the container detail page, which works entirely off /api/containers/:id.
+func ContainerPageHandler(r render.Render) {
+	r.HTML(http.StatusOK, "container", View("Container"), render.HTMLOptions{})
+}
@@ -0,0 +1,228 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeDocker serves canned Docker Engine API answers on a unix socket and points DASHBOARD_DOCKER_SOCKET to it.
+func fakeDocker(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "dashboard-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answer := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(body))
+		}
+	}
+	web := `{"Id":"abc123","Name":"/web","Created":"2024-01-02T03:04:05Z","RestartCount":3,
+		"State":{"Status":"running","Pid":4242,"StartedAt":"2024-01-02T03:04:06Z","Health":{"Status":"healthy"}},
+		"Config":{"Image":"nginx:1.25","Entrypoint":["/entrypoint.sh","--api-key","hunter2"],"Cmd":["nginx","-g","daemon off;"],"Env":["PATH=/usr/bin","DB_PASSWORD=hunter2"],"Labels":{"team":"web"}},
+		"HostConfig":{"RestartPolicy":{"Name":"always"}},
+		"Mounts":[{"Type":"bind","Source":"/srv/www","Destination":"/usr/share/nginx/html","RW":false}],
+		"NetworkSettings":{"Networks":{"bridge":{"IPAddress":"172.17.0.2"}}}}`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/version", answer(`{"Version":"24.0.7","ApiVersion":"1.43"}`))
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, req *http.Request) {
+		list := `[{"Id":"def456","Names":["/batch"],"Image":"busybox","Command":"sleep 1","Created":1700000000,"State":"exited","Status":"Exited (0) 2 hours ago","Ports":[]},
+			{"Id":"abc123","Names":["/web"],"Image":"nginx:1.25","Command":"/entrypoint.sh --api-key hunter2 nginx -g 'daemon off;'","Created":1704164645,"State":"running","Status":"Up 2 hours (healthy)",
+			"Ports":[{"IP":"0.0.0.0","PrivatePort":80,"PublicPort":8080,"Type":"tcp"},{"PrivatePort":443,"Type":"tcp"},{"IP":"::","PrivatePort":80,"PublicPort":8080,"Type":"tcp"}]}]`
+		if len(req.URL.Query().Get("filters")) > 0 {
+			list = `[{"Id":"abc123","Names":["/web"],"Image":"nginx:1.25","Created":1704164645,"State":"running","Status":"Up 2 hours (healthy)","Ports":[]}]`
+		}
+		answer(list)(w, req)
+	})
+	mux.HandleFunc("/containers/abc123/json", answer(web))
+	mux.HandleFunc("/containers/web/json", answer(web))
+	mux.HandleFunc("/containers/def456/json", answer(`{"Id":"def456","Name":"/batch","RestartCount":0,"State":{"Status":"exited"}}`))
+	mux.HandleFunc("/containers/abc123/stats", answer(`{
+		"cpu_stats":{"cpu_usage":{"total_usage":3000000000},"system_cpu_usage":20000000000,"online_cpus":4},
+		"precpu_stats":{"cpu_usage":{"total_usage":2000000000},"system_cpu_usage":10000000000,"online_cpus":4},
+		"memory_stats":{"usage":209715200,"limit":1073741824,"stats":{"inactive_file":104857600}},
+		"networks":{"eth0":{"rx_bytes":1000,"tx_bytes":2000},"eth1":{"rx_bytes":24,"tx_bytes":48}},
+		"blkio_stats":{"io_service_bytes_recursive":[{"major":8,"minor":0,"op":"read","value":4096},{"major":8,"minor":0,"op":"write","value":8192}]},
+		"pids_stats":{"current":5}}`))
+	mux.HandleFunc("/images/json", answer(`[{"Id":"sha256:old","RepoTags":["<none>:<none>"],"Created":1600000000,"Size":1000,"Containers":0},
+		{"Id":"sha256:nginx","RepoTags":["nginx:1.25","nginx:latest"],"Created":1700000000,"Size":187000000,"Containers":1}]`))
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"page not found"}`))
+	})
+
+	server := httptest.NewUnstartedServer(mux)
+	server.Listener = listener
+	server.Start()
+
+	os.Setenv("DASHBOARD_DOCKER_SOCKET", socket)
+	return func() {
+		os.Unsetenv("DASHBOARD_DOCKER_SOCKET")
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_todoapp_docker_dockerPorts(t *testing.T) {
+	Expect(t, dockerPorts([]dockerPort{
+		{IP: "::", PrivatePort: 80, PublicPort: 8080, Type: "tcp"},
+		{PrivatePort: 53, Type: "udp"},
+		{IP: "0.0.0.0", PrivatePort: 80, PublicPort: 8080, Type: "tcp"},
+	}), []string{"53/udp", "0.0.0.0:8080->80/tcp", "[::]:8080->80/tcp"})
+	Expect(t, dockerPorts(nil), []string{})
+}
+
+func Test_todoapp_docker_Unavailable(t *testing.T) {
+	os.Setenv("DASHBOARD_DOCKER_SOCKET", "/nonexistent/docker.sock")
+	defer os.Unsetenv("DASHBOARD_DOCKER_SOCKET")
+
+	data, err := dockerContainers()
+	Expect(t, err, nil)
+	Expect(t, data.Available, false)
+	Expect(t, len(data.Containers), 0)
+	images, err := dockerImages()
+	Expect(t, err, nil)
+	Expect(t, len(images), 0)
+	_, err = dockerContainerDetail("web")
+	Expect(t, err, errNoContainer)
+}
+
+func Test_todoapp_docker_Containers(t *testing.T) {
+	defer fakeDocker(t)()
+
+	data, err := dockerContainers()
+	Expect(t, err, nil)
+	Expect(t, data.Available, true)
+	Expect(t, data.Version, "24.0.7")
+	Expect(t, len(data.Containers), 2)
+
+	web := data.Containers[0]
+	Expect(t, web.Name, "web")
+	Expect(t, web.RestartCount, 3)
+	Expect(t, web.Ports, []string{"0.0.0.0:8080->80/tcp", "[::]:8080->80/tcp", "443/tcp"})
+	Expect(t, web.CPUPercent, 40.0)
+	Expect(t, web.MemoryBytes, uint64(100<<20))
+	Expect(t, web.MemoryLimitBytes, uint64(1<<30))
+	Expect(t, web.MemoryPercent, 9.8)
+	Expect(t, web.NetworkReceiveBytes, uint64(1024))
+	Expect(t, web.NetworkTransmitBytes, uint64(2048))
+	Expect(t, web.BlockReadBytes, uint64(4096))
+	Expect(t, web.BlockWriteBytes, uint64(8192))
+	Expect(t, web.Pids, uint64(5))
+
+	batch := data.Containers[1]
+	Expect(t, batch.Name, "batch")
+	Expect(t, batch.State, "exited")
+	Expect(t, batch.CPUPercent, 0.0)
+	Expect(t, batch.Created.Unix(), int64(1700000000))
+}
+
+func Test_todoapp_docker_Images(t *testing.T) {
+	defer fakeDocker(t)()
+
+	images, err := dockerImages()
+	Expect(t, err, nil)
+	Expect(t, len(images), 2)
+	Expect(t, images[0].Tags, []string{"nginx:1.25", "nginx:latest"})
+	Expect(t, images[0].SizeBytes, uint64(187000000))
+	Expect(t, images[1].Tags, []string{})
+}
+
+func Test_todoapp_docker_ContainerDetail(t *testing.T) {
+	defer fakeDocker(t)()
+
+	detail, err := dockerContainerDetail("web")
+	Expect(t, err, nil)
+	Expect(t, detail.Container.ID, "abc123")
+	Expect(t, detail.Container.RestartCount, 3)
+	Expect(t, detail.Container.CPUPercent, 40.0)
+	Expect(t, detail.Health, "healthy")
+	Expect(t, detail.RestartPolicy, "always")
+	Expect(t, detail.Networks, map[string]string{"bridge": "172.17.0.2"})
+	Expect(t, *detail.Mounts[0], DockerMount{Type: "bind", Source: "/srv/www", Destination: "/usr/share/nginx/html", ReadOnly: true})
+	Expect(t, *detail.Env[1], Env{"DB_PASSWORD", "hunter2"})
+
+	_, err = dockerContainerDetail("missing")
+	Expect(t, err, errNoContainer)
+}
+
+func Test_todoapp_api_GetContainers(t *testing.T) {
+	defer fakeDocker(t)()
+
+	var data DockerContainers
+	response := getV2(t, "/api/containers", &data)
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, len(data.Containers), 2)
+	NotContain(t, response.Body.String(), "hunter2")
+
+	var images []*DockerImage
+	response = getV2(t, "/api/v2/images", &images)
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, len(images), 2)
+}
+
+func Test_todoapp_api_GetContainer(t *testing.T) {
+	defer fakeDocker(t)()
+
+	var detail DockerContainerDetail
+	response := getV2(t, "/api/containers/web", &detail)
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, detail.Container.Name, "web")
+	NotContain(t, response.Body.String(), "hunter2")
+	Contain(t, response.Body.String(), redactedValue)
+
+	Expect(t, detail.Entrypoint, []string{"/entrypoint.sh", "--api-key", redactedValue})
+	Expect(t, detail.Cmd, []string{"nginx", "-g", "daemon off;"})
+	Expect(t, len(detail.Env), 2)
+
+	response = getV2(t, "/api/containers/missing", nil)
+	Expect(t, response.Code, http.StatusNotFound)
+	var body errorBody
+	json.Unmarshal(response.Body.Bytes(), &body)
+	Expect(t, body.Error.Code, ErrNotFound)
+
+	response = getV2(t, "/api/containers/..", nil)
+	Expect(t, response.Code, http.StatusBadRequest)
+}
+
+func Test_todoapp_api_GetContainer_WithoutEnv(t *testing.T) {
+	// viewers may see containers here, but not environment variables
+	os.Setenv("DASHBOARD_PERMISSIONS", "collector:docker=viewer")
+	defer os.Unsetenv("DASHBOARD_PERMISSIONS")
+	defer fakeDocker(t)()
+	m, cleanup := setupAuthMartini(t)
+	defer cleanup()
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/api/containers/web", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer s3cr3t-t0k3n")
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+
+	var detail DockerContainerDetail
+	if err := json.Unmarshal(response.Body.Bytes(), &detail); err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, detail.Container.Name, "web")
+	Expect(t, len(detail.Env), 0)
+	NotContain(t, response.Body.String(), "DB_PASSWORD")
+}
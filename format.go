@@ -0,0 +1,401 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/martini-contrib/render"
+	"gopkg.in/yaml.v2"
+)
+
+// formats maps the names accepted by ?format= to their content type.
+var formats = map[string]string{
+	"json":   "application/json; charset=UTF-8",
+	"csv":    "text/csv; charset=UTF-8",
+	"tsv":    "text/tab-separated-values; charset=UTF-8",
+	"yaml":   "application/yaml; charset=UTF-8",
+	"ndjson": "application/x-ndjson; charset=UTF-8",
+	"text":   "text/plain; charset=UTF-8",
+}
+
+// mediaTypes maps the media types of an Accept header to a format.
+var mediaTypes = map[string]string{
+	"*/*":                       "json",
+	"application/*":             "json",
+	"application/json":          "json",
+	"text/csv":                  "csv",
+	"text/tab-separated-values": "tsv",
+	"application/yaml":          "yaml",
+	"application/x-yaml":        "yaml",
+	"text/yaml":                 "yaml",
+	"text/x-yaml":               "yaml",
+	"application/x-ndjson":      "ndjson",
+	"application/ndjson":        "ndjson",
+	"text/plain":                "text",
+}
+
+// negotiateFormat picks the output format from ?format= or otherwise the Accept header.
+// An unsupported ?format= is an error, an Accept header without any supported type falls back to JSON.
+func negotiateFormat(req *http.Request) (string, error) {
+	if format := req.URL.Query().Get("format"); len(format) > 0 {
+		return parseFormat(format)
+	}
+
+	format, quality := "json", 0.0
+	for _, mediaRange := range strings.Split(req.Header.Get("Accept"), ",") {
+		params := strings.Split(mediaRange, ";")
+		q := 1.0
+		for _, param := range params[1:] {
+			if value := trim(param); strings.HasPrefix(value, "q=") {
+				if parsed, err := strconv.ParseFloat(value[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		// on equal quality the first listed type wins
+		if candidate, ok := mediaTypes[strings.ToLower(trim(params[0]))]; ok && q > quality {
+			format, quality = candidate, q
+		}
+	}
+	return format, nil
+}
+
+// parseFormat validates a format name, "table" is accepted as an alias of "text".
+func parseFormat(name string) (string, error) {
+	format := strings.ToLower(name)
+	if format == "table" {
+		format = "text"
+	}
+	if _, ok := formats[format]; !ok {
+		return "", fmt.Errorf("unsupported format [%s]", name)
+	}
+	return format, nil
+}
+
+// renderFormat writes data in the given format, tabular formats get one row per record.
+func renderFormat(r render.Render, format string, data interface{}) {
+	r.Header().Add("Vary", "Accept")
+	if format == "json" {
+		r.JSON(http.StatusOK, data)
+		return
+	}
+
+	out, err := encodeFormat(format, data)
+	if err != nil {
+		apiError(r, http.StatusInternalServerError, ErrCollectorFailed, "", err)
+		return
+	}
+	r.Header().Set("Content-Type", formats[format])
+	r.Data(http.StatusOK, out)
+}
+
+// encodeFormat returns data in the given format, JSON is indented the same way as the API renders it.
+func encodeFormat(format string, data interface{}) (out []byte, err error) {
+	switch format {
+	case "json":
+		out, err = json.MarshalIndent(data, "", "  ")
+		out = append(out, '\n')
+	case "csv":
+		out, err = formatCSV(records(data))
+	case "tsv":
+		out = formatTSV(records(data))
+	case "yaml":
+		out, err = formatYAML(data)
+	case "ndjson":
+		out, err = formatNDJSON(records(data))
+	case "text":
+		out = formatTable(records(data))
+	}
+	return out, err
+}
+
+type field struct {
+	Name  string
+	Value interface{}
+}
+
+// record is a flattened row of a payload, nested structs become dotted column names.
+type record []field
+
+func (r record) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, f := range r {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		name, _ := json.Marshal(f.Name)
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteString(":")
+		buf.Write(value)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+// records turns a payload into rows: slices give one row per element, maps one row per key,
+// structs holding a list (like Top) give the rows of that list, structs made up of the same
+// struct type (like Memory) one row per field, and any other struct a single row.
+func records(data interface{}) (rows []record) {
+	v := indirect(reflect.ValueOf(data))
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			rows = append(rows, toRecord(v.Index(i)))
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, key := range keys {
+			rows = append(rows, record{{"Key", key.Interface()}, {"Value", v.MapIndex(key).Interface()}})
+		}
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return []record{toRecord(v)}
+		}
+		if list, ok := structList(v); ok {
+			return records(list.Interface())
+		}
+		if sameStructFields(v) {
+			for i := 0; i < v.NumField(); i++ {
+				row := record{{"Name", v.Type().Field(i).Name}}
+				rows = append(rows, flatten(v.Field(i), "", row))
+			}
+			return rows
+		}
+		rows = append(rows, toRecord(v))
+	case reflect.Invalid:
+	default:
+		rows = append(rows, toRecord(v))
+	}
+	return rows
+}
+
+// structList returns the only field of v that is a list of structs.
+func structList(v reflect.Value) (list reflect.Value, ok bool) {
+	for i := 0; i < v.NumField(); i++ {
+		t := v.Field(i).Type()
+		if t.Kind() != reflect.Slice {
+			continue
+		}
+		elem := t.Elem()
+		for elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if elem.Kind() == reflect.Struct && elem != timeType {
+			if ok {
+				return reflect.Value{}, false
+			}
+			list, ok = v.Field(i), true
+		}
+	}
+	return list, ok
+}
+
+func sameStructFields(v reflect.Value) bool {
+	if v.NumField() < 2 {
+		return false
+	}
+	first := v.Type().Field(0).Type
+	for i := 0; i < v.NumField(); i++ {
+		if t := v.Type().Field(i).Type; t != first || t.Kind() != reflect.Struct || t == timeType {
+			return false
+		}
+	}
+	return true
+}
+
+func toRecord(v reflect.Value) record {
+	v = indirect(v)
+	if v.Kind() == reflect.Struct && v.Type() != timeType {
+		return flatten(v, "", nil)
+	}
+	if !v.IsValid() {
+		return record{{"Value", nil}}
+	}
+	return record{{"Value", v.Interface()}}
+}
+
+func flatten(v reflect.Value, prefix string, row record) record {
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		if len(structField.PkgPath) > 0 {
+			continue
+		}
+		name := structField.Name
+		if tag := strings.Split(structField.Tag.Get("json"), ","); tag[0] == "-" {
+			continue
+		} else if len(tag[0]) > 0 {
+			name = tag[0]
+		}
+
+		value := indirect(v.Field(i))
+		switch {
+		case !value.IsValid():
+			row = append(row, field{prefix + name, nil})
+		case value.Kind() == reflect.Struct && value.Type() != timeType:
+			row = flatten(value, prefix+name+".", row)
+		default:
+			row = append(row, field{prefix + name, value.Interface()})
+		}
+	}
+	return row
+}
+
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// columns returns the union of all column names in order of their first appearance.
+func columns(rows []record) (names []string) {
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		for _, f := range row {
+			if !seen[f.Name] {
+				seen[f.Name] = true
+				names = append(names, f.Name)
+			}
+		}
+	}
+	return names
+}
+
+func table(rows []record) (header []string, cells [][]string) {
+	header = columns(rows)
+	for _, row := range rows {
+		values := make(map[string]interface{})
+		for _, f := range row {
+			values[f.Name] = f.Value
+		}
+		line := make([]string, len(header))
+		for i, name := range header {
+			line[i] = cell(values[name])
+		}
+		cells = append(cells, line)
+	}
+	return header, cells
+}
+
+func cell(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case time.Time:
+		return value.Format(time.RFC3339)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case []string:
+		return strings.Join(value, ", ")
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Slice {
+		var values []string
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, cell(indirect(v.Index(i)).Interface()))
+		}
+		return strings.Join(values, ", ")
+	}
+	return fmt.Sprint(value)
+}
+
+func formatCSV(rows []record) ([]byte, error) {
+	var buf bytes.Buffer
+	header, cells := table(rows)
+	w := csv.NewWriter(&buf)
+	if len(header) > 0 {
+		w.Write(header)
+	}
+	w.WriteAll(cells)
+	return buf.Bytes(), w.Error()
+}
+
+// formatTSV writes tab separated values without any quoting, tabs and line breaks within values become spaces.
+func formatTSV(rows []record) []byte {
+	var buf bytes.Buffer
+	header, cells := table(rows)
+	if len(header) > 0 {
+		cells = append([][]string{header}, cells...)
+	}
+	for _, line := range cells {
+		for i := range line {
+			line[i] = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ").Replace(line[i])
+		}
+		buf.WriteString(strings.Join(line, "\t") + "\n")
+	}
+	return buf.Bytes()
+}
+
+// formatTable aligns all columns for reading in a terminal.
+func formatTable(rows []record) []byte {
+	var buf bytes.Buffer
+	header, cells := table(rows)
+	if len(header) == 0 {
+		return buf.Bytes()
+	}
+	separator := make([]string, len(header))
+	for i, name := range header {
+		separator[i] = strings.Repeat("-", len(name))
+	}
+
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	for _, line := range append([][]string{header, separator}, cells...) {
+		for i := range line {
+			line[i] = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ").Replace(line[i])
+		}
+		fmt.Fprintln(w, strings.Join(line, "\t"))
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+func formatNDJSON(rows []record) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, row := range rows {
+		line, err := json.Marshal(row)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line)
+		buf.WriteString("\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// formatYAML converts data via JSON, so keys and their order are the same as in the JSON output.
+func formatYAML(data interface{}) ([]byte, error) {
+	content, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return nil, err
+	}
+	// decoding into a MapSlice keeps the order of all nested mappings too
+	var document yaml.MapSlice
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(document[0].Value)
+}
@@ -0,0 +1,157 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_todoapp_format_Negotiate(t *testing.T) {
+	for target, expected := range map[string]string{
+		"/api/cpu":              "json",
+		"/api/cpu?format=csv":   "csv",
+		"/api/cpu?format=TSV":   "tsv",
+		"/api/cpu?format=table": "text",
+	} {
+		req, _ := http.NewRequest("GET", target, nil)
+		format, err := negotiateFormat(req)
+		Expect(t, err, nil)
+		Expect(t, format, expected)
+	}
+
+	for accept, expected := range map[string]string{
+		"":                                  "json",
+		"*/*":                               "json",
+		"text/plain":                        "text",
+		"application/json, text/plain, */*": "json",
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": "json",
+		"application/json;q=0.5, text/csv":                                "csv",
+		"application/x-yaml":                                              "yaml",
+		"application/x-ndjson":                                            "ndjson",
+		"image/png":                                                       "json",
+	} {
+		req, _ := http.NewRequest("GET", "/api/cpu", nil)
+		req.Header.Set("Accept", accept)
+		format, err := negotiateFormat(req)
+		Expect(t, err, nil)
+		Expect(t, format, expected)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/cpu?format=xml", nil)
+	_, err := negotiateFormat(req)
+	NotExpect(t, err, nil)
+}
+
+func Test_todoapp_format_Records(t *testing.T) {
+	// lists inside a struct become the rows
+	top := &Top{
+		Header:    []string{"top - 10:00:00"},
+		Processes: []*Process{{User: "root", Pid: 1, Command: "init"}, {User: "alice", Pid: 42, Command: "vim"}},
+	}
+	header, cells := table(records(top))
+	Expect(t, header, []string{"User", "Pid", "Cpu", "Mem", "Vsz", "Rss", "Tty", "Stat", "Start", "Time", "Command"})
+	Expect(t, cells[1][0], "alice")
+	Expect(t, cells[1][1], "42")
+
+	// structs made up of the same type get a row per field
+	header, cells = table(records(&Memory{RAM: MemoryData{TotalM: 512, TotalH: "512M"}}))
+	Expect(t, header, []string{"Name", "TotalM", "TotalH", "UsedM", "UsedH", "FreeM", "FreeH"})
+	Expect(t, cells[0][:3], []string{"RAM", "512", "512M"})
+	Expect(t, cells[2][0], "Total")
+
+	// maps get a row per key
+	header, cells = table(records(http.Header{"B": {"2", "3"}, "A": {"1"}}))
+	Expect(t, header, []string{"Key", "Value"})
+	Expect(t, cells, [][]string{{"A", "1"}, {"B", "2, 3"}})
+
+	start := time.Date(2024, time.October, 1, 12, 0, 0, 0, time.UTC)
+	header, cells = table(records([]*ProcessV2{{Pid: 1, Start: &start}, {Pid: 2}}))
+	Expect(t, cells[0][8], "2024-10-01T12:00:00Z")
+	Expect(t, cells[1][8], "")
+
+	header, cells = table(records([]string{"10.0.0.1", "::1"}))
+	Expect(t, header, []string{"Value"})
+	Expect(t, cells, [][]string{{"10.0.0.1"}, {"::1"}})
+}
+
+func Test_todoapp_format_Output(t *testing.T) {
+	rows := records([]*Env{{"KEY", "a\tb"}, {"QUOTE", `say "hi", ok`}})
+
+	out, err := formatCSV(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := csv.NewReader(strings.NewReader(string(out))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, parsed, [][]string{{"Key", "Value"}, {"KEY", "a\tb"}, {"QUOTE", `say "hi", ok`}})
+
+	Expect(t, string(formatTSV(rows)), "Key\tValue\nKEY\ta b\nQUOTE\tsay \"hi\", ok\n")
+	Expect(t, string(formatTable(rows)), "Key    Value\n---    -----\nKEY    a b\nQUOTE  say \"hi\", ok\n")
+
+	out, err = formatNDJSON(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, string(out), "{\"Key\":\"KEY\",\"Value\":\"a\\tb\"}\n{\"Key\":\"QUOTE\",\"Value\":\"say \\\"hi\\\", ok\"}\n")
+
+	out, err = formatYAML(&CPU{Processors: 2, ModelName: "Xeon", Speed: 2000.5, Processes: "1/234"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, string(out), "Processors: 2\nModelName: Xeon\nSpeed: 2000.5\nLoad1: 0\nLoad5: 0\nLoad15: 0\nProcesses: 1/234\n")
+}
+
+func Test_todoapp_api_Formats(t *testing.T) {
+	m := setupMartini()
+
+	for format, contentType := range formats {
+		response := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://localhost:4005/api/network?format="+format, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m.ServeHTTP(response, req)
+		Expect(t, response.Code, http.StatusOK)
+		Expect(t, response.Header().Get("Content-Type"), contentType)
+		Expect(t, response.Header().Get("Vary"), "Accept")
+		Contain(t, response.Body.String(), "127.0.0.1/8")
+	}
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/api/v2/processes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/x-ndjson")
+
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+	for _, line := range strings.Split(Trim(response.Body.String()), "\n") {
+		var process ProcessV2
+		if err := json.Unmarshal([]byte(line), &process); err != nil {
+			t.Fatal(err)
+		}
+		NotExpect(t, process.Pid, 0)
+	}
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://localhost:4005/api/cpu?format=xml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusNotAcceptable)
+	Contain(t, response.Body.String(), `"Code": "not_acceptable"`)
+}
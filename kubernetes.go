@@ -0,0 +1,321 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount" // replaced in tests
+	defaultPodInfoDir = "/etc/podinfo"
+	kubernetesTimeout = 5 * time.Second
+)
+
+// hiddenAnnotations are left out, last-applied-configuration repeats the whole pod spec including env values.
+var hiddenAnnotations = map[string]bool{
+	"kubectl.kubernetes.io/last-applied-configuration": true,
+}
+
+// Kubernetes describes the pod the dashboard runs in, from the downward API and the mounted service account.
+// Resources are as exposed by the downward API, in units of the divisor of their resourceFieldRef.
+type Kubernetes struct {
+	InCluster      bool
+	Pod            string
+	Namespace      string
+	UID            string
+	Node           string
+	IP             string
+	ServiceAccount string
+	Labels         map[string]string
+	Annotations    map[string]string
+	CPURequest     string
+	CPULimit       string
+	MemoryRequest  string
+	MemoryLimit    string
+	APIServer      string
+}
+
+type KubernetesPod struct {
+	Name     string
+	Status   string // the phase, or like kubectl the reason a container is waiting, e.g. CrashLoopBackOff
+	Ready    string // ready containers of all, e.g. "1/2"
+	Restarts int
+	Node     string
+	IP       string
+	Created  time.Time
+	Self     bool // the pod of the dashboard
+}
+
+// the parts of the pod list of the Kubernetes API we use
+type kubernetesPodList struct {
+	Items []struct {
+		Metadata struct {
+			Name              string     `json:"name"`
+			CreationTimestamp time.Time  `json:"creationTimestamp"`
+			DeletionTimestamp *time.Time `json:"deletionTimestamp"`
+		} `json:"metadata"`
+		Spec struct {
+			NodeName string `json:"nodeName"`
+		} `json:"spec"`
+		Status struct {
+			Phase             string `json:"phase"`
+			Reason            string `json:"reason"`
+			PodIP             string `json:"podIP"`
+			ContainerStatuses []struct {
+				Ready        bool `json:"ready"`
+				RestartCount int  `json:"restartCount"`
+				State        struct {
+					Waiting *struct {
+						Reason string `json:"reason"`
+					} `json:"waiting"`
+				} `json:"state"`
+			} `json:"containerStatuses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// kubernetesEnv returns the first of the variables that is set. The Kubernetes docs name downward API
+// variables with a MY_ prefix, e.g. MY_POD_NAME, both spellings are accepted.
+func kubernetesEnv(names ...string) string {
+	for _, name := range names {
+		for _, key := range []string{name, "MY_" + name} {
+			if value := os.Getenv(key); len(value) > 0 {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+func readTrimmed(file string) string {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// parseDownwardMap parses the labels and annotations files of a downward API volume, lines like key="value"
+// with the value quoted like a Go string.
+func parseDownwardMap(content string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(trim(content), "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value, err := strconv.Unquote(parts[1])
+		if err != nil {
+			value = parts[1]
+		}
+		if !hiddenAnnotations[parts[0]] {
+			values[parts[0]] = value
+		}
+	}
+	return values
+}
+
+// serviceAccountName reads the name from the claims of the token, which is not verified, only the API server can.
+// Bound tokens have it in "kubernetes.io", legacy secret based tokens in a claim of its own.
+func serviceAccountName(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Kubernetes struct {
+			ServiceAccount struct {
+				Name string `json:"name"`
+			} `json:"serviceaccount"`
+		} `json:"kubernetes.io"`
+		Legacy string `json:"kubernetes.io/serviceaccount/service-account.name"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	if len(claims.Kubernetes.ServiceAccount.Name) > 0 {
+		return claims.Kubernetes.ServiceAccount.Name
+	}
+	return claims.Legacy
+}
+
+// kubernetesAPIServer is the in-cluster address of the API server, as every pod gets it in its environment.
+func kubernetesAPIServer() string {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if len(host) == 0 || len(port) == 0 {
+		return ""
+	}
+	return "https://" + net.JoinHostPort(host, port)
+}
+
+// kubernetes collects what the pod knows about itself. Outside of Kubernetes it returns InCluster false.
+func kubernetes() (*Kubernetes, error) {
+	_, err := os.Stat(serviceAccountDir)
+	data := &Kubernetes{
+		InCluster:   err == nil || len(os.Getenv("KUBERNETES_SERVICE_HOST")) > 0,
+		Labels:      map[string]string{},
+		Annotations: map[string]string{},
+	}
+	if !data.InCluster {
+		return data, nil
+	}
+	podInfo := envOrDefault("DASHBOARD_PODINFO_DIR", defaultPodInfoDir)
+	fromFile := func(name string) string {
+		return readTrimmed(filepath.Join(podInfo, name))
+	}
+	first := func(values ...string) string {
+		for _, value := range values {
+			if len(value) > 0 {
+				return value
+			}
+		}
+		return ""
+	}
+
+	data.Pod = first(kubernetesEnv("POD_NAME"), fromFile("name"), os.Getenv("HOSTNAME"))
+	data.Namespace = first(kubernetesEnv("POD_NAMESPACE"), fromFile("namespace"), readTrimmed(filepath.Join(serviceAccountDir, "namespace")))
+	data.UID = first(kubernetesEnv("POD_UID"), fromFile("uid"))
+	data.Node = first(kubernetesEnv("NODE_NAME"), fromFile("nodename"))
+	data.IP = kubernetesEnv("POD_IP")
+	data.ServiceAccount = first(kubernetesEnv("POD_SERVICE_ACCOUNT"), serviceAccountName(readTrimmed(filepath.Join(serviceAccountDir, "token"))))
+	data.CPURequest = first(kubernetesEnv("CPU_REQUEST"), fromFile("cpu_request"))
+	data.CPULimit = first(kubernetesEnv("CPU_LIMIT"), fromFile("cpu_limit"))
+	data.MemoryRequest = first(kubernetesEnv("MEM_REQUEST", "MEMORY_REQUEST"), fromFile("mem_request"))
+	data.MemoryLimit = first(kubernetesEnv("MEM_LIMIT", "MEMORY_LIMIT"), fromFile("mem_limit"))
+	data.APIServer = kubernetesAPIServer()
+	if content, err := ioutil.ReadFile(filepath.Join(podInfo, "labels")); err == nil {
+		data.Labels = parseDownwardMap(string(content))
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(podInfo, "annotations")); err == nil {
+		data.Annotations = parseDownwardMap(string(content))
+	}
+	return data, nil
+}
+
+// kubernetesClient trusts the cluster CA of the service account. The token is read per request, as bound tokens are rotated.
+func kubernetesClient() (*http.Client, error) {
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %s", filepath.Join(serviceAccountDir, "ca.crt"))
+	}
+	return &http.Client{
+		Timeout:   kubernetesTimeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}, nil
+}
+
+func kubernetesPodStatus(phase, reason string, waiting []string, deleting bool) string {
+	switch {
+	case deleting:
+		return "Terminating"
+	case len(waiting) > 0:
+		return waiting[0]
+	case len(reason) > 0: // e.g. Evicted
+		return reason
+	}
+	return phase
+}
+
+// kubernetesPods lists the pods in the namespace of the dashboard through the in-cluster API, if DASHBOARD_KUBERNETES_SIBLINGS
+// is true, optionally restricted by the label selector DASHBOARD_KUBERNETES_SELECTOR. The service account needs to be
+// allowed to list pods, failures are reported as warnings.
+func kubernetesPods() ([]*KubernetesPod, error) {
+	pods := []*KubernetesPod{}
+	if os.Getenv("DASHBOARD_KUBERNETES_SIBLINGS") != "true" {
+		return pods, nil
+	}
+	self, err := kubernetes()
+	if err != nil {
+		return nil, err
+	}
+	if !self.InCluster || len(self.APIServer) == 0 || len(self.Namespace) == 0 {
+		return pods, nil
+	}
+	fail := func(err error) ([]*KubernetesPod, error) {
+		return pods, Warnings{fmt.Sprintf("pods: %v", err)}
+	}
+
+	client, err := kubernetesClient()
+	if err != nil {
+		return fail(err)
+	}
+	query := url.Values{}
+	if selector := os.Getenv("DASHBOARD_KUBERNETES_SELECTOR"); len(selector) > 0 {
+		query.Set("labelSelector", selector)
+	}
+	req, err := http.NewRequest("GET", self.APIServer+"/api/v1/namespaces/"+url.PathEscape(self.Namespace)+"/pods?"+query.Encode(), nil)
+	if err != nil {
+		return fail(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+readTrimmed(filepath.Join(serviceAccountDir, "token")))
+	req.Header.Set("Accept", "application/json")
+	response, err := client.Do(req)
+	if err != nil {
+		return fail(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		var status struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(response.Body).Decode(&status)
+		return fail(fmt.Errorf("API server returned status %d: %s", response.StatusCode, status.Message))
+	}
+	var list kubernetesPodList
+	if err := json.NewDecoder(response.Body).Decode(&list); err != nil {
+		return fail(err)
+	}
+
+	for _, item := range list.Items {
+		var ready, restarts int
+		var waiting []string
+		for _, container := range item.Status.ContainerStatuses {
+			if container.Ready {
+				ready++
+			}
+			restarts += container.RestartCount
+			if container.State.Waiting != nil && len(container.State.Waiting.Reason) > 0 {
+				waiting = append(waiting, container.State.Waiting.Reason)
+			}
+		}
+		pods = append(pods, &KubernetesPod{
+			Name:     item.Metadata.Name,
+			Status:   kubernetesPodStatus(item.Status.Phase, item.Status.Reason, waiting, item.Metadata.DeletionTimestamp != nil),
+			Ready:    fmt.Sprintf("%d/%d", ready, len(item.Status.ContainerStatuses)),
+			Restarts: restarts,
+			Node:     item.Spec.NodeName,
+			IP:       item.Status.PodIP,
+			Created:  item.Metadata.CreationTimestamp,
+			Self:     item.Metadata.Name == self.Pod,
+		})
+	}
+	sort.SliceStable(pods, func(i, j int) bool {
+		return pods[i].Name < pods[j].Name
+	})
+	return pods, nil
+}
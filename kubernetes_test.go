@@ -0,0 +1,230 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const podList = `{"kind":"PodList","items":[
+	{"metadata":{"name":"web-7d4b9-x2x9q","creationTimestamp":"2024-01-02T03:04:05Z"},"spec":{"nodeName":"node-1"},
+	 "status":{"phase":"Running","podIP":"10.0.1.5","containerStatuses":[{"ready":true,"restartCount":0,"state":{"running":{}}}]}},
+	{"metadata":{"name":"dashboard-5c8f7-abcde","creationTimestamp":"2024-01-01T00:00:00Z"},"spec":{"nodeName":"node-2"},
+	 "status":{"phase":"Running","podIP":"10.0.2.7","containerStatuses":[{"ready":true,"restartCount":1,"state":{"running":{}}},{"ready":false,"restartCount":4,"state":{"waiting":{"reason":"CrashLoopBackOff"}}}]}},
+	{"metadata":{"name":"batch-1","creationTimestamp":"2024-01-03T00:00:00Z","deletionTimestamp":"2024-01-03T01:00:00Z"},"spec":{"nodeName":"node-1"},
+	 "status":{"phase":"Running","containerStatuses":[{"ready":true,"restartCount":0,"state":{"running":{}}}]}}
+]}`
+
+// token returns an unsigned service account token with the given claims.
+func token(claims string) string {
+	return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".signature"
+}
+
+// fakeKubernetes starts a fake API server and sets up the service account, downward API volume and environment of a pod.
+func fakeKubernetes(t *testing.T, handler http.HandlerFunc) func() {
+	server := httptest.NewTLSServer(handler)
+	dir, err := ioutil.TempDir("", "dashboard-kubernetes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	files := map[string]string{
+		"serviceaccount/ca.crt":    string(ca),
+		"serviceaccount/namespace": "shop\n",
+		"serviceaccount/token":     token(`{"kubernetes.io":{"namespace":"shop","serviceaccount":{"name":"dashboard"}}}`),
+		"podinfo/labels":           "app=\"dashboard\"\npod-template-hash=\"5c8f7\"\n",
+		"podinfo/annotations":      "kubectl.kubernetes.io/last-applied-configuration=\"{\\\"secret\\\":1}\"\nnote=\"multi\\nline\"\nvault.hashicorp.com/agent-inject-token=\"hunter2\"\n",
+		"podinfo/cpu_limit":        "2\n",
+		"podinfo/mem_limit":        "536870912\n",
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(file), 0755)
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	address, _ := url.Parse(server.URL)
+	host, port, _ := net.SplitHostPort(address.Host)
+	original := serviceAccountDir
+	serviceAccountDir = filepath.Join(dir, "serviceaccount")
+	env := map[string]string{
+		"DASHBOARD_PODINFO_DIR":   filepath.Join(dir, "podinfo"),
+		"KUBERNETES_SERVICE_HOST": host,
+		"KUBERNETES_SERVICE_PORT": port,
+		"MY_POD_NAME":             "dashboard-5c8f7-abcde",
+		"NODE_NAME":               "node-2",
+		"POD_IP":                  "10.0.2.7",
+		"CPU_REQUEST":             "1",
+	}
+	for key, value := range env {
+		os.Setenv(key, value)
+	}
+	return func() {
+		for key := range env {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("DASHBOARD_KUBERNETES_SIBLINGS")
+		os.Unsetenv("DASHBOARD_KUBERNETES_SELECTOR")
+		serviceAccountDir = original
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_todoapp_kubernetes_parseDownwardMap(t *testing.T) {
+	Expect(t, parseDownwardMap("app=\"web\"\ntier=\"front end\"\nquoted=\"say \\\"hi\\\"\"\n"), map[string]string{
+		"app":    "web",
+		"tier":   "front end",
+		"quoted": `say "hi"`,
+	})
+	Expect(t, parseDownwardMap(""), map[string]string{})
+}
+
+func Test_todoapp_kubernetes_serviceAccountName(t *testing.T) {
+	Expect(t, serviceAccountName(token(`{"kubernetes.io":{"serviceaccount":{"name":"bound"}}}`)), "bound")
+	Expect(t, serviceAccountName(token(`{"kubernetes.io/serviceaccount/service-account.name":"legacy"}`)), "legacy")
+	Expect(t, serviceAccountName("not-a-token"), "")
+}
+
+func Test_todoapp_kubernetes_NotInCluster(t *testing.T) {
+	original := serviceAccountDir
+	serviceAccountDir = "/nonexistent"
+	defer func() { serviceAccountDir = original }()
+
+	data, err := kubernetes()
+	Expect(t, err, nil)
+	Expect(t, data.InCluster, false)
+	Expect(t, data.Pod, "")
+
+	os.Setenv("DASHBOARD_KUBERNETES_SIBLINGS", "true")
+	defer os.Unsetenv("DASHBOARD_KUBERNETES_SIBLINGS")
+	pods, err := kubernetesPods()
+	Expect(t, err, nil)
+	Expect(t, len(pods), 0)
+}
+
+func Test_todoapp_kubernetes_DownwardAPI(t *testing.T) {
+	defer fakeKubernetes(t, nil)()
+
+	data, err := kubernetes()
+	Expect(t, err, nil)
+	Expect(t, data.InCluster, true)
+	Expect(t, data.Pod, "dashboard-5c8f7-abcde")
+	Expect(t, data.Namespace, "shop")
+	Expect(t, data.Node, "node-2")
+	Expect(t, data.IP, "10.0.2.7")
+	Expect(t, data.ServiceAccount, "dashboard")
+	Expect(t, data.Labels, map[string]string{"app": "dashboard", "pod-template-hash": "5c8f7"})
+	Expect(t, data.Annotations, map[string]string{"note": "multi\nline", "vault.hashicorp.com/agent-inject-token": "hunter2"})
+	Expect(t, data.CPURequest, "1")
+	Expect(t, data.CPULimit, "2")
+	Expect(t, data.MemoryLimit, "536870912")
+	Contain(t, data.APIServer, "https://127.0.0.1:")
+}
+
+func Test_todoapp_kubernetes_Pods(t *testing.T) {
+	var requested *http.Request
+	defer fakeKubernetes(t, func(w http.ResponseWriter, req *http.Request) {
+		requested = req
+		w.Write([]byte(podList))
+	})()
+
+	// listing pods is opt-in
+	pods, err := kubernetesPods()
+	Expect(t, err, nil)
+	Expect(t, len(pods), 0)
+	Expect(t, requested == nil, true)
+
+	os.Setenv("DASHBOARD_KUBERNETES_SIBLINGS", "true")
+	os.Setenv("DASHBOARD_KUBERNETES_SELECTOR", "app in (web,dashboard)")
+	pods, err = kubernetesPods()
+	Expect(t, err, nil)
+	Expect(t, requested.URL.Path, "/api/v1/namespaces/shop/pods")
+	Expect(t, requested.URL.Query().Get("labelSelector"), "app in (web,dashboard)")
+	Contain(t, requested.Header.Get("Authorization"), "Bearer eyJ")
+
+	Expect(t, len(pods), 3)
+	Expect(t, pods[0].Name, "batch-1")
+	Expect(t, pods[0].Status, "Terminating")
+	Expect(t, *pods[1], KubernetesPod{
+		Name:     "dashboard-5c8f7-abcde",
+		Status:   "CrashLoopBackOff",
+		Ready:    "1/2",
+		Restarts: 5,
+		Node:     "node-2",
+		IP:       "10.0.2.7",
+		Created:  pods[1].Created,
+		Self:     true,
+	})
+	Expect(t, pods[2].Status, "Running")
+	Expect(t, pods[2].Self, false)
+}
+
+func Test_todoapp_kubernetes_PodsForbidden(t *testing.T) {
+	defer fakeKubernetes(t, func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"kind":"Status","message":"pods is forbidden: User \"system:serviceaccount:shop:dashboard\" cannot list resource \"pods\""}`))
+	})()
+	os.Setenv("DASHBOARD_KUBERNETES_SIBLINGS", "true")
+
+	pods, err := kubernetesPods()
+	Expect(t, len(pods), 0)
+	warnings, ok := err.(Warnings)
+	Expect(t, ok, true)
+	Contain(t, warnings[0], "status 403: pods is forbidden")
+}
+
+func Test_todoapp_api_GetKubernetes(t *testing.T) {
+	defer fakeKubernetes(t, func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(podList))
+	})()
+	os.Setenv("DASHBOARD_KUBERNETES_SIBLINGS", "true")
+
+	var data Kubernetes
+	response := getV2(t, "/api/kubernetes", &data)
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, data.Namespace, "shop")
+	Expect(t, data.Annotations["note"], "multi\nline")
+	Expect(t, data.Annotations["vault.hashicorp.com/agent-inject-token"], redactedValue)
+
+	var pods []*KubernetesPod
+	response = getV2(t, "/api/v2/pods", &pods)
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, len(pods), 3)
+}
+
+func Test_todoapp_bundle_Kubernetes(t *testing.T) {
+	defer fakeKubernetes(t, func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(podList))
+	})()
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/api/bundle", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupMartini().ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+
+	// only the collector output is included, with annotations redacted and last-applied-configuration left out
+	_, files := readBundle(t, response.Body)
+	Contain(t, string(files["collectors/kubernetes.json"]), `"Namespace": "shop"`)
+	NotContain(t, string(files["collectors/kubernetes.json"]), "hunter2")
+	for name, content := range files {
+		NotContain(t, name, "sources/kubernetes/")
+		NotContain(t, string(content), "last-applied-configuration")
+	}
+}
@@ -0,0 +1,144 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var netDevSampler = newSampler(func() (interface{}, error) {
+	content, err := ioutil.ReadFile(procDir + "/net/dev")
+	if err != nil {
+		return nil, err
+	}
+	return parseNetDev(string(content))
+})
+
+type TrafficCounters struct {
+	Bytes   uint64
+	Packets uint64
+	Errors  uint64
+	Drops   uint64
+}
+
+type TrafficRates struct {
+	BytesPerSecond   float64
+	PacketsPerSecond float64
+	ErrorsPerSecond  float64
+	DropsPerSecond   float64
+}
+
+// InterfaceStat is the traffic of a network interface, rates cover the time since the previous request.
+type InterfaceStat struct {
+	Name          string
+	MAC           string
+	MTU           int
+	OperState     string
+	Duplex        string
+	SpeedMbps     int // 0 if the driver does not report it, e.g. for virtual interfaces
+	Receive       TrafficRates
+	Transmit      TrafficRates
+	ReceiveTotal  TrafficCounters
+	TransmitTotal TrafficCounters
+}
+
+// netDev is a line of /proc/net/dev.
+type netDev struct {
+	name              string
+	receive, transmit TrafficCounters
+}
+
+func parseNetDev(content string) ([]*netDev, error) {
+	var devices []*netDev
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.SplitN(scanner.Text(), ":", 2)
+		if len(line) != 2 || strings.Contains(line[0], "|") { // the two header lines
+			continue
+		}
+		fields := strings.Fields(line[1])
+		if len(fields) < 12 {
+			return nil, fmt.Errorf("invalid /proc/net/dev line [%s]", scanner.Text())
+		}
+		values := make([]uint64, 12)
+		for i := range values {
+			values[i], _ = strconv.ParseUint(fields[i], 10, 64)
+		}
+		devices = append(devices, &netDev{
+			name:     strings.TrimSpace(line[0]),
+			receive:  TrafficCounters{Bytes: values[0], Packets: values[1], Errors: values[2], Drops: values[3]},
+			transmit: TrafficCounters{Bytes: values[8], Packets: values[9], Errors: values[10], Drops: values[11]},
+		})
+	}
+	return devices, scanner.Err()
+}
+
+func trafficRates(previous, current TrafficCounters, seconds float64) TrafficRates {
+	round := func(v float64) float64 {
+		return math.Round(v*10) / 10
+	}
+	return TrafficRates{
+		BytesPerSecond:   round(rate(previous.Bytes, current.Bytes, seconds)),
+		PacketsPerSecond: round(rate(previous.Packets, current.Packets, seconds)),
+		ErrorsPerSecond:  round(rate(previous.Errors, current.Errors, seconds)),
+		DropsPerSecond:   round(rate(previous.Drops, current.Drops, seconds)),
+	}
+}
+
+// readSysNet returns an attribute of /sys/class/net/<name>, or "" if it can not be read,
+// e.g. speed and duplex of an interface that is down.
+func readSysNet(name, attribute string) string {
+	content, err := ioutil.ReadFile(filepath.Join(sysDir, "class", "net", name, attribute))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+func netStatBetween(previous, current []*netDev, seconds float64) []*InterfaceStat {
+	before := make(map[string]*netDev)
+	for _, dev := range previous {
+		before[dev.name] = dev
+	}
+
+	stats := []*InterfaceStat{}
+	for _, dev := range current {
+		old, ok := before[dev.name]
+		if !ok { // appeared since the previous reading
+			old = dev
+		}
+		stat := &InterfaceStat{
+			Name:          dev.name,
+			MAC:           readSysNet(dev.name, "address"),
+			OperState:     readSysNet(dev.name, "operstate"),
+			Duplex:        readSysNet(dev.name, "duplex"),
+			Receive:       trafficRates(old.receive, dev.receive, seconds),
+			Transmit:      trafficRates(old.transmit, dev.transmit, seconds),
+			ReceiveTotal:  dev.receive,
+			TransmitTotal: dev.transmit,
+		}
+		stat.MTU, _ = strconv.Atoi(readSysNet(dev.name, "mtu"))
+		if speed, err := strconv.Atoi(readSysNet(dev.name, "speed")); err == nil && speed > 0 {
+			stat.SpeedMbps = speed
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// netStat samples /proc/net/dev, rates cover the time since the previous call.
+func netStat() ([]*InterfaceStat, error) {
+	previous, current, seconds, err := netDevSampler.sample()
+	if err != nil {
+		return nil, err
+	}
+	return netStatBetween(previous.([]*netDev), current.([]*netDev), seconds), nil
+}
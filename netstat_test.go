@@ -0,0 +1,106 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const netDevBefore = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+  eth0: 2000000    1000    1    2    0     0          0         0   500000     800    0    0    0     0       0          0
+`
+
+const netDevAfter = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+  eth0: 4000000    2000    3    2    0     0          0         0   600000     900    0    4    0     0       0          0
+ wlan0:     500       5    0    0    0     0          0         0      100       1    0    0    0     0       0          0
+`
+
+func Test_todoapp_netstat_parseNetDev(t *testing.T) {
+	devices, err := parseNetDev(netDevBefore)
+	Expect(t, err, nil)
+	Expect(t, len(devices), 2)
+	Expect(t, devices[1].name, "eth0")
+	Expect(t, devices[1].receive, TrafficCounters{Bytes: 2000000, Packets: 1000, Errors: 1, Drops: 2})
+	Expect(t, devices[1].transmit, TrafficCounters{Bytes: 500000, Packets: 800})
+
+	_, err = parseNetDev("eth0: 1 2 3\n")
+	NotExpect(t, err, nil)
+}
+
+func Test_todoapp_netstat_netStatBetween(t *testing.T) {
+	original := sysDir
+	defer func() {
+		sysDir = original
+	}()
+	dir, err := ioutil.TempDir("", "dashboard-sys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sysDir = dir
+
+	eth0 := filepath.Join(dir, "class", "net", "eth0")
+	if err := os.MkdirAll(eth0, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for attribute, value := range map[string]string{
+		"address":   "02:42:ac:11:00:02\n",
+		"mtu":       "1500\n",
+		"operstate": "up\n",
+		"duplex":    "full\n",
+		"speed":     "1000\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(eth0, attribute), []byte(value), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before, _ := parseNetDev(netDevBefore)
+	after, _ := parseNetDev(netDevAfter)
+	stats := netStatBetween(before, after, 2)
+	Expect(t, len(stats), 3)
+
+	eth := stats[1]
+	Expect(t, eth.Name, "eth0")
+	Expect(t, eth.MAC, "02:42:ac:11:00:02")
+	Expect(t, eth.MTU, 1500)
+	Expect(t, eth.OperState, "up")
+	Expect(t, eth.Duplex, "full")
+	Expect(t, eth.SpeedMbps, 1000)
+	Expect(t, eth.Receive, TrafficRates{BytesPerSecond: 1000000, PacketsPerSecond: 500, ErrorsPerSecond: 1})
+	Expect(t, eth.Transmit, TrafficRates{BytesPerSecond: 50000, PacketsPerSecond: 50, DropsPerSecond: 2})
+	Expect(t, eth.TransmitTotal.Drops, uint64(4))
+
+	// interfaces without sysfs entries, and new ones without a previous reading
+	Expect(t, stats[0].MAC, "")
+	Expect(t, stats[0].SpeedMbps, 0)
+	Expect(t, stats[2].Name, "wlan0")
+	Expect(t, stats[2].Receive, TrafficRates{})
+	Expect(t, stats[2].ReceiveTotal.Bytes, uint64(500))
+}
+
+func Test_todoapp_api_GetNetworkTraffic(t *testing.T) {
+	var data []*InterfaceStat
+	response := getV2(t, "/api/network_traffic", &data)
+	Expect(t, response.Code, http.StatusOK)
+
+	NotExpect(t, len(data), 0)
+	var loopback bool
+	for _, i := range data {
+		if i.Name == "lo" {
+			loopback = true
+			NotExpect(t, i.MTU, 0)
+		}
+	}
+	Expect(t, loopback, true)
+}
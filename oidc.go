@@ -0,0 +1,513 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/martini-contrib/render"
+)
+
+var (
+	oidcCookieName = "dashboard_oidc"
+	oidcCookieTTL  = 10 * time.Minute
+	oidcClockSkew  = time.Minute
+)
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type oidcProvider struct {
+	issuer        string
+	clientID      string
+	clientSecret  string
+	redirectURL   string
+	scopes        []string
+	usernameClaim string
+	groupsClaim   string
+	groupRoles    map[string]Role
+	client        *http.Client
+
+	sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+// setupOIDC configures OpenID Connect single sign-on from the environment:
+//
+//	DASHBOARD_OIDC_ISSUER          issuer URL, enables OIDC login
+//	DASHBOARD_OIDC_CLIENT_ID       client id registered at the identity provider
+//	DASHBOARD_OIDC_CLIENT_SECRET   client secret, may be empty for public clients
+//	DASHBOARD_OIDC_REDIRECT_URL    callback URL, defaults to <request host>/login/oidc/callback
+//	DASHBOARD_OIDC_SCOPES          space separated scopes (default "openid profile email groups")
+//	DASHBOARD_OIDC_USERNAME_CLAIM  claim used as user name (default "preferred_username")
+//	DASHBOARD_OIDC_GROUPS_CLAIM    claim containing the user's groups (default "groups")
+//	DASHBOARD_OIDC_ROLES           comma separated group to role mapping, e.g. "ops=operator,sre=admin"
+func setupOIDC() *oidcProvider {
+	issuer := os.Getenv("DASHBOARD_OIDC_ISSUER")
+	if len(issuer) == 0 {
+		return nil
+	}
+
+	p := &oidcProvider{
+		issuer:        strings.TrimSuffix(issuer, "/"),
+		clientID:      os.Getenv("DASHBOARD_OIDC_CLIENT_ID"),
+		clientSecret:  os.Getenv("DASHBOARD_OIDC_CLIENT_SECRET"),
+		redirectURL:   os.Getenv("DASHBOARD_OIDC_REDIRECT_URL"),
+		scopes:        strings.Fields(envOrDefault("DASHBOARD_OIDC_SCOPES", "openid profile email groups")),
+		usernameClaim: envOrDefault("DASHBOARD_OIDC_USERNAME_CLAIM", "preferred_username"),
+		groupsClaim:   envOrDefault("DASHBOARD_OIDC_GROUPS_CLAIM", "groups"),
+		groupRoles:    make(map[string]Role),
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+	if len(p.clientID) == 0 {
+		log.Fatalf("DASHBOARD_OIDC_CLIENT_ID is required when DASHBOARD_OIDC_ISSUER is set")
+	}
+
+	if value := os.Getenv("DASHBOARD_OIDC_ROLES"); len(value) > 0 {
+		for _, mapping := range strings.Split(value, ",") {
+			values := strings.SplitN(mapping, "=", 2)
+			if len(values) != 2 {
+				log.Fatalf("Invalid DASHBOARD_OIDC_ROLES, expected group=role: [%s]", mapping)
+			}
+			role, err := parseRole(values[1])
+			if err != nil {
+				log.Fatalf("Invalid DASHBOARD_OIDC_ROLES: %v", err)
+			}
+			p.groupRoles[trim(values[0])] = role
+		}
+	}
+
+	return p
+}
+
+func envOrDefault(key, value string) string {
+	if env := os.Getenv(key); len(env) > 0 {
+		return env
+	}
+	return value
+}
+
+// discover fetches and caches the provider metadata from the issuer's well-known endpoint.
+func (p *oidcProvider) discover() (*oidcDiscovery, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := p.getJSON(p.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("issuer mismatch in discovery document: [%s]", discovery.Issuer)
+	}
+	if len(discovery.AuthorizationEndpoint) == 0 || len(discovery.TokenEndpoint) == 0 || len(discovery.JwksURI) == 0 {
+		return nil, errors.New("discovery document is missing required endpoints")
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+func (p *oidcProvider) getJSON(url string, v interface{}) error {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// key returns the RSA signing key with the given key id, refreshing the JWKS if it is unknown.
+func (p *oidcProvider) key(kid string) (*rsa.PublicKey, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(discovery.JwksURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	p.keys = make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" || (len(jwk.Use) > 0 && jwk.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			continue
+		}
+		p.keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key [%s]", kid)
+}
+
+// verify checks signature, issuer, audience, expiry and nonce of an ID token and returns its claims.
+func (p *oidcProvider) verify(idToken, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported ID token algorithm [%s]", header.Alg)
+	}
+
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed ID token signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("invalid ID token signature")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != p.issuer {
+		return nil, fmt.Errorf("unexpected ID token issuer [%s]", iss)
+	}
+	if !containsString(stringsClaim(claims["aud"]), p.clientID) {
+		return nil, errors.New("ID token was not issued for this client")
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.Add(-oidcClockSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("ID token is expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && now.Add(oidcClockSkew).Before(time.Unix(int64(iat), 0)) {
+		return nil, errors.New("ID token is issued in the future")
+	}
+	if n, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(n), []byte(nonce)) != 1 {
+		return nil, errors.New("ID token nonce mismatch")
+	}
+
+	return claims, nil
+}
+
+// identity maps the ID token claims to a dashboard identity, the highest role of all groups wins.
+func (p *oidcProvider) identity(claims map[string]interface{}, roles *roles) *Identity {
+	name, _ := claims[p.usernameClaim].(string)
+	if len(name) == 0 {
+		name, _ = claims["sub"].(string)
+	}
+
+	role := RoleNone
+	for _, group := range stringsClaim(claims[p.groupsClaim]) {
+		if groupRole, ok := p.groupRoles[group]; ok && groupRole > role {
+			role = groupRole
+		}
+	}
+	if role == RoleNone {
+		role = roles.For(name)
+	}
+	return &Identity{Name: name, Method: "oidc", Role: role}
+}
+
+func (p *oidcProvider) redirectURI(req *http.Request) string {
+	if len(p.redirectURL) > 0 {
+		return p.redirectURL
+	}
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + "/login/oidc/callback"
+}
+
+func (p *oidcProvider) exchange(discovery *oidcDiscovery, code, verifier, redirectURI string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.clientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if len(p.clientSecret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed: %s", resp.Status)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", err
+	}
+	if len(token.IDToken) == 0 {
+		return "", errors.New("token response did not contain an ID token")
+	}
+	return token.IDToken, nil
+}
+
+// oidcState is kept in a short-lived signed cookie between the redirect to the provider and the callback.
+type oidcState struct {
+	State    string
+	Nonce    string
+	Verifier string
+	Next     string
+	Expires  int64
+}
+
+func (s *sessions) issueOIDCState(res http.ResponseWriter, req *http.Request, state *oidcState) {
+	data, _ := json.Marshal(state)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	http.SetCookie(res, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    payload + "." + s.sign(payload),
+		Path:     "/login/oidc",
+		MaxAge:   int(oidcCookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *sessions) oidcState(req *http.Request) (*oidcState, error) {
+	cookie, err := req.Cookie(oidcCookieName)
+	if err != nil {
+		return nil, errors.New("login state is missing, please try again")
+	}
+	values := strings.SplitN(cookie.Value, ".", 2)
+	if len(values) != 2 || !hmac.Equal([]byte(values[1]), []byte(s.sign(values[0]))) {
+		return nil, errors.New("login state is invalid")
+	}
+
+	var state oidcState
+	if err := decodeSegment(values[0], &state); err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > state.Expires {
+		return nil, errors.New("login state is expired, please try again")
+	}
+	return &state, nil
+}
+
+func (s *sessions) clearOIDCState(res http.ResponseWriter) {
+	http.SetCookie(res, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    "",
+		Path:     "/login/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// OIDCLoginHandler redirects to the identity provider using the authorization code flow with PKCE.
+func OIDCLoginHandler(a *auth, res http.ResponseWriter, req *http.Request, r render.Render) {
+	if a.oidc == nil {
+		r.Redirect("/login")
+		return
+	}
+	discovery, err := a.oidc.discover()
+	if err != nil {
+		oidcFailed(a, r, err)
+		return
+	}
+
+	state := &oidcState{
+		State:    randomString(24),
+		Nonce:    randomString(24),
+		Verifier: randomString(48),
+		Next:     safeRedirect(req.URL.Query().Get("next")),
+		Expires:  time.Now().Add(oidcCookieTTL).Unix(),
+	}
+	a.sessions.issueOIDCState(res, req, state)
+
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {a.oidc.clientID},
+		"redirect_uri":          {a.oidc.redirectURI(req)},
+		"scope":                 {strings.Join(a.oidc.scopes, " ")},
+		"state":                 {state.State},
+		"nonce":                 {state.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	r.Redirect(discovery.AuthorizationEndpoint + separator + query.Encode())
+}
+
+// OIDCCallbackHandler completes the login, validates the ID token and starts a dashboard session.
+func OIDCCallbackHandler(a *auth, res http.ResponseWriter, req *http.Request, r render.Render) {
+	if a.oidc == nil {
+		r.Redirect("/login")
+		return
+	}
+	query := req.URL.Query()
+	if e := query.Get("error"); len(e) > 0 {
+		oidcFailed(a, r, fmt.Errorf("identity provider returned [%s]: %s", e, query.Get("error_description")))
+		return
+	}
+
+	state, err := a.sessions.oidcState(req)
+	if err != nil {
+		oidcFailed(a, r, err)
+		return
+	}
+	a.sessions.clearOIDCState(res)
+	if subtle.ConstantTimeCompare([]byte(state.State), []byte(query.Get("state"))) != 1 {
+		oidcFailed(a, r, errors.New("login state mismatch"))
+		return
+	}
+
+	discovery, err := a.oidc.discover()
+	if err != nil {
+		oidcFailed(a, r, err)
+		return
+	}
+	idToken, err := a.oidc.exchange(discovery, query.Get("code"), state.Verifier, a.oidc.redirectURI(req))
+	if err != nil {
+		oidcFailed(a, r, err)
+		return
+	}
+	claims, err := a.oidc.verify(idToken, state.Nonce)
+	if err != nil {
+		oidcFailed(a, r, err)
+		return
+	}
+
+	identity := a.oidc.identity(claims, a.roles)
+	if len(identity.Name) == 0 {
+		oidcFailed(a, r, errors.New("ID token does not identify a user"))
+		return
+	}
+	a.sessions.Issue(res, req, identity)
+	r.Redirect(state.Next)
+}
+
+func oidcFailed(a *auth, r render.Render, err error) {
+	log.Printf("OIDC login failed: %v\n", err)
+	r.HTML(http.StatusUnauthorized, "login", a.loginView("/", err), render.HTMLOptions{})
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// stringsClaim accepts claims that are either a single string or a list of strings.
+func stringsClaim(claim interface{}) (result []string) {
+	switch value := claim.(type) {
+	case string:
+		result = append(result, value)
+	case []interface{}:
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
+
+func containsString(list []string, value string) bool {
+	for _, s := range list {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
+
+func randomString(length int) string {
+	data := make([]byte, length)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,295 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+// mockIssuer is a minimal OpenID Connect provider which logs in every user as "bob".
+type mockIssuer struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	subject   string
+	username  string // left out of the ID token if empty
+	groups    []string
+	challenge string
+	nonce     string
+	issuer    string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, subject: "1234", username: "bob", groups: []string{"staff", "dashboard-ops"}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "test-key",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		m.challenge = query.Get("code_challenge")
+		m.nonce = query.Get("nonce")
+		http.Redirect(w, r, query.Get("redirect_uri")+"?code=test-code&state="+url.QueryEscape(query.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if r.FormValue("code") != "test-code" || base64.RawURLEncoding.EncodeToString(verifier[:]) != m.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		claims := map[string]interface{}{
+			"iss":    m.issuer,
+			"aud":    "dashboard",
+			"sub":    m.subject,
+			"groups": m.groups,
+			"nonce":  m.nonce,
+			"iat":    time.Now().Unix(),
+			"exp":    time.Now().Add(time.Hour).Unix(),
+		}
+		if len(m.username) > 0 {
+			claims["preferred_username"] = m.username
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     m.sign(t, claims),
+		})
+	})
+
+	m.Server = httptest.NewServer(mux)
+	m.issuer = m.URL
+	return m
+}
+
+func (m *mockIssuer) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func setupOIDCMartini(t *testing.T) (*martini.Martini, *mockIssuer, func()) {
+	issuer := newMockIssuer(t)
+	os.Setenv("DASHBOARD_OIDC_ISSUER", issuer.URL)
+	os.Setenv("DASHBOARD_OIDC_CLIENT_ID", "dashboard")
+	os.Setenv("DASHBOARD_OIDC_ROLES", "dashboard-ops=operator,dashboard-admins=admin")
+	m := setupMartini()
+
+	return m, issuer, func() {
+		os.Unsetenv("DASHBOARD_OIDC_ISSUER")
+		os.Unsetenv("DASHBOARD_OIDC_CLIENT_ID")
+		os.Unsetenv("DASHBOARD_OIDC_ROLES")
+		issuer.Close()
+	}
+}
+
+// oidcLogin follows the redirects of the login flow like a browser would and returns the final response.
+func oidcLogin(t *testing.T, m *martini.Martini) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/login/oidc?next=/%23cpu", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusFound)
+	state := response.Result().Cookies()
+
+	location, err := url.Parse(response.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, location.Query().Get("code_challenge_method"), "S256")
+	Expect(t, location.Query().Get("redirect_uri"), "http://localhost:4005/login/oidc/callback")
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(location.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", resp.Header.Get("Location"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range state {
+		req.AddCookie(cookie)
+	}
+	m.ServeHTTP(response, req)
+	return response
+}
+
+func Test_todoapp_oidc_Login(t *testing.T) {
+	m, _, cleanup := setupOIDCMartini(t)
+	defer cleanup()
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/login", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+	Contain(t, response.Body.String(), `Login with Single Sign-On`)
+	NotContain(t, response.Body.String(), `<form method="POST" action="/login">`)
+
+	response = oidcLogin(t, m)
+	Expect(t, response.Code, http.StatusFound)
+	Expect(t, response.Header().Get("Location"), "/#cpu")
+
+	var session *http.Cookie
+	for _, cookie := range response.Result().Cookies() {
+		if cookie.Name == sessionCookieName {
+			session = cookie
+		}
+	}
+	if session == nil {
+		t.Fatal("no session cookie issued")
+	}
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://localhost:4005/api/permissions", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(session)
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+	body := response.Body.String()
+	Contain(t, body, `"Name": "bob"`)
+	Contain(t, body, `"Method": "oidc"`)
+	Contain(t, body, `"Role": "operator"`)
+}
+
+func Test_todoapp_oidc_SubjectAsName(t *testing.T) {
+	m, issuer, cleanup := setupOIDCMartini(t)
+	defer cleanup()
+
+	// without a username claim the subject is the name, subjects like this one contain "|"
+	issuer.subject, issuer.username = "auth0|abc123", ""
+	response := oidcLogin(t, m)
+	Expect(t, response.Code, http.StatusFound)
+	var session *http.Cookie
+	for _, cookie := range response.Result().Cookies() {
+		if cookie.Name == sessionCookieName {
+			session = cookie
+		}
+	}
+	if session == nil {
+		t.Fatal("no session cookie issued")
+	}
+
+	response = httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/api/permissions", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(session)
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+	Contain(t, response.Body.String(), `"Name": "auth0|abc123"`)
+}
+
+func Test_todoapp_oidc_InvalidToken(t *testing.T) {
+	m, issuer, cleanup := setupOIDCMartini(t)
+	defer cleanup()
+
+	issuer.issuer = "https://evil.example.com"
+	response := oidcLogin(t, m)
+	Expect(t, response.Code, http.StatusUnauthorized)
+	Contain(t, response.Body.String(), `unexpected ID token issuer [https://evil.example.com]`)
+
+	response = httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/login/oidc/callback?code=test-code&state=forged", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusUnauthorized)
+	Contain(t, response.Body.String(), `login state is missing`)
+}
+
+func Test_todoapp_oidc_Verify(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+	os.Setenv("DASHBOARD_OIDC_ISSUER", issuer.URL)
+	os.Setenv("DASHBOARD_OIDC_CLIENT_ID", "dashboard")
+	defer os.Unsetenv("DASHBOARD_OIDC_ISSUER")
+	defer os.Unsetenv("DASHBOARD_OIDC_CLIENT_ID")
+	p := setupOIDC()
+
+	claims := map[string]interface{}{
+		"iss":   issuer.URL,
+		"aud":   []string{"other", "dashboard"},
+		"sub":   "1234",
+		"nonce": "n0nce",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	_, err := p.verify(issuer.sign(t, claims), "n0nce")
+	Expect(t, err, nil)
+
+	_, err = p.verify(issuer.sign(t, claims), "other")
+	Expect(t, err.Error(), "ID token nonce mismatch")
+
+	token := issuer.sign(t, claims)
+	_, err = p.verify(token[:len(token)-4]+"AAAA", "n0nce")
+	Expect(t, err.Error(), "invalid ID token signature")
+
+	claims["aud"] = "other"
+	_, err = p.verify(issuer.sign(t, claims), "n0nce")
+	Expect(t, err.Error(), "ID token was not issued for this client")
+
+	claims["aud"] = "dashboard"
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	_, err = p.verify(issuer.sign(t, claims), "n0nce")
+	Expect(t, err.Error(), "ID token is expired")
+
+	identity := p.identity(map[string]interface{}{"sub": "1234", "groups": "unknown"}, &roles{defaultRole: RoleViewer})
+	Expect(t, identity.Name, "1234")
+	Expect(t, identity.Role, RoleViewer)
+	Expect(t, identity.Method, "oidc")
+}
@@ -0,0 +1,225 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+)
+
+var (
+	apiVersion  = "2.0.0"
+	rxPathParam = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+)
+
+// binaryRoutes don't answer with JSON, but a download of the given content type.
+var binaryRoutes = map[string]string{
+	"/api/bundle": "application/gzip",
+}
+
+// queryParameters documents the query parameters of non-collector routes.
+var queryParameters = map[string][]map[string]string{
+	"/api/check/:name": {
+		{"name": "warning", "description": "Warning threshold in Nagios range format, e.g. 80 or 10:"},
+		{"name": "critical", "description": "Critical threshold in Nagios range format"},
+		{"name": "mount", "description": "disk: only check the filesystem mounted here"},
+		{"name": "command", "description": "processes: only count processes whose command line contains this"},
+		{"name": "format", "description": "text for the plugin output line, json (default) or any other output format"},
+	},
+	"/api/diff": {
+		{"name": "from", "description": "Snapshot to compare from, defaults to the oldest stored snapshot"},
+		{"name": "to", "description": "Snapshot to compare to, defaults to \"current\""},
+		{"name": "threshold", "description": "Relative change of metrics to report, defaults to 0.1"},
+	},
+}
+
+// errorBody is the envelope every API error is rendered in, see apiError.
+type errorBody struct {
+	Error *APIError
+}
+
+// apiDocs returns the documentation of all known JSON routes by their pattern,
+// routes without documentation still show up in the OpenAPI document with a generic payload.
+func apiDocs() map[string]apiEndpoint {
+	docs := map[string]apiEndpoint{
+		"/api/permissions":      {Payload: permissionsView{}, Summary: "Identity, role and allowed collectors and actions of the caller"},
+		"/api/openapi.json":     {Payload: map[string]interface{}{}, Summary: "This OpenAPI document"},
+		"/api/bundle":           {Payload: []byte{}, Summary: "Support bundle with the output and raw sources of all collectors, the version and the redacted config"},
+		"/api/snapshots":        {Payload: snapshotsView{}, Summary: "Stored snapshots and fleet hosts, POST takes a new snapshot"},
+		"/api/snapshots/:id":    {Payload: Snapshot{}, Summary: "A stored snapshot, \"current\" for the state right now or \"host:<name>\" for a fleet host"},
+		"/api/check/:name":      {Payload: CheckResult{}, Summary: "Nagios plugin check of cpu, mem, disk or processes, the plugin output line with ?format=text"},
+		"/api/containers/:id":   {Payload: DockerContainerDetail{}, Summary: "A Docker container by id or name with its configuration, mounts, networks, health and live stats"},
+		"/api/diff":             {Payload: SnapshotDiff{}, Summary: "Difference between the snapshots ?from= and ?to=, metrics changed by at least ?threshold="},
+		"/api/v2/schemas":       {Payload: map[string]string{}, Summary: "URLs of the JSON Schemas of all /api/v2 payloads"},
+		"/api/v2/schemas/:name": {Payload: map[string]interface{}{}, Summary: "JSON Schema of an /api/v2 payload"},
+	}
+	for _, endpoint := range v1Endpoints {
+		docs["/api/"+endpoint.Path] = endpoint
+	}
+	for _, endpoint := range v2Endpoints {
+		docs["/api/v2/"+endpoint.Path] = endpoint
+	}
+	return docs
+}
+
+// openAPI generates an OpenAPI 3 document of all registered GET routes below /api.
+func openAPI(routes martini.Routes, p *permissions) map[string]interface{} {
+	docs := apiDocs()
+	components := make(map[string]interface{})
+	paths := make(map[string]interface{})
+
+	for _, route := range routes.All() {
+		pattern := route.Pattern()
+		if route.Method() != "GET" || !isAPIPath(pattern) || strings.HasPrefix(pattern, "/api/debug/") {
+			continue
+		}
+		doc, documented := docs[pattern]
+		if !documented {
+			doc = apiEndpoint{Payload: map[string]interface{}{}}
+		}
+
+		tag := "v1"
+		if strings.HasPrefix(pattern, "/api/v2/") {
+			tag = "v2"
+		}
+		content := map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": schemaFor(reflect.TypeOf(doc.Payload), components),
+			},
+		}
+		if contentType, ok := binaryRoutes[pattern]; ok {
+			content = map[string]interface{}{
+				contentType: map[string]interface{}{
+					"schema": map[string]interface{}{"type": "string", "format": "binary"},
+				},
+			}
+		}
+		ok := map[string]interface{}{"description": "OK", "content": content}
+		responses := map[string]interface{}{"200": ok}
+		for _, status := range []string{"401", "403", "404", "500"} {
+			responses[status] = map[string]interface{}{"$ref": "#/components/responses/Error"}
+		}
+		operation := map[string]interface{}{
+			"operationId": "get_" + strings.NewReplacer("/", "_", ":", "", ".", "_").Replace(strings.TrimPrefix(pattern, "/api/")),
+			"summary":     doc.Summary,
+			"tags":        []string{tag},
+			"responses":   responses,
+		}
+
+		var parameters []interface{}
+		for _, match := range rxPathParam.FindAllStringSubmatch(pattern, -1) {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, parameter := range queryParameters[pattern] {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        parameter["name"],
+				"in":          "query",
+				"description": parameter["description"],
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+		if len(doc.Method) > 0 {
+			operation["description"] = fmt.Sprintf("Collector [%s], requires the [%s] role.", doc.Method, p.collectors[doc.Method])
+			operation["x-collector"] = doc.Method
+			if sensitiveCollectors[doc.Method] {
+				parameters = append(parameters, map[string]interface{}{
+					"name":        "reveal",
+					"in":          "query",
+					"description": fmt.Sprintf("Return secrets unredacted, requires the [%s] role.", p.actions["reveal"]),
+					"schema":      map[string]interface{}{"type": "boolean"},
+				})
+			}
+			var names []string
+			for format, contentType := range formats {
+				names = append(names, format)
+				if format != "json" {
+					content[strings.Split(contentType, ";")[0]] = map[string]interface{}{
+						"schema": map[string]interface{}{"type": "string"},
+					}
+				}
+			}
+			sort.Strings(names)
+			parameters = append(parameters, map[string]interface{}{
+				"name":        "format",
+				"in":          "query",
+				"description": "Output format, overrides the Accept header. Tabular formats get one row per record.",
+				"schema":      map[string]interface{}{"type": "string", "enum": names},
+			})
+			responses["406"] = map[string]interface{}{"$ref": "#/components/responses/Error"}
+			ok["headers"] = map[string]interface{}{
+				"Warning": map[string]interface{}{
+					"description": "Partial results, one header for every part that had to be skipped",
+					"schema":      map[string]interface{}{"type": "string"},
+				},
+			}
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		paths[rxPathParam.ReplaceAllString(pattern, "{$1}")] = map[string]interface{}{"get": operation}
+	}
+
+	errorSchema := schemaFor(reflect.TypeOf(errorBody{}), components)
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "dashboard API",
+			"description": "System information of " + currentHostname,
+			"version":     apiVersion,
+		},
+		"servers": []interface{}{map[string]interface{}{"url": "/"}},
+		"tags": []interface{}{
+			map[string]interface{}{"name": "v1", "description": "Original payloads with human readable units"},
+			map[string]interface{}{"name": "v2", "description": "Machine-friendly payloads with integer units and RFC 3339 timestamps"},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": components,
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"description": "Error",
+					"headers": map[string]interface{}{
+						requestIDHeader: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+					},
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": errorSchema},
+					},
+				},
+			},
+			"securitySchemes": map[string]interface{}{
+				"basicAuth":  map[string]interface{}{"type": "http", "scheme": "basic"},
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"session":    map[string]interface{}{"type": "apiKey", "in": "cookie", "name": sessionCookieName},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"basicAuth": []string{}},
+			map[string]interface{}{"bearerAuth": []string{}},
+			map[string]interface{}{"session": []string{}},
+		},
+	}
+}
+
+func OpenAPIHandler(routes martini.Routes, p *permissions, r render.Render) {
+	r.JSON(http.StatusOK, openAPI(routes, p))
+}
+
+// ExplorerHandler serves the interactive API explorer, which works entirely off /api/openapi.json.
+func ExplorerHandler(r render.Render) {
+	r.HTML(http.StatusOK, "explorer", View("API Explorer"), render.HTMLOptions{})
+}
@@ -0,0 +1,114 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type openAPIDocument struct {
+	OpenAPI string `json:"openapi"`
+	Paths   map[string]struct {
+		Get struct {
+			OperationId string `json:"operationId"`
+			Tags        []string
+			Parameters  []struct {
+				Name string
+				In   string
+			} `json:"parameters"`
+			Responses map[string]struct {
+				Ref     string `json:"$ref"`
+				Content map[string]struct {
+					Schema map[string]interface{} `json:"schema"`
+				} `json:"content"`
+			} `json:"responses"`
+		} `json:"get"`
+	} `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Type       string `json:"type"`
+			Required   []string
+			Properties map[string]map[string]interface{} `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func Test_todoapp_api_OpenAPI(t *testing.T) {
+	m := setupMartini()
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/api/openapi.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+
+	var doc openAPIDocument
+	if err := json.Unmarshal(response.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, doc.OpenAPI, "3.0.3")
+
+	// every registered JSON route is documented, the HTML debug route isn't
+	for _, endpoint := range v1Endpoints {
+		if _, ok := doc.Paths["/api/"+endpoint.Path]; !ok {
+			t.Errorf("Expected path [/api/%s] to be documented", endpoint.Path)
+		}
+	}
+	for _, endpoint := range v2Endpoints {
+		if _, ok := doc.Paths["/api/v2/"+endpoint.Path]; !ok {
+			t.Errorf("Expected path [/api/v2/%s] to be documented", endpoint.Path)
+		}
+	}
+	for path := range doc.Paths {
+		if strings.HasPrefix(path, "/api/debug") {
+			t.Errorf("Expected path [%s] not to be documented", path)
+		}
+	}
+
+	cpu := doc.Paths["/api/cpu"].Get
+	Expect(t, cpu.OperationId, "get_cpu")
+	Expect(t, cpu.Tags, []string{"v1"})
+	Expect(t, cpu.Responses["200"].Content["application/json"].Schema["$ref"], "#/components/schemas/CPU")
+	Expect(t, cpu.Responses["403"].Ref, "#/components/responses/Error")
+
+	schema := doc.Paths["/api/v2/schemas/{name}"].Get
+	Expect(t, schema.Parameters[0].Name, "name")
+	Expect(t, schema.Parameters[0].In, "path")
+	Expect(t, doc.Paths["/api/env"].Get.Parameters[0].Name, "reveal")
+
+	Expect(t, doc.Components.Schemas["Process"].Properties["Pid"]["type"], "number")
+	Expect(t, doc.Components.Schemas["ProcessV2"].Properties["Pid"]["type"], "integer")
+	Expect(t, doc.Components.Schemas["DiskUsage"].Properties["Size"]["type"], "string")
+	Expect(t, doc.Components.Schemas["APIError"].Required, []string{"Code", "Message", "RequestID"})
+	Expect(t, doc.Components.Schemas["Env"].Type, "object")
+	Expect(t, doc.Components.Schemas["LoggedOn"].Type, "object")
+	Expect(t, doc.Components.Schemas["User"].Type, "object")
+	Expect(t, doc.Components.Schemas["If"].Type, "object")
+}
+
+func Test_todoapp_Explorer(t *testing.T) {
+	m := setupMartini()
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/explorer", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+
+	body := response.Body.String()
+	Contain(t, body, `<title>API Explorer - Dashboard</title>`)
+	Contain(t, body, `ng-app="explorer"`)
+	Contain(t, body, `<script src="/js/explorer.js"`)
+}
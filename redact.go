@@ -0,0 +1,303 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	redactedValue = "[REDACTED]"
+
+	defaultRedactNames   = "*PASSWORD*,*PASSWD*,*TOKEN*,*SECRET*,*CREDENTIAL*,*PRIVATE_KEY*,*API_KEY*"
+	defaultRedactJSON    = "VCAP_SERVICES:*.*.credentials"
+	defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+	rxArg          = regexp.MustCompile(`\S+`)
+	annotationName = strings.NewReplacer("/", "_", ".", "_", "-", "_")
+)
+
+type redactor struct {
+	names     []string
+	jsonPaths map[string][][]string
+	headers   []string
+}
+
+// setupRedactor configures how secrets in environment data are masked:
+//
+//	DASHBOARD_REDACT_NAMES  comma separated, case-insensitive patterns of variable names to mask completely
+//	DASHBOARD_REDACT_JSON   comma separated "VARIABLE:path" entries, masking all values below a path inside
+//	                        a JSON variable, "*" matches any key or array index (default "VCAP_SERVICES:*.*.credentials")
+func setupRedactor() *redactor {
+	rd := &redactor{
+		jsonPaths: make(map[string][][]string),
+		headers:   defaultRedactHeaders,
+	}
+
+	for _, pattern := range strings.Split(envOrDefault("DASHBOARD_REDACT_NAMES", defaultRedactNames), ",") {
+		pattern = strings.ToUpper(trim(pattern))
+		if len(pattern) == 0 {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.Fatalf("Invalid pattern [%s] in DASHBOARD_REDACT_NAMES: %v", pattern, err)
+		}
+		rd.names = append(rd.names, pattern)
+	}
+
+	for _, entry := range strings.Split(envOrDefault("DASHBOARD_REDACT_JSON", defaultRedactJSON), ",") {
+		if len(trim(entry)) == 0 {
+			continue
+		}
+		values := strings.SplitN(trim(entry), ":", 2)
+		if len(values) != 2 {
+			log.Fatalf("Invalid entry [%s] in DASHBOARD_REDACT_JSON, expected VARIABLE:path", entry)
+		}
+		rd.jsonPaths[values[0]] = append(rd.jsonPaths[values[0]], strings.Split(values[1], "."))
+	}
+
+	return rd
+}
+
+// Redact masks secrets in the result of a collector.
+func (rd *redactor) Redact(data interface{}) interface{} {
+	switch value := data.(type) {
+	case []*Env:
+		return rd.Env(value)
+	case http.Header:
+		return rd.Header(value)
+	case *Top:
+		redacted := *value
+		redacted.Processes = make([]*Process, 0, len(value.Processes))
+		for _, process := range value.Processes {
+			masked := *process
+			masked.Command = rd.CommandLine(process.Command)
+			redacted.Processes = append(redacted.Processes, &masked)
+		}
+		return &redacted
+	case *TopV2:
+		redacted := *value
+		redacted.Processes = make([]*ProcessV2, 0, len(value.Processes))
+		for _, process := range value.Processes {
+			masked := *process
+			masked.Command = rd.CommandLine(process.Command)
+			redacted.Processes = append(redacted.Processes, &masked)
+		}
+		return &redacted
+	case *Kubernetes:
+		redacted := *value
+		redacted.Annotations = make(map[string]string, len(value.Annotations))
+		for key, annotation := range value.Annotations {
+			// keys like "vault.hashicorp.com/agent-inject-token" are matched like variable names
+			redacted.Annotations[key] = rd.Value(annotationName.Replace(key), annotation)
+		}
+		return &redacted
+	case *DockerContainers:
+		redacted := *value
+		redacted.Containers = make([]*DockerContainer, 0, len(value.Containers))
+		for _, container := range value.Containers {
+			masked := *container
+			masked.Command = rd.CommandLine(container.Command)
+			redacted.Containers = append(redacted.Containers, &masked)
+		}
+		return &redacted
+	case *DockerContainerDetail:
+		redacted := *value
+		if value.Container != nil {
+			container := *value.Container
+			container.Command = rd.CommandLine(container.Command)
+			redacted.Container = &container
+		}
+		redacted.Entrypoint = rd.Args(value.Entrypoint)
+		redacted.Cmd = rd.Args(value.Cmd)
+		redacted.Env = rd.Env(value.Env)
+		return &redacted
+	}
+	return data
+}
+
+// sensitiveArg returns the name of an argument like "--password=value", "DB_TOKEN=value" or "--api-key" if it
+// matches DASHBOARD_REDACT_NAMES, and whether the value follows in the same argument.
+func (rd *redactor) sensitiveArg(arg string) (name string, inline bool) {
+	name = strings.TrimLeft(arg, "-")
+	if i := strings.Index(name, "="); i >= 0 {
+		name, inline = name[:i], true
+	} else if len(name) == len(arg) { // neither a flag nor an assignment
+		return "", false
+	}
+	if !rd.sensitiveName(strings.Replace(name, "-", "_", -1)) {
+		return "", false
+	}
+	return name, inline
+}
+
+// Args returns a copy of command arguments with the values of sensitive flags and assignments masked,
+// e.g. "--password=hunter2" or "--password", "hunter2".
+func (rd *redactor) Args(args []string) []string {
+	if args == nil {
+		return nil
+	}
+	result := make([]string, len(args))
+	maskNext := false
+	for i, arg := range args {
+		name, inline := rd.sensitiveArg(arg)
+		switch {
+		case maskNext:
+			result[i] = redactedValue
+		case len(name) > 0 && inline:
+			result[i] = arg[:strings.Index(arg, "=")+1] + redactedValue
+		default:
+			result[i] = arg
+		}
+		maskNext = len(name) > 0 && !inline
+	}
+	return result
+}
+
+// CommandLine masks the arguments of command lines like Args, keeping the whitespace between them intact,
+// so columns of tables like the output of ps stay aligned.
+func (rd *redactor) CommandLine(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		tokens := rxArg.FindAllStringIndex(line, -1)
+		args := make([]string, len(tokens))
+		for j, token := range tokens {
+			args[j] = line[token[0]:token[1]]
+		}
+		masked := rd.Args(args)
+
+		var result bytes.Buffer
+		end := 0
+		for j, token := range tokens {
+			result.WriteString(line[end:token[0]])
+			result.WriteString(masked[j])
+			end = token[1]
+		}
+		result.WriteString(line[end:])
+		lines[i] = result.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (rd *redactor) sensitiveName(name string) bool {
+	name = strings.ToUpper(name)
+	for _, pattern := range rd.names {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Env returns a redacted copy of environment variables.
+func (rd *redactor) Env(env []*Env) []*Env {
+	result := make([]*Env, 0, len(env))
+	for _, e := range env {
+		result = append(result, &Env{e.Key, rd.Value(e.Key, e.Value)})
+	}
+	return result
+}
+
+// Value masks the value of a single environment variable.
+func (rd *redactor) Value(key, value string) string {
+	if len(value) == 0 {
+		return value
+	}
+	if rd.sensitiveName(key) {
+		return redactedValue
+	}
+
+	paths, ok := rd.jsonPaths[key]
+	if !ok {
+		return value
+	}
+	var document interface{}
+	if err := json.Unmarshal([]byte(value), &document); err != nil {
+		// better hide everything than leak credentials of a variable we can't parse
+		return redactedValue
+	}
+	for _, p := range paths {
+		document = maskPath(document, p)
+	}
+	masked, err := json.Marshal(document)
+	if err != nil {
+		return redactedValue
+	}
+	return string(masked)
+}
+
+// maskPath replaces every scalar below the nodes matched by path, keeping the structure intact.
+func maskPath(node interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return maskAll(node)
+	}
+
+	switch value := node.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if path[0] == "*" || path[0] == key {
+				value[key] = maskPath(child, path[1:])
+			}
+		}
+	case []interface{}:
+		for i, child := range value {
+			if path[0] == "*" || path[0] == strconv.Itoa(i) {
+				value[i] = maskPath(child, path[1:])
+			}
+		}
+	}
+	return node
+}
+
+func maskAll(node interface{}) interface{} {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			value[key] = maskAll(child)
+		}
+		return value
+	case []interface{}:
+		for i, child := range value {
+			value[i] = maskAll(child)
+		}
+		return value
+	case nil:
+		return nil
+	}
+	return redactedValue
+}
+
+// Header returns a copy of the request headers with credentials masked.
+func (rd *redactor) Header(header http.Header) http.Header {
+	result := make(http.Header, len(header))
+	for key, values := range header {
+		result[key] = values
+		for _, name := range rd.headers {
+			if http.CanonicalHeaderKey(name) == http.CanonicalHeaderKey(key) {
+				result[key] = []string{redactedValue}
+			}
+		}
+	}
+	return result
+}
+
+// reveal reports whether the request asks for unredacted data, which requires the "reveal" action.
+func reveal(req *http.Request) bool {
+	value, _ := strconv.ParseBool(req.URL.Query().Get("reveal"))
+	return value
+}
+
+// mayReveal reports whether an identity may see unredacted data. With authentication disabled every caller
+// is anonymous with the admin role, redaction must not be opt-out for anyone who can reach the port.
+func mayReveal(p *permissions, id *Identity) bool {
+	return id != anonymous && p.Action(id.Role, "reveal")
+}
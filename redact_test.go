@@ -0,0 +1,162 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+var testVcapServices = `{"p-mysql":[{"name":"db","credentials":{"hostname":"10.0.0.1","port":3306,"password":"hunter2"}}]}`
+
+func Test_todoapp_redact_Value(t *testing.T) {
+	rd := setupRedactor()
+
+	Expect(t, rd.Value("DB_PASSWORD", "hunter2"), redactedValue)
+	Expect(t, rd.Value("github_token", "abc"), redactedValue)
+	Expect(t, rd.Value("AWS_SECRET_ACCESS_KEY", "abc"), redactedValue)
+	Expect(t, rd.Value("EMPTY_SECRET", ""), "")
+	Expect(t, rd.Value("HOME", "/root"), "/root")
+	Expect(t, rd.Value("VCAP_SERVICES", "not json"), redactedValue)
+
+	masked := rd.Value("VCAP_SERVICES", testVcapServices)
+	NotContain(t, masked, "hunter2")
+	NotContain(t, masked, "10.0.0.1")
+	Contain(t, masked, `"name":"db"`)
+	Contain(t, masked, `"port":"[REDACTED]"`)
+
+	header := http.Header{"Authorization": {"Bearer abc"}, "Accept": {"*/*"}}
+	redacted := rd.Header(header)
+	Expect(t, redacted.Get("Authorization"), redactedValue)
+	Expect(t, redacted.Get("Accept"), "*/*")
+	Expect(t, header.Get("Authorization"), "Bearer abc")
+}
+
+func Test_todoapp_redact_Args(t *testing.T) {
+	rd := setupRedactor()
+	Expect(t, rd.Args([]string{"mysqld", "--password=hunter2", "--user", "mysql"}), []string{"mysqld", "--password=" + redactedValue, "--user", "mysql"})
+	Expect(t, rd.Args([]string{"app", "--api-key", "hunter2", "-v"}), []string{"app", "--api-key", redactedValue, "-v"})
+	Expect(t, rd.Args([]string{"env", "DB_TOKEN=hunter2", "run"}), []string{"env", "DB_TOKEN=" + redactedValue, "run"})
+	Expect(t, rd.Args(nil), []string(nil))
+
+	// whitespace is kept, the value of a flag at the end of a line is not looked for on the next one
+	Expect(t, rd.CommandLine("root   1  app  --secret  hunter2 -v\nroot   2  app --token\nroot   3  other"),
+		"root   1  app  --secret  "+redactedValue+" -v\nroot   2  app --token\nroot   3  other")
+}
+
+func Test_todoapp_redact_Processes(t *testing.T) {
+	rd := setupRedactor()
+	top := rd.Redact(&Top{Processes: []*Process{{Pid: 1, Command: "mysqld --password=hunter2"}}}).(*Top)
+	Expect(t, top.Processes[0].Command, "mysqld --password="+redactedValue)
+	topV2 := rd.Redact(&TopV2{Processes: []*ProcessV2{{Pid: 1, Command: "app --api-key hunter2"}}}).(*TopV2)
+	Expect(t, topV2.Processes[0].Command, "app --api-key "+redactedValue)
+}
+
+func Test_todoapp_redact_Config(t *testing.T) {
+	os.Setenv("DASHBOARD_REDACT_NAMES", "MY_*")
+	os.Setenv("DASHBOARD_REDACT_JSON", "CONFIG:db.0.password")
+	defer os.Unsetenv("DASHBOARD_REDACT_NAMES")
+	defer os.Unsetenv("DASHBOARD_REDACT_JSON")
+	rd := setupRedactor()
+
+	Expect(t, rd.Value("my_var", "value"), redactedValue)
+	Expect(t, rd.Value("DB_PASSWORD", "hunter2"), "hunter2")
+	Expect(t, rd.Value("CONFIG", `{"db":[{"password":"a","user":"b"},{"password":"c"}]}`),
+		`{"db":[{"password":"[REDACTED]","user":"b"},{"password":"c"}]}`)
+}
+
+func Test_todoapp_redact_API(t *testing.T) {
+	os.Setenv("DASHBOARD_TEST_PASSWORD", "hunter2")
+	os.Setenv("DASHBOARD_TEST_URL", "postgres://db/app?sslmode=require&x=y")
+	defer os.Unsetenv("DASHBOARD_TEST_PASSWORD")
+	defer os.Unsetenv("DASHBOARD_TEST_URL")
+	m, cleanup := setupAuthMartini(t)
+	defer cleanup()
+
+	get := func(path string) (*httptest.ResponseRecorder, map[string]string) {
+		response := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://localhost:4005"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("alice", "secret")
+		m.ServeHTTP(response, req)
+
+		var data []*Env
+		if err := json.Unmarshal(response.Body.Bytes(), &data); err != nil {
+			t.Fatal(err)
+		}
+		env := make(map[string]string)
+		for _, e := range data {
+			env[e.Key] = e.Value
+		}
+		return response, env
+	}
+
+	response, env := get("/api/env")
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, env["DASHBOARD_TEST_PASSWORD"], redactedValue)
+	Expect(t, env["DASHBOARD_TEST_URL"], "postgres://db/app?sslmode=require&x=y")
+
+	response, env = get("/api/env?reveal=true")
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, env["DASHBOARD_TEST_PASSWORD"], "hunter2")
+}
+
+func Test_todoapp_redact_RevealWithoutAuth(t *testing.T) {
+	os.Setenv("DASHBOARD_TEST_PASSWORD", "hunter2")
+	defer os.Unsetenv("DASHBOARD_TEST_PASSWORD")
+	m := setupMartini()
+
+	// everybody is an anonymous admin with authentication disabled, redaction must still apply to them
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/api/env?reveal=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusForbidden)
+	NotContain(t, response.Body.String(), "hunter2")
+
+	response = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "http://localhost:4005/api/env", nil)
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+	NotContain(t, response.Body.String(), "hunter2")
+
+	// the frontend must not offer a reveal link that can only ever be refused
+	response = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "http://localhost:4005/api/permissions", nil)
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+	var permissions struct {
+		Actions map[string]bool
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &permissions); err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, permissions.Actions["reveal"], false)
+}
+
+func Test_todoapp_redact_RevealForbidden(t *testing.T) {
+	m, cleanup := setupAuthMartini(t)
+	defer cleanup()
+	os.Setenv("DASHBOARD_PERMISSIONS", "collector:env=viewer")
+	defer os.Unsetenv("DASHBOARD_PERMISSIONS")
+	m = setupMartini()
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/api/env?reveal=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer s3cr3t-t0k3n")
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusForbidden)
+	Contain(t, response.Body.String(), `not allowed to access [reveal]`)
+}
@@ -0,0 +1,138 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/martini-contrib/render"
+)
+
+// reportTemplate is embedded, "dashboard report" can be run from anywhere, not only next to the templates directory.
+//
+//go:embed templates/report.html
+var reportTemplate string
+
+// report is a point-in-time view of all collectors, rendered into a single self-contained HTML file.
+type report struct {
+	Title       string
+	Hostname    string
+	Version     string
+	Generated   time.Time
+	GeneratedBy string
+	Sections    []*reportSection
+	Omitted     []string
+}
+
+type reportSection struct {
+	Name     string
+	Summary  string
+	Error    string
+	Warnings Warnings
+	Columns  []string
+	Rows     [][]string
+}
+
+// newReport collects every collector allowed by the allowed func, secrets are always redacted.
+// Request headers are left out, they describe the download and not the system.
+func newReport(rd *redactor, generatedBy string, allowed func(method string) bool) *report {
+	rep := &report{
+		Title:       "System report of " + currentHostname,
+		Hostname:    currentHostname,
+		Version:     version,
+		Generated:   time.Now().UTC(),
+		GeneratedBy: generatedBy,
+	}
+	for _, endpoint := range v1Endpoints {
+		if endpoint.Method == "headers" {
+			continue
+		}
+		if !allowed(endpoint.Method) {
+			rep.Omitted = append(rep.Omitted, endpoint.Path)
+			continue
+		}
+
+		section := &reportSection{Name: endpoint.Path, Summary: endpoint.Summary}
+		data, err := data(endpoint.Method)
+		warnings, err := collectorError(err)
+		section.Warnings = warnings
+		if err != nil {
+			section.Error = err.Error()
+		} else {
+			section.Columns, section.Rows = table(records(rd.Redact(data)))
+		}
+		rep.Sections = append(rep.Sections, section)
+	}
+	return rep
+}
+
+// write renders the report with the same template engine and delimiters as the web pages.
+func (rep *report) write(w io.Writer) error {
+	t, err := template.New("report.html").Delims("{[{", "}]}").Parse(reportTemplate)
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, rep)
+}
+
+func (rep *report) filename() string {
+	return fmt.Sprintf("dashboard-report-%s-%s.html", rep.Hostname, rep.Generated.Format("20060102T150405Z"))
+}
+
+// ReportHandler downloads the report, containing the collectors the caller may read.
+func ReportHandler(res http.ResponseWriter, r render.Render, p *permissions, rd *redactor, id *Identity) {
+	rep := newReport(rd, id.Name, func(method string) bool {
+		return p.Collector(id.Role, method)
+	})
+
+	var buf bytes.Buffer
+	if err := rep.write(&buf); err != nil {
+		apiError(r, http.StatusInternalServerError, ErrCollectorFailed, "", err)
+		return
+	}
+	res.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	res.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, rep.filename()))
+	res.WriteHeader(http.StatusOK)
+	res.Write(buf.Bytes())
+}
+
+// reportCommand implements "dashboard report [-o file]", which writes the report of the local host without a running server.
+func reportCommand(args []string) int {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	output := flags.String("o", "", "write the report to this file instead of stdout, \"-\" for a generated file name")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	rep := newReport(setupRedactor(), "cli", func(string) bool { return true })
+	w := stdout
+	if len(*output) > 0 {
+		name := *output
+		if name == "-" {
+			name = rep.filename()
+		}
+		file, err := os.Create(name)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := rep.write(w); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
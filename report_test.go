@@ -0,0 +1,111 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_todoapp_Report(t *testing.T) {
+	os.Setenv("DASHBOARD_TEST_SECRET", "hunter2")
+	defer os.Unsetenv("DASHBOARD_TEST_SECRET")
+
+	m := setupMartini()
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/report", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, response.Header().Get("Content-Type"), "text/html; charset=UTF-8")
+	Contain(t, response.Header().Get("Content-Disposition"), `attachment; filename="dashboard-report-`+currentHostname)
+
+	body := response.Body.String()
+	Contain(t, body, "<title>System report of "+currentHostname+"</title>")
+	Contain(t, body, "<style>")
+	Contain(t, body, `<h2 id="cpu">cpu</h2>`)
+	Contain(t, body, "<th>Processors</th>")
+	Contain(t, body, "<td>DASHBOARD_TEST_SECRET</td>")
+	NotContain(t, body, "hunter2")
+	// self-contained, nothing is loaded from the dashboard
+	NotContain(t, body, "angular")
+	NotContain(t, body, `src="/`)
+	NotContain(t, body, `href="/`)
+	NotContain(t, body, `<h2 id="headers">`)
+}
+
+func Test_todoapp_Report_Permissions(t *testing.T) {
+	m, cleanup := setupAuthMartini(t)
+	defer cleanup()
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005/report", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer s3cr3t-t0k3n")
+
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusOK)
+	body := response.Body.String()
+	Contain(t, body, `<h2 id="cpu">cpu</h2>`)
+	NotContain(t, body, `<h2 id="env">`)
+	Contain(t, body, "Not included for lack of permissions: containers, images, pods, sockets, logged_on, users, env.")
+}
+
+func Test_todoapp_reportCommand(t *testing.T) {
+	file, err := ioutil.TempFile("", "dashboard-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	code, _, _ := runCLI("report", "-o", file.Name())
+	Expect(t, code, 0)
+	content, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	Contain(t, string(content), "by cli with dashboard "+version)
+	Contain(t, string(content), `<h2 id="env">env</h2>`)
+
+	code, _, errOut := runCLI("report", "-unknown")
+	Expect(t, code, 2)
+	Contain(t, errOut, "flag provided but not defined: -unknown")
+}
+
+func Test_todoapp_reportCommand_OtherDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dashboard-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// without a templates directory next to it
+	code, _, _ := runCLI("report", "-o", "report.html")
+	Expect(t, code, 0)
+	content, err := ioutil.ReadFile(filepath.Join(dir, "report.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Contain(t, string(content), "<title>System report of "+currentHostname+"</title>")
+}
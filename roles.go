@@ -0,0 +1,236 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/martini-contrib/render"
+)
+
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleViewer
+	RoleOperator
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleNone:     "none",
+	RoleViewer:   "viewer",
+	RoleOperator: "operator",
+	RoleAdmin:    "admin",
+}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// enumValues lists the names of all roles for the JSON Schema, lowest first.
+func (r Role) enumValues() []string {
+	return []string{RoleNone.String(), RoleViewer.String(), RoleOperator.String(), RoleAdmin.String()}
+}
+
+func parseRole(name string) (Role, error) {
+	for role, roleName := range roleNames {
+		if roleName == strings.ToLower(trim(name)) && role != RoleNone {
+			return role, nil
+		}
+	}
+	return RoleNone, fmt.Errorf("unknown role [%s]", name)
+}
+
+// sensitiveCollectors expose secrets or account names and are restricted to admins by default.
+var sensitiveCollectors = map[string]bool{
+	"env":     true,
+	"passwd":  true,
+	"headers": true,
+}
+
+type permissions struct {
+	collectors map[string]Role
+	actions    map[string]Role
+}
+
+func defaultPermissions() *permissions {
+	p := &permissions{
+		collectors: map[string]Role{
+			"hostname":        RoleViewer,
+			"ip":              RoleViewer,
+			"addresses":       RoleViewer,
+			"cpu":             RoleViewer,
+			"cpustat":         RoleViewer,
+			"mem":             RoleViewer,
+			"cgroup":          RoleViewer,
+			"disk":            RoleViewer,
+			"diskio":          RoleViewer,
+			"top":             RoleViewer,
+			"cgroups":         RoleViewer,
+			"docker":          RoleOperator,
+			"docker_images":   RoleOperator,
+			"kubernetes":      RoleViewer,
+			"kubernetes_pods": RoleOperator,
+			"network":         RoleViewer,
+			"netstat":         RoleViewer,
+			"routing":         RoleViewer,
+			"sockets":         RoleOperator,
+			"logged_on":       RoleOperator,
+		},
+		actions: map[string]Role{
+			"debug":    RoleOperator,
+			"bundle":   RoleOperator,
+			"snapshot": RoleOperator,
+			"reveal":   RoleAdmin,
+		},
+	}
+	for collector := range sensitiveCollectors {
+		p.collectors[collector] = RoleAdmin
+	}
+	return p
+}
+
+// Collector reports whether role may read the given collector, unknown collectors require admin.
+func (p *permissions) Collector(role Role, collector string) bool {
+	required, ok := p.collectors[collector]
+	if !ok {
+		required = RoleAdmin
+	}
+	return role >= required
+}
+
+// Action reports whether role may perform the given action, unknown actions require admin.
+func (p *permissions) Action(role Role, action string) bool {
+	required, ok := p.actions[action]
+	if !ok {
+		required = RoleAdmin
+	}
+	return role >= required
+}
+
+// set overrides a permission given as "collector:<name>=<role>" or "action:<name>=<role>".
+func (p *permissions) set(permission string) error {
+	values := strings.SplitN(permission, "=", 2)
+	if len(values) != 2 {
+		return fmt.Errorf("malformed permission [%s], expected kind:name=role", permission)
+	}
+	role, err := parseRole(values[1])
+	if err != nil {
+		return err
+	}
+
+	target := strings.SplitN(trim(values[0]), ":", 2)
+	if len(target) != 2 {
+		return fmt.Errorf("malformed permission [%s], expected kind:name=role", permission)
+	}
+	switch target[0] {
+	case "collector":
+		p.collectors[target[1]] = role
+	case "action":
+		p.actions[target[1]] = role
+	default:
+		return fmt.Errorf("unknown permission kind [%s]", target[0])
+	}
+	return nil
+}
+
+type roles struct {
+	users       map[string]Role
+	defaultRole Role
+}
+
+func (r *roles) For(name string) Role {
+	if role, ok := r.users[name]; ok {
+		return role
+	}
+	return r.defaultRole
+}
+
+// setupRoles configures role based access control from the environment:
+//
+//	DASHBOARD_ROLES         file with "name:role" lines for users and API token names
+//	DASHBOARD_DEFAULT_ROLE  role of authenticated identities not listed in DASHBOARD_ROLES (default viewer)
+//	DASHBOARD_PERMISSIONS   comma separated overrides, e.g. "collector:env=operator,action:debug=admin"
+func setupRoles() (*roles, *permissions) {
+	r := &roles{users: make(map[string]Role), defaultRole: RoleViewer}
+	p := defaultPermissions()
+
+	if value := os.Getenv("DASHBOARD_DEFAULT_ROLE"); len(value) > 0 {
+		role, err := parseRole(value)
+		if err != nil {
+			log.Fatalf("Invalid DASHBOARD_DEFAULT_ROLE: %v", err)
+		}
+		r.defaultRole = role
+	}
+
+	if file := os.Getenv("DASHBOARD_ROLES"); len(file) > 0 {
+		err := readLines(file, func(line string) error {
+			values := strings.SplitN(line, ":", 2)
+			if len(values) != 2 {
+				return fmt.Errorf("malformed line, expected name:role")
+			}
+			role, err := parseRole(values[1])
+			if err != nil {
+				return err
+			}
+			r.users[trim(values[0])] = role
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Could not load roles file [%s]: %v", file, err)
+		}
+	}
+
+	if value := os.Getenv("DASHBOARD_PERMISSIONS"); len(value) > 0 {
+		for _, permission := range strings.Split(value, ",") {
+			if err := p.set(permission); err != nil {
+				log.Fatalf("Invalid DASHBOARD_PERMISSIONS: %v", err)
+			}
+		}
+	}
+
+	return r, p
+}
+
+func forbidden(r render.Render, id *Identity, collector, what string) {
+	apiError(r, http.StatusForbidden, ErrForbidden, collector,
+		fmt.Errorf("role [%s] of [%s] is not allowed to access [%s]", id.Role, id.Name, what))
+}
+
+type permissionsView struct {
+	Name       string
+	Method     string
+	Role       Role
+	Collectors map[string]bool
+	Actions    map[string]bool
+}
+
+// PermissionsHandler tells the frontend which panels and actions the current identity may use.
+func PermissionsHandler(p *permissions, id *Identity, r render.Render) {
+	view := &permissionsView{
+		Name:       id.Name,
+		Method:     id.Method,
+		Role:       id.Role,
+		Collectors: make(map[string]bool),
+		Actions:    make(map[string]bool),
+	}
+	for collector := range p.collectors {
+		view.Collectors[collector] = p.Collector(id.Role, collector)
+	}
+	for action := range p.actions {
+		view.Actions[action] = p.Action(id.Role, action)
+	}
+	view.Actions["reveal"] = mayReveal(p, id)
+	r.JSON(http.StatusOK, view)
+}
@@ -0,0 +1,107 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_todoapp_roles_Viewer(t *testing.T) {
+	m, cleanup := setupAuthMartini(t)
+	defer cleanup()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://localhost:4005"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer s3cr3t-t0k3n")
+		m.ServeHTTP(response, req)
+		return response
+	}
+
+	response := get("/api/hostname")
+	Expect(t, response.Code, http.StatusOK)
+
+	for _, path := range []string{"/api/env", "/api/users", "/api/headers", "/api/debug/hostname"} {
+		response = get(path)
+		Expect(t, response.Code, http.StatusForbidden)
+		Expect(t, response.Header().Get("Content-Type"), "application/json; charset=UTF-8")
+		Contain(t, response.Body.String(), `"Code": "forbidden"`)
+		Contain(t, response.Body.String(), `"Message": "role [viewer] of [monitoring] is not allowed to access`)
+	}
+
+	response = get("/api/permissions")
+	Expect(t, response.Code, http.StatusOK)
+	var data struct {
+		Name       string
+		Role       string
+		Collectors map[string]bool
+		Actions    map[string]bool
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, data.Name, "monitoring")
+	Expect(t, data.Role, "viewer")
+	Expect(t, data.Collectors["cpu"], true)
+	Expect(t, data.Collectors["logged_on"], false)
+	Expect(t, data.Collectors["env"], false)
+	Expect(t, data.Actions["debug"], false)
+}
+
+func Test_todoapp_roles_Admin(t *testing.T) {
+	m, cleanup := setupAuthMartini(t)
+	defer cleanup()
+
+	for _, path := range []string{"/api/env", "/api/headers", "/api/debug/hostname"} {
+		response := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://localhost:4005"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("alice", "secret")
+		m.ServeHTTP(response, req)
+		Expect(t, response.Code, http.StatusOK)
+	}
+
+	response := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost:4005/api/permissions", nil)
+	req.SetBasicAuth("alice", "secret")
+	m.ServeHTTP(response, req)
+	var data struct {
+		Actions map[string]bool
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, data.Actions["reveal"], true)
+}
+
+func Test_todoapp_roles_Permissions(t *testing.T) {
+	os.Setenv("DASHBOARD_PERMISSIONS", "collector:env=viewer,action:debug=admin")
+	defer os.Unsetenv("DASHBOARD_PERMISSIONS")
+
+	_, p := setupRoles()
+	Expect(t, p.Collector(RoleViewer, "env"), true)
+	Expect(t, p.Collector(RoleViewer, "passwd"), false)
+	Expect(t, p.Collector(RoleOperator, "logged_on"), true)
+	Expect(t, p.Collector(RoleAdmin, "unknown"), true)
+	Expect(t, p.Collector(RoleOperator, "unknown"), false)
+	Expect(t, p.Action(RoleOperator, "debug"), false)
+	Expect(t, p.Action(RoleAdmin, "debug"), true)
+}
+
+func Test_todoapp_roles_Schema(t *testing.T) {
+	// roles are encoded by name, not by their number
+	role := schemaFor(reflect.TypeOf(permissionsView{}), nil)["properties"].(map[string]interface{})["Role"]
+	Expect(t, role, map[string]interface{}{"type": "string", "enum": []string{"none", "viewer", "operator", "admin"}})
+}
@@ -0,0 +1,332 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+var (
+	resolvConf   = "/etc/resolv.conf"
+	hostsFile    = "/etc/hosts"
+	nsswitchConf = "/etc/nsswitch.conf"
+)
+
+// route flags of /proc/net/route and /proc/net/ipv6_route, see linux/route.h and linux/ipv6_route.h
+const (
+	routeUp      = 0x0001
+	routeGateway = 0x0002
+	routeHost    = 0x0004
+	routeReject  = 0x0200
+	routeLocal   = 0x80000000
+)
+
+type Route struct {
+	Family      string // inet or inet6, like the address types of the network collector
+	Destination string
+	Gateway     string // empty for directly connected networks
+	Interface   string
+	Metric      uint64
+	Flags       string // U up, G gateway, H host
+}
+
+type Neighbour struct {
+	Family    string
+	Address   string
+	MAC       string
+	Interface string
+	State     string
+}
+
+type Resolver struct {
+	Nameservers []string
+	Search      []string
+	Options     []string
+}
+
+type HostsEntry struct {
+	Address string
+	Names   []string
+}
+
+type Routing struct {
+	Routes          []*Route
+	DefaultGateways []*Route
+	Neighbours      []*Neighbour
+	Resolver        *Resolver
+	Hosts           []*HostsEntry
+	NSSwitch        map[string][]string // sources per database, in the order they are asked
+}
+
+func routeFlags(flags uint64) string {
+	var result string
+	for _, flag := range []struct {
+		bit  uint64
+		name string
+	}{{routeUp, "U"}, {routeGateway, "G"}, {routeHost, "H"}} {
+		if flags&flag.bit != 0 {
+			result += flag.name
+		}
+	}
+	return result
+}
+
+// parseRoutes parses /proc/net/route, where addresses are 32 bit hex numbers in host byte order (little endian).
+func parseRoutes(content string) ([]*Route, error) {
+	var routes []*Route
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] == "Iface" {
+			continue
+		}
+		if len(fields) < 8 {
+			return nil, fmt.Errorf("invalid /proc/net/route line [%s]", scanner.Text())
+		}
+		var values [3]net.IP // destination, gateway, mask
+		for i, field := range []string{fields[1], fields[2], fields[7]} {
+			raw, err := strconv.ParseUint(field, 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid /proc/net/route line [%s]", scanner.Text())
+			}
+			values[i] = make(net.IP, net.IPv4len)
+			binary.LittleEndian.PutUint32(values[i], uint32(raw))
+		}
+		flags, _ := strconv.ParseUint(fields[3], 16, 32)
+		if flags&routeUp == 0 || flags&routeReject != 0 {
+			continue
+		}
+		length, _ := net.IPMask(values[2]).Size()
+		route := &Route{
+			Family:      "inet",
+			Destination: fmt.Sprintf("%s/%d", values[0], length),
+			Interface:   fields[0],
+			Flags:       routeFlags(flags),
+		}
+		route.Metric, _ = strconv.ParseUint(fields[6], 10, 64)
+		if flags&routeGateway != 0 {
+			route.Gateway = values[1].String()
+		}
+		routes = append(routes, route)
+	}
+	return routes, scanner.Err()
+}
+
+// parseIPv6Routes parses /proc/net/ipv6_route, where addresses are in network byte order.
+// Routes to the addresses of the host itself are left out, the network collector lists those.
+func parseIPv6Routes(content string) ([]*Route, error) {
+	var routes []*Route
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 10 {
+			return nil, fmt.Errorf("invalid /proc/net/ipv6_route line [%s]", scanner.Text())
+		}
+		destination, err1 := hex.DecodeString(fields[0])
+		length, err2 := strconv.ParseUint(fields[1], 16, 8)
+		gateway, err3 := hex.DecodeString(fields[4])
+		if err1 != nil || err2 != nil || err3 != nil || len(destination) != net.IPv6len || len(gateway) != net.IPv6len {
+			return nil, fmt.Errorf("invalid /proc/net/ipv6_route line [%s]", scanner.Text())
+		}
+		flags, _ := strconv.ParseUint(fields[8], 16, 32)
+		if flags&routeUp == 0 || flags&(routeReject|routeLocal) != 0 {
+			continue
+		}
+		route := &Route{
+			Family:      "inet6",
+			Destination: fmt.Sprintf("%s/%d", net.IP(destination), length),
+			Interface:   fields[9],
+			Flags:       routeFlags(flags),
+		}
+		route.Metric, _ = strconv.ParseUint(fields[5], 16, 32)
+		if flags&routeGateway != 0 {
+			route.Gateway = net.IP(gateway).String()
+		}
+		routes = append(routes, route)
+	}
+	return routes, scanner.Err()
+}
+
+func defaultGateways(routes []*Route) []*Route {
+	gateways := []*Route{}
+	for _, route := range routes {
+		if (route.Destination == "0.0.0.0/0" || route.Destination == "::/0") && len(route.Gateway) > 0 {
+			gateways = append(gateways, route)
+		}
+	}
+	return gateways
+}
+
+// parseARP parses /proc/net/arp, incomplete entries have no hardware address yet.
+func parseARP(content string) []*Neighbour {
+	var neighbours []*Neighbour
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[0] == "IP" {
+			continue
+		}
+		state := "REACHABLE"
+		if flags, _ := strconv.ParseUint(strings.TrimPrefix(fields[2], "0x"), 16, 32); flags&0x2 == 0 { // ATF_COM
+			state = "INCOMPLETE"
+		} else if flags&0x4 != 0 { // ATF_PERM
+			state = "PERMANENT"
+		}
+		neighbours = append(neighbours, &Neighbour{Family: "inet", Address: fields[0], MAC: fields[3], Interface: fields[5], State: state})
+	}
+	return neighbours
+}
+
+// parseNeighbours parses the output of ip neigh, e.g. "fe80::1 dev eth0 lladdr 52:54:00:12:35:02 router REACHABLE".
+func parseNeighbours(out string) []*Neighbour {
+	var neighbours []*Neighbour
+	for _, line := range strings.Split(trim(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		neighbour := &Neighbour{Family: "inet", Address: fields[0], State: fields[len(fields)-1]}
+		if strings.Contains(neighbour.Address, ":") {
+			neighbour.Family = "inet6"
+		}
+		for i := 1; i+1 < len(fields); i++ {
+			switch fields[i] {
+			case "dev":
+				neighbour.Interface = fields[i+1]
+			case "lladdr":
+				neighbour.MAC = fields[i+1]
+			}
+		}
+		neighbours = append(neighbours, neighbour)
+	}
+	return neighbours
+}
+
+// configLines returns the fields of all lines of a configuration file, without comments.
+func configLines(content string) (lines [][]string) {
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if index := strings.IndexAny(line, "#;"); index >= 0 {
+			line = line[:index]
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			lines = append(lines, fields)
+		}
+	}
+	return lines
+}
+
+func parseResolvConf(content string) *Resolver {
+	resolver := &Resolver{Nameservers: []string{}, Search: []string{}, Options: []string{}}
+	var domain []string
+	for _, fields := range configLines(content) {
+		switch fields[0] {
+		case "nameserver":
+			resolver.Nameservers = append(resolver.Nameservers, fields[1:]...)
+		case "search":
+			resolver.Search = fields[1:] // the last one wins
+		case "domain":
+			domain = fields[1:]
+		case "options":
+			resolver.Options = append(resolver.Options, fields[1:]...)
+		}
+	}
+	if len(resolver.Search) == 0 && len(domain) > 0 {
+		resolver.Search = domain
+	}
+	return resolver
+}
+
+func parseHosts(content string) []*HostsEntry {
+	hosts := []*HostsEntry{}
+	for _, fields := range configLines(content) {
+		if len(fields) > 1 {
+			hosts = append(hosts, &HostsEntry{Address: fields[0], Names: fields[1:]})
+		}
+	}
+	return hosts
+}
+
+func parseNSSwitch(content string) map[string][]string {
+	databases := make(map[string][]string)
+	for _, fields := range configLines(content) {
+		if strings.HasSuffix(fields[0], ":") {
+			databases[strings.TrimSuffix(fields[0], ":")] = fields[1:]
+		}
+	}
+	return databases
+}
+
+// routing collects its parts independently, those that can not be read are reported as warnings.
+// A missing nsswitch.conf is not an error, e.g. musl based distributions do not have one.
+func routing() (*Routing, error) {
+	data := &Routing{Routes: []*Route{}, Neighbours: []*Neighbour{}, Resolver: parseResolvConf(""), Hosts: []*HostsEntry{}, NSSwitch: map[string][]string{}}
+	var warnings Warnings
+	read := func(file string, optional bool) (string, bool) {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			if !optional || !os.IsNotExist(err) {
+				warnings = append(warnings, err.Error())
+			}
+			return "", false
+		}
+		return string(content), true
+	}
+
+	if content, ok := read(procDir+"/net/route", false); ok {
+		routes, err := parseRoutes(content)
+		if err != nil {
+			warnings = append(warnings, err.Error())
+		}
+		data.Routes = append(data.Routes, routes...)
+	}
+	if content, ok := read(procDir+"/net/ipv6_route", true); ok {
+		routes, err := parseIPv6Routes(content)
+		if err != nil {
+			warnings = append(warnings, err.Error())
+		}
+		data.Routes = append(data.Routes, routes...)
+	}
+	data.DefaultGateways = defaultGateways(data.Routes)
+
+	if content, ok := read(procDir+"/net/arp", false); ok {
+		data.Neighbours = append(data.Neighbours, parseARP(content)...)
+	}
+	if _, err := os.Stat(procDir + "/net/ipv6_route"); err == nil {
+		out, err := exec.Command("ip", "-6", "neigh").Output()
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("ip -6 neigh: %v", err))
+		}
+		data.Neighbours = append(data.Neighbours, parseNeighbours(string(out))...)
+	}
+
+	if content, ok := read(resolvConf, false); ok {
+		data.Resolver = parseResolvConf(content)
+	}
+	if content, ok := read(hostsFile, false); ok {
+		data.Hosts = parseHosts(content)
+	}
+	if content, ok := read(nsswitchConf, true); ok {
+		data.NSSwitch = parseNSSwitch(content)
+	}
+
+	if len(warnings) > 0 {
+		return data, warnings
+	}
+	return data, nil
+}
@@ -0,0 +1,137 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const procNetRoute = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	0102A8C0	0003	0	0	100	00000000	0	0	0
+eth0	0002A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+docker0	000011AC	00000000	0001	0	0	0	0000FFFF	0	0	0
+eth0	0A0A0A0A	00000000	0201	0	0	0	FFFFFFFF	0	0	0
+`
+
+const procNetIPv6Route = `20010db8000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth0
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000400 00000001 00000000 00000003     eth0
+20010db8000000000000000000000002 80 00000000000000000000000000000000 00 00000000000000000000000000000000 00000000 00000002 00000000 80200001     eth0
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 ffffffff 00000001 00000000 00200200       lo
+`
+
+func Test_todoapp_routing_parseRoutes(t *testing.T) {
+	routes, err := parseRoutes(procNetRoute)
+	Expect(t, err, nil)
+	Expect(t, len(routes), 3)
+	Expect(t, *routes[0], Route{Family: "inet", Destination: "0.0.0.0/0", Gateway: "192.168.2.1", Interface: "eth0", Metric: 100, Flags: "UG"})
+	Expect(t, routes[1].Destination, "192.168.2.0/24")
+	Expect(t, routes[1].Gateway, "")
+	Expect(t, routes[2].Destination, "172.17.0.0/16")
+
+	routes, err = parseIPv6Routes(procNetIPv6Route)
+	Expect(t, err, nil)
+	Expect(t, len(routes), 2)
+	Expect(t, *routes[0], Route{Family: "inet6", Destination: "2001:db8::/64", Interface: "eth0", Metric: 256, Flags: "U"})
+	Expect(t, routes[1].Destination, "::/0")
+	Expect(t, routes[1].Gateway, "fe80::1")
+	Expect(t, routes[1].Metric, uint64(1024))
+
+	_, err = parseRoutes("eth0 XYZ 0 0003 0 0 0 0\n")
+	NotExpect(t, err, nil)
+	_, err = parseIPv6Routes("2001 40 0 00 0 0 0 0 1 eth0\n")
+	NotExpect(t, err, nil)
+}
+
+func Test_todoapp_routing_parseNeighbours(t *testing.T) {
+	arp := parseARP(`IP address       HW type     Flags       HW address            Mask     Device
+192.168.2.1      0x1         0x2         52:54:00:12:35:02     *        eth0
+192.168.2.9      0x1         0x0         00:00:00:00:00:00     *        eth0
+192.168.2.7      0x1         0x6         52:54:00:12:35:07     *        eth0
+`)
+	Expect(t, len(arp), 3)
+	Expect(t, *arp[0], Neighbour{Family: "inet", Address: "192.168.2.1", MAC: "52:54:00:12:35:02", Interface: "eth0", State: "REACHABLE"})
+	Expect(t, arp[1].State, "INCOMPLETE")
+	Expect(t, arp[2].State, "PERMANENT")
+
+	ndp := parseNeighbours("fe80::1 dev eth0 lladdr 52:54:00:12:35:02 router REACHABLE\nfe80::5 dev eth0 FAILED\n")
+	Expect(t, len(ndp), 2)
+	Expect(t, *ndp[0], Neighbour{Family: "inet6", Address: "fe80::1", MAC: "52:54:00:12:35:02", Interface: "eth0", State: "REACHABLE"})
+	Expect(t, ndp[1].MAC, "")
+	Expect(t, ndp[1].State, "FAILED")
+	Expect(t, len(parseNeighbours("")), 0)
+}
+
+func Test_todoapp_routing_parseConfig(t *testing.T) {
+	resolver := parseResolvConf(`# generated by NetworkManager
+domain example.org
+search corp.example.org example.org
+nameserver 10.0.0.2
+nameserver 10.0.0.3 ; secondary
+options ndots:2 timeout:1
+`)
+	Expect(t, resolver.Nameservers, []string{"10.0.0.2", "10.0.0.3"})
+	Expect(t, resolver.Search, []string{"corp.example.org", "example.org"})
+	Expect(t, resolver.Options, []string{"ndots:2", "timeout:1"})
+	Expect(t, parseResolvConf("domain example.org\n").Search, []string{"example.org"})
+
+	hosts := parseHosts("127.0.0.1 localhost\n::1 localhost ip6-localhost # loopback\n\n10.0.0.1\n")
+	Expect(t, len(hosts), 2)
+	Expect(t, *hosts[1], HostsEntry{Address: "::1", Names: []string{"localhost", "ip6-localhost"}})
+
+	nsswitch := parseNSSwitch("# comment\npasswd: files systemd\nhosts:      files mdns4_minimal [NOTFOUND=return] dns\n")
+	Expect(t, nsswitch["hosts"], []string{"files", "mdns4_minimal", "[NOTFOUND=return]", "dns"})
+	Expect(t, nsswitch["passwd"], []string{"files", "systemd"})
+}
+
+func Test_todoapp_routing(t *testing.T) {
+	originalProc, originalResolv, originalHosts, originalNSSwitch := procDir, resolvConf, hostsFile, nsswitchConf
+	defer func() {
+		procDir, resolvConf, hostsFile, nsswitchConf = originalProc, originalResolv, originalHosts, originalNSSwitch
+	}()
+	dir, err := ioutil.TempDir("", "dashboard-routing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// no ipv6 and no nsswitch.conf, like on an alpine container with ipv6 disabled
+	files := map[string]string{
+		"net/route":   procNetRoute,
+		"net/arp":     "IP address       HW type     Flags       HW address            Mask     Device\n",
+		"resolv.conf": "nameserver 10.0.0.2\n",
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(file), 0755)
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	procDir = dir
+	resolvConf = filepath.Join(dir, "resolv.conf")
+	nsswitchConf = filepath.Join(dir, "nsswitch.conf")
+	hostsFile = filepath.Join(dir, "hosts")
+
+	data, err := routing()
+	Expect(t, err, Warnings{"open " + hostsFile + ": no such file or directory"})
+	Expect(t, len(data.Routes), 3)
+	Expect(t, len(data.DefaultGateways), 1)
+	Expect(t, data.DefaultGateways[0].Gateway, "192.168.2.1")
+	Expect(t, data.Resolver.Nameservers, []string{"10.0.0.2"})
+	Expect(t, len(data.Hosts), 0)
+	Expect(t, len(data.NSSwitch), 0)
+}
+
+func Test_todoapp_api_GetRouting(t *testing.T) {
+	var data Routing
+	response := getV2(t, "/api/routing", &data)
+	Expect(t, response.Code, http.StatusOK)
+	NotExpect(t, len(data.Routes), 0)
+	Expect(t, data.Resolver != nil, true)
+}
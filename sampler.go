@@ -0,0 +1,69 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	sampleInterval = 500 * time.Millisecond // between the two readings of a first sample
+	maxSampleAge   = time.Minute            // older readings are not used for rates anymore
+)
+
+// sampler turns counters into rates, by remembering the previous reading of a counter source.
+// Rates cover the time since the previous call, or sampleInterval if there is no recent reading.
+// Calls less than sampleInterval apart share a sample, so concurrent clients can't shrink the window to nothing.
+type sampler struct {
+	read func() (interface{}, error)
+
+	sync.Mutex
+	last     interface{}
+	at       time.Time
+	previous interface{} // the reading before last
+	seconds  float64     // between previous and last
+}
+
+func newSampler(read func() (interface{}, error)) *sampler {
+	return &sampler{read: read}
+}
+
+// sample returns the previous and the current reading and the seconds between them.
+func (s *sampler) sample() (previous, current interface{}, seconds float64, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.last == nil || time.Since(s.at) > maxSampleAge {
+		if s.last, err = s.read(); err != nil {
+			s.last, s.previous = nil, nil
+			return nil, nil, 0, err
+		}
+		s.at = time.Now()
+		time.Sleep(sampleInterval)
+	}
+
+	if s.previous != nil && time.Since(s.at) < sampleInterval {
+		return s.previous, s.last, s.seconds, nil
+	}
+
+	current, err = s.read()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	now := time.Now()
+	previous, seconds = s.last, now.Sub(s.at).Seconds()
+	s.previous, s.seconds = previous, seconds
+	s.last, s.at = current, now
+	return previous, current, seconds, nil
+}
+
+// rate returns the per second increase of a counter, counters that went backwards (e.g. on overflow) give 0.
+func rate(previous, current uint64, seconds float64) float64 {
+	if current < previous || seconds <= 0 {
+		return 0
+	}
+	return float64(current-previous) / seconds
+}
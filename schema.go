@@ -0,0 +1,158 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+)
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// enumerated is implemented by text marshalers that encode as one of a fixed set of strings.
+type enumerated interface {
+	enumValues() []string
+}
+
+// schemaFor generates the JSON Schema of a payload from its Go type, so schemas can't drift from what is actually served.
+// Fields are required unless they are pointers or tagged with omitempty, pointers, slices and maps without omitempty
+// may be null. Exported structs are added to components and referenced from there if components is not nil, otherwise
+// they are inlined.
+func schemaFor(t reflect.Type, components map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	if t.Implements(textMarshalerType) { // encoded as a string whatever the kind
+		schema := map[string]interface{}{"type": "string"}
+		if values, ok := reflect.Zero(t).Interface().(enumerated); ok {
+			schema["enum"] = values.enumValues()
+		}
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), components)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), components)}
+	case reflect.Struct:
+		if components == nil || !isExported(t.Name()) {
+			return structSchema(t, components)
+		}
+		if _, ok := components[t.Name()]; !ok {
+			components[t.Name()] = nil // reserve the name, structs may reference themselves
+			components[t.Name()] = structSchema(t, components)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type, components map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if len(field.PkgPath) > 0 { // unexported
+			continue
+		}
+		name, omitempty := field.Name, false
+		if tag := field.Tag.Get("json"); len(tag) > 0 {
+			options := strings.Split(tag, ",")
+			if options[0] == "-" {
+				continue
+			}
+			if len(options[0]) > 0 {
+				name = options[0]
+			}
+			omitempty = containsString(options[1:], "omitempty")
+		}
+		optional := omitempty || field.Type.Kind() == reflect.Ptr
+		properties[name] = schemaFor(field.Type, components)
+		if kind := field.Type.Kind(); (kind == reflect.Ptr || kind == reflect.Slice || kind == reflect.Map) && !omitempty {
+			properties[name] = nullable(properties[name].(map[string]interface{}), components)
+		}
+		if !optional {
+			required = append(required, name)
+		}
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+// nullable allows null in addition to a schema, as nil pointers, slices and maps are encoded. JSON Schema lists it as
+// another type, OpenAPI 3.0, which is used if components is not nil, has a keyword of its own that is ignored next to $ref.
+func nullable(schema map[string]interface{}, components map[string]interface{}) map[string]interface{} {
+	if _, ok := schema["$ref"]; ok {
+		if components != nil {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		return map[string]interface{}{"anyOf": []interface{}{schema, map[string]interface{}{"type": "null"}}}
+	}
+	if components != nil {
+		schema["nullable"] = true
+	} else if kind, ok := schema["type"].(string); ok {
+		schema["type"] = []string{kind, "null"}
+	}
+	return schema
+}
+
+func isExported(name string) bool {
+	return len(name) > 0 && name[0] >= 'A' && name[0] <= 'Z'
+}
+
+func v2Schema(endpoint apiEndpoint) map[string]interface{} {
+	schema := schemaFor(reflect.TypeOf(endpoint.Payload), nil)
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["$id"] = "/api/v2/schemas/" + endpoint.Path
+	schema["title"] = fmt.Sprintf("/api/v2/%s", endpoint.Path)
+	return schema
+}
+
+// SchemasHandler lists the JSON Schema of every /api/v2 endpoint.
+func SchemasHandler(r render.Render) {
+	schemas := make(map[string]string)
+	for _, endpoint := range v2Endpoints {
+		schemas[endpoint.Path] = "/api/v2/schemas/" + endpoint.Path
+	}
+	r.JSON(http.StatusOK, schemas)
+}
+
+func SchemaHandler(params martini.Params, r render.Render) {
+	for _, endpoint := range v2Endpoints {
+		if endpoint.Path == params["name"] {
+			r.JSON(http.StatusOK, v2Schema(endpoint))
+			return
+		}
+	}
+	apiError(r, http.StatusNotFound, ErrNotFound, "", fmt.Errorf("no schema for [%s]", params["name"]))
+}
@@ -0,0 +1,402 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+)
+
+var (
+	rxSnapshotID    = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}Z-[A-Za-z0-9._-]+$`)
+	rxUnsafeID      = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+	errNoSnapshot   = errors.New("no such snapshot")
+	fleetHTTPClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Snapshot is the state of a host at one point in time, as collected by the /api/v2 collectors.
+// Collectors the reader may not access are left empty.
+type Snapshot struct {
+	ID         string
+	Hostname   string
+	Taken      time.Time
+	CPU        *CPUV2            `json:",omitempty"`
+	Memory     *MemoryV2         `json:",omitempty"`
+	Disks      []*DiskUsageV2    `json:",omitempty"`
+	Processes  []*ProcessV2      `json:",omitempty"`
+	Users      []*UserV2         `json:",omitempty"`
+	Interfaces []*IfV2           `json:",omitempty"`
+	Env        []*Env            `json:",omitempty"`
+	Errors     map[string]string `json:",omitempty"`
+}
+
+// SnapshotRef identifies a snapshot without its data.
+type SnapshotRef struct {
+	ID       string
+	Hostname string
+	Taken    time.Time
+}
+
+func (s *Snapshot) Ref() *SnapshotRef {
+	return &SnapshotRef{ID: s.ID, Hostname: s.Hostname, Taken: s.Taken}
+}
+
+// snapshotCollectors are the collectors a snapshot is made of.
+var snapshotCollectors = []string{"cpu", "mem", "disk", "top", "passwd", "network", "env"}
+
+// takeSnapshot collects the current state of this host, environment variables and command lines are stored redacted.
+func takeSnapshot(rd *redactor) *Snapshot {
+	return collectSnapshot(rd, snapshotCollectors)
+}
+
+// collectSnapshot is takeSnapshot restricted to the given collectors.
+func collectSnapshot(rd *redactor, methods []string) *Snapshot {
+	taken := time.Now().UTC()
+	s := &Snapshot{
+		ID:       taken.Format("20060102T150405Z") + "-" + rxUnsafeID.ReplaceAllString(currentHostname, "_"),
+		Hostname: currentHostname,
+		Taken:    taken,
+		Errors:   make(map[string]string),
+	}
+
+	for _, method := range methods {
+		data, err := dataV2(method)
+		if _, partial := err.(Warnings); err != nil && !partial {
+			s.Errors[method] = err.Error()
+			continue
+		}
+		switch data := data.(type) {
+		case *CPUV2:
+			s.CPU = data
+		case *MemoryV2:
+			s.Memory = data
+		case []*DiskUsageV2:
+			s.Disks = data
+		case *TopV2:
+			s.Processes = rd.Redact(data).(*TopV2).Processes
+		case []*UserV2:
+			s.Users = data
+		case []*IfV2:
+			s.Interfaces = data
+		case []*Env:
+			s.Env = rd.Env(data)
+		}
+	}
+	return s
+}
+
+// filter returns a copy of the snapshot without the collectors role may not access.
+func (s *Snapshot) filter(p *permissions, role Role) *Snapshot {
+	filtered := *s
+	if !p.Collector(role, "cpu") {
+		filtered.CPU = nil
+	}
+	if !p.Collector(role, "mem") {
+		filtered.Memory = nil
+	}
+	if !p.Collector(role, "disk") {
+		filtered.Disks = nil
+	}
+	if !p.Collector(role, "top") {
+		filtered.Processes = nil
+	}
+	if !p.Collector(role, "passwd") {
+		filtered.Users = nil
+	}
+	if !p.Collector(role, "network") {
+		filtered.Interfaces = nil
+	}
+	if !p.Collector(role, "env") {
+		filtered.Env = nil
+	}
+	return &filtered
+}
+
+// snapshotStore keeps the most recent snapshots, on disk if a directory is configured and in memory otherwise.
+type snapshotStore struct {
+	dir  string
+	keep int
+
+	sync.Mutex
+	refs      []*SnapshotRef
+	snapshots map[string]*Snapshot
+}
+
+// setupSnapshots configures the snapshot store from the environment:
+//
+//	DASHBOARD_SNAPSHOT_DIR       directory to store snapshots in, they are only kept in memory if unset
+//	DASHBOARD_SNAPSHOT_KEEP      number of snapshots to keep (default 48)
+//	DASHBOARD_SNAPSHOT_INTERVAL  take a snapshot periodically, e.g. "1h"
+func setupSnapshots(rd *redactor) *snapshotStore {
+	store := &snapshotStore{
+		dir:       os.Getenv("DASHBOARD_SNAPSHOT_DIR"),
+		keep:      48,
+		snapshots: make(map[string]*Snapshot),
+	}
+	if value := os.Getenv("DASHBOARD_SNAPSHOT_KEEP"); len(value) > 0 {
+		keep, err := strconv.Atoi(value)
+		if err != nil || keep < 1 {
+			log.Fatalf("Invalid DASHBOARD_SNAPSHOT_KEEP [%s]", value)
+		}
+		store.keep = keep
+	}
+	if len(store.dir) > 0 {
+		if err := store.load(); err != nil {
+			log.Fatalf("Could not load snapshots from [%s]: %v", store.dir, err)
+		}
+	}
+
+	if value := os.Getenv("DASHBOARD_SNAPSHOT_INTERVAL"); len(value) > 0 {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < time.Minute {
+			log.Fatalf("Invalid DASHBOARD_SNAPSHOT_INTERVAL [%s], must be at least 1m", value)
+		}
+		go func() {
+			for range time.Tick(interval) {
+				if err := store.Save(takeSnapshot(rd)); err != nil {
+					log.Printf("Could not store snapshot: %v\n", err)
+				}
+			}
+		}()
+	}
+	return store
+}
+
+func (s *snapshotStore) load() error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		snapshot, err := s.read(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			log.Printf("Skipping snapshot [%s]: %v\n", file, err)
+			continue
+		}
+		s.refs = append(s.refs, snapshot.Ref())
+	}
+	sort.Slice(s.refs, func(i, j int) bool { return s.refs[i].Taken.Before(s.refs[j].Taken) })
+	return nil
+}
+
+func (s *snapshotStore) read(id string) (*Snapshot, error) {
+	content, err := ioutil.ReadFile(filepath.Join(s.dir, id+".json"))
+	if os.IsNotExist(err) {
+		return nil, errNoSnapshot
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// Save stores a snapshot and drops the oldest ones beyond the configured number to keep.
+// IDs have a resolution of one second, a snapshot taken within the same second as a stored one gets a numbered suffix.
+func (s *snapshotStore) Save(snapshot *Snapshot) error {
+	s.Lock()
+	defer s.Unlock()
+
+	id := snapshot.ID
+	for n := 2; s.exists(snapshot.ID); n++ {
+		snapshot.ID = fmt.Sprintf("%s-%d", id, n)
+	}
+
+	if len(s.dir) > 0 {
+		content, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(s.dir, snapshot.ID+".json"), content, 0600); err != nil {
+			return err
+		}
+	} else {
+		s.snapshots[snapshot.ID] = snapshot
+	}
+	s.refs = append(s.refs, snapshot.Ref())
+
+	for len(s.refs) > s.keep {
+		oldest := s.refs[0]
+		s.refs = s.refs[1:]
+		if len(s.dir) > 0 {
+			os.Remove(filepath.Join(s.dir, oldest.ID+".json"))
+		}
+		delete(s.snapshots, oldest.ID)
+	}
+	return nil
+}
+
+func (s *snapshotStore) exists(id string) bool {
+	for _, ref := range s.refs {
+		if ref.ID == id {
+			return true
+		}
+	}
+	if len(s.dir) > 0 {
+		// another dashboard process, e.g. "dashboard snapshot -save", may share the directory
+		_, err := os.Stat(filepath.Join(s.dir, id+".json"))
+		return err == nil
+	}
+	return false
+}
+
+// List returns all stored snapshots, oldest first.
+func (s *snapshotStore) List() []*SnapshotRef {
+	s.Lock()
+	defer s.Unlock()
+	return append([]*SnapshotRef{}, s.refs...)
+}
+
+func (s *snapshotStore) Load(id string) (*Snapshot, error) {
+	if !rxSnapshotID.MatchString(id) {
+		return nil, errNoSnapshot
+	}
+	s.Lock()
+	defer s.Unlock()
+	if len(s.dir) > 0 {
+		return s.read(id)
+	}
+	if snapshot, ok := s.snapshots[id]; ok {
+		return snapshot, nil
+	}
+	return nil, errNoSnapshot
+}
+
+// fleet are other dashboards whose current state can be compared with, configured as
+// DASHBOARD_FLEET="name=https://host:3443,..." and authenticated with the API token in DASHBOARD_FLEET_TOKEN.
+type fleet struct {
+	hosts map[string]string
+	token string
+}
+
+func setupFleet() *fleet {
+	f := &fleet{hosts: make(map[string]string), token: os.Getenv("DASHBOARD_FLEET_TOKEN")}
+	for _, host := range strings.Split(os.Getenv("DASHBOARD_FLEET"), ",") {
+		if len(trim(host)) == 0 {
+			continue
+		}
+		values := strings.SplitN(host, "=", 2)
+		if len(values) != 2 || !strings.HasPrefix(values[1], "http") {
+			log.Fatalf("Invalid DASHBOARD_FLEET entry [%s], expected name=url", host)
+		}
+		f.hosts[trim(values[0])] = strings.TrimSuffix(trim(values[1]), "/")
+	}
+	if os.Getenv("DASHBOARD_FLEET_INSECURE") == "true" { // fleets commonly run on self-signed certificates
+		fleetHTTPClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	return f
+}
+
+func (f *fleet) Names() (names []string) {
+	for name := range f.hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Current fetches the current snapshot of a fleet host.
+func (f *fleet) Current(name string) (*Snapshot, error) {
+	url, ok := f.hosts[name]
+	if !ok {
+		return nil, errNoSnapshot
+	}
+	req, err := http.NewRequest("GET", url+"/api/snapshots/current", nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+	resp, err := fleetHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fleet host [%s] answered with status %d", name, resp.StatusCode)
+	}
+
+	var snapshot Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		return nil, err
+	}
+	snapshot.ID = "host:" + name
+	return &snapshot, nil
+}
+
+// resolveSnapshot finds a snapshot by id, "current" is taken right now and "host:<name>" is fetched from the fleet.
+func resolveSnapshot(id string, store *snapshotStore, f *fleet, rd *redactor) (*Snapshot, error) {
+	switch {
+	case id == "current":
+		snapshot := takeSnapshot(rd)
+		snapshot.ID = "current"
+		return snapshot, nil
+	case strings.HasPrefix(id, "host:"):
+		return f.Current(strings.TrimPrefix(id, "host:"))
+	}
+	return store.Load(id)
+}
+
+type snapshotsView struct {
+	Snapshots []*SnapshotRef
+	Fleet     []string
+}
+
+func SnapshotsHandler(store *snapshotStore, f *fleet, r render.Render) {
+	r.JSON(http.StatusOK, &snapshotsView{Snapshots: store.List(), Fleet: f.Names()})
+}
+
+func TakeSnapshotHandler(store *snapshotStore, p *permissions, rd *redactor, id *Identity, r render.Render) {
+	if !p.Action(id.Role, "snapshot") {
+		forbidden(r, id, "", "snapshot")
+		return
+	}
+	snapshot := takeSnapshot(rd)
+	if err := store.Save(snapshot); err != nil {
+		apiError(r, http.StatusInternalServerError, ErrCollectorFailed, "", err)
+		return
+	}
+	r.JSON(http.StatusCreated, snapshot.filter(p, id.Role))
+}
+
+func SnapshotHandler(params martini.Params, store *snapshotStore, f *fleet, p *permissions, rd *redactor, id *Identity, r render.Render) {
+	snapshot, err := resolveSnapshot(params["id"], store, f, rd)
+	if err != nil {
+		snapshotError(r, params["id"], err)
+		return
+	}
+	r.JSON(http.StatusOK, snapshot.filter(p, id.Role))
+}
+
+func snapshotError(r render.Render, id string, err error) {
+	if err == errNoSnapshot {
+		apiError(r, http.StatusNotFound, ErrNotFound, "", fmt.Errorf("no such snapshot [%s]", id))
+		return
+	}
+	apiError(r, http.StatusBadGateway, ErrUpstreamFailed, "", err)
+}
@@ -0,0 +1,246 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_todoapp_diffSnapshots(t *testing.T) {
+	from := &Snapshot{
+		ID:        "20260101T000000Z-a",
+		CPU:       &CPUV2{Load1: 1.0, Load5: 1.0, TotalProcesses: 100},
+		Disks:     []*DiskUsageV2{{Filesystem: "/dev/sda1", MountedOn: "/", UsedBytes: 1000}, {Filesystem: "tmpfs", MountedOn: "/tmp"}},
+		Processes: []*ProcessV2{{Pid: 1, User: "root", Command: "init"}, {Pid: 42, User: "www", Command: "nginx"}},
+		Users:     []*UserV2{{Name: "alice", Uid: 1000, Gid: 1000, Shell: "/bin/sh"}},
+		Env:       []*Env{{"PATH", "/bin"}, {"OLD", "1"}},
+	}
+	to := &Snapshot{
+		ID:        "20260101T010000Z-a",
+		CPU:       &CPUV2{Load1: 1.05, Load5: 2.0, TotalProcesses: 100},
+		Disks:     []*DiskUsageV2{{Filesystem: "/dev/sda1", MountedOn: "/", UsedBytes: 1500}, {Filesystem: "/dev/sdb1", MountedOn: "/data"}},
+		Processes: []*ProcessV2{{Pid: 1, User: "root", Command: "init"}, {Pid: 43, User: "www", Command: "nginx"}, {Pid: 50, User: "root", Command: "sshd"}},
+		Users:     []*UserV2{{Name: "alice", Uid: 1000, Gid: 1000, Shell: "/bin/bash"}},
+		Env:       []*Env{{"PATH", "/bin"}, {"NEW", "2"}},
+	}
+
+	d := diffSnapshots(from, to, 0.1)
+	Expect(t, d.From.ID, from.ID)
+	Expect(t, d.To.ID, to.ID)
+
+	// restarted processes with a new pid are not reported
+	Expect(t, d.Processes.Added, []string{"root sshd"})
+	Expect(t, len(d.Processes.Removed), 0)
+	Expect(t, d.Mounts.Added, []string{"/data"})
+	Expect(t, d.Mounts.Removed, []string{"/tmp"})
+	Expect(t, d.Users.Changed[0].Key, "alice")
+	Contain(t, d.Users.Changed[0].To, "shell=/bin/bash")
+	Expect(t, d.Env.Added, []string{"NEW"})
+	Expect(t, d.Env.Removed, []string{"OLD"})
+	// interfaces were not collected in either snapshot
+	Expect(t, d.Interfaces == nil, true)
+
+	// load1 only changed by 5%, below the threshold
+	var names []string
+	for _, metric := range d.Metrics {
+		names = append(names, metric.Name)
+	}
+	Expect(t, names, []string{"cpu.load5", "disk./.used_bytes"})
+	Expect(t, *d.Metrics[0].Change, 1.0)
+	Expect(t, *d.Metrics[1].Change, 0.5)
+
+	// growing from zero is always significant, but has no relative change
+	d = diffSnapshots(&Snapshot{CPU: &CPUV2{}}, &Snapshot{CPU: &CPUV2{Load15: 0.5}}, 0.1)
+	Expect(t, len(d.Metrics), 1)
+	Expect(t, d.Metrics[0].Name, "cpu.load15")
+	Expect(t, d.Metrics[0].Change == nil, true)
+
+	Expect(t, len(diffSnapshots(from, to, 0.01).Metrics), 3)
+}
+
+func Test_todoapp_snapshotStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dashboard-snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("DASHBOARD_SNAPSHOT_DIR", dir)
+	os.Setenv("DASHBOARD_SNAPSHOT_KEEP", "2")
+	defer os.Unsetenv("DASHBOARD_SNAPSHOT_DIR")
+	defer os.Unsetenv("DASHBOARD_SNAPSHOT_KEEP")
+
+	store := setupSnapshots(setupRedactor())
+	taken := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		taken = taken.Add(time.Hour)
+		snapshot := &Snapshot{ID: taken.Format("20060102T150405Z") + "-test", Hostname: "test", Taken: taken}
+		if err := store.Save(snapshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the oldest snapshot has been pruned, from disk as well
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	Expect(t, len(files), 2)
+	_, err = store.Load("20260101T010000Z-test")
+	Expect(t, err, errNoSnapshot)
+
+	// a new store picks up the snapshots on disk
+	refs := setupSnapshots(setupRedactor()).List()
+	Expect(t, len(refs), 2)
+	Expect(t, refs[0].ID, "20260101T020000Z-test")
+	Expect(t, refs[1].ID, "20260101T030000Z-test")
+
+	_, err = store.Load("../etc/passwd")
+	Expect(t, err, errNoSnapshot)
+}
+
+func Test_todoapp_snapshotStore_SameSecond(t *testing.T) {
+	os.Setenv("DASHBOARD_SNAPSHOT_KEEP", "2")
+	defer os.Unsetenv("DASHBOARD_SNAPSHOT_KEEP")
+
+	store := setupSnapshots(setupRedactor())
+	taken := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		snapshot := &Snapshot{ID: taken.Format("20060102T150405Z") + "-test", Hostname: "test", Taken: taken}
+		if err := store.Save(snapshot); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, snapshot.ID)
+	}
+	Expect(t, ids[0], "20260101T000000Z-test")
+	Expect(t, ids[1], "20260101T000000Z-test-2")
+	Expect(t, ids[2], "20260101T000000Z-test-3")
+
+	// pruning the oldest must not take the data of the others with it
+	refs := store.List()
+	Expect(t, len(refs), 2)
+	for _, ref := range refs {
+		snapshot, err := store.Load(ref.ID)
+		Expect(t, err, nil)
+		Expect(t, snapshot.ID, ref.ID)
+	}
+	_, err := store.Load(ids[0])
+	Expect(t, err, errNoSnapshot)
+}
+
+func Test_todoapp_api_Snapshots(t *testing.T) {
+	m := setupMartini()
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "http://localhost:4005/api/snapshots", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusCreated)
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(response.Body.Bytes(), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, snapshot.Hostname, currentHostname)
+	Expect(t, snapshot.CPU != nil, true)
+	NotExpect(t, len(snapshot.Disks), 0)
+
+	var list snapshotsView
+	response = getV2(t, "/api/snapshots", &list)
+	Expect(t, response.Code, http.StatusOK)
+	// every call to setupMartini starts with an empty in-memory store
+	Expect(t, len(list.Snapshots), 0)
+
+	response = getV2(t, "/api/snapshots/20260101T000000Z-nope", nil)
+	Expect(t, response.Code, http.StatusNotFound)
+	Contain(t, response.Body.String(), `"Code": "not_found"`)
+
+	var current Snapshot
+	response = getV2(t, "/api/snapshots/current", &current)
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, current.ID, "current")
+}
+
+func Test_todoapp_api_Diff(t *testing.T) {
+	response := getV2(t, "/api/diff", nil)
+	Expect(t, response.Code, http.StatusNotFound)
+	Contain(t, response.Body.String(), "no snapshots stored yet")
+
+	response = getV2(t, "/api/diff?from=current&threshold=x", nil)
+	Expect(t, response.Code, http.StatusBadRequest)
+	Contain(t, response.Body.String(), `"Code": "bad_request"`)
+
+	var d SnapshotDiff
+	response = getV2(t, "/api/diff?from=current&to=current", &d)
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, d.From.Hostname, currentHostname)
+	Expect(t, d.Threshold, defaultThreshold)
+	Expect(t, len(d.Mounts.Added), 0)
+	Expect(t, len(d.Users.Removed), 0)
+}
+
+func Test_todoapp_api_Diff_Fleet(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/snapshots/current" || req.Header.Get("Authorization") != "Bearer fleet-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(&Snapshot{
+			ID:       "current",
+			Hostname: "remote",
+			Taken:    time.Now(),
+			Users:    []*UserV2{{Name: "fleet-only-user"}},
+		})
+	}))
+	defer remote.Close()
+
+	os.Setenv("DASHBOARD_FLEET", "remote="+remote.URL+", broken="+remote.URL+"/nope")
+	os.Setenv("DASHBOARD_FLEET_TOKEN", "fleet-token")
+	defer os.Unsetenv("DASHBOARD_FLEET")
+	defer os.Unsetenv("DASHBOARD_FLEET_TOKEN")
+
+	var list snapshotsView
+	getV2(t, "/api/snapshots", &list)
+	Expect(t, list.Fleet, []string{"broken", "remote"})
+
+	var d SnapshotDiff
+	response := getV2(t, "/api/diff?from=host:remote&to=current", &d)
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, d.From.ID, "host:remote")
+	Expect(t, d.From.Hostname, "remote")
+	Expect(t, d.Users.Removed, []string{"fleet-only-user"})
+	// collectors missing on one side are left out
+	Expect(t, d.Processes == nil, true)
+
+	response = getV2(t, "/api/diff?from=host:broken", nil)
+	Expect(t, response.Code, http.StatusBadGateway)
+	Contain(t, response.Body.String(), `"Code": "upstream_failed"`)
+
+	response = getV2(t, "/api/diff?from=host:unknown", nil)
+	Expect(t, response.Code, http.StatusNotFound)
+}
+
+func Test_todoapp_api_Snapshots_Forbidden(t *testing.T) {
+	m, cleanup := setupAuthMartini(t)
+	defer cleanup()
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "http://localhost:4005/api/snapshots", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer s3cr3t-t0k3n")
+
+	m.ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusForbidden)
+	Contain(t, response.Body.String(), `is not allowed to access [snapshot]`)
+}
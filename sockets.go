@@ -0,0 +1,254 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+	"0C": "NEW_SYN_RECV",
+}
+
+var unixStates = map[string]string{
+	"01": "UNCONNECTED",
+	"02": "CONNECTING",
+	"03": "CONNECTED",
+	"04": "DISCONNECTING",
+}
+
+// unixAcceptConnections is __SO_ACCEPTCON in the flags column of /proc/net/unix, set on listening sockets.
+const unixAcceptConnections = 0x10000
+
+type Socket struct {
+	Protocol      string
+	State         string
+	LocalAddress  string // a path for unix sockets, abstract ones start with @
+	LocalPort     int
+	RemoteAddress string
+	RemotePort    int
+	UID           int
+	Pid           int // 0 if the owning process is unknown
+	Command       string
+	inode         uint64
+}
+
+type Sockets struct {
+	Counts  map[string]map[string]int // of all sockets, by protocol and state
+	Sockets []*Socket                 // listening sockets first, then established connections
+}
+
+// parseSocketAddress decodes an address of /proc/net/tcp and friends, e.g. 0100007F:1F90 for 127.0.0.1:8080.
+// The address is printed as 32 bit words in host byte order, which is little endian on all platforms we run on.
+func parseSocketAddress(value string) (string, int, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return "", 0, fmt.Errorf("invalid socket address [%s]", value)
+	}
+	raw, err := hex.DecodeString(parts[0])
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", 0, fmt.Errorf("invalid socket address [%s]", value)
+	}
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid socket port [%s]", value)
+	}
+
+	ip := make(net.IP, len(raw))
+	for word := 0; word < len(raw); word += 4 {
+		for i := 0; i < 4; i++ {
+			ip[word+i] = raw[word+3-i]
+		}
+	}
+	return ip.String(), int(port), nil
+}
+
+// parseInetSockets parses /proc/net/tcp, tcp6, udp or udp6.
+func parseInetSockets(protocol, content string) ([]*Socket, error) {
+	var sockets []*Socket
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] == "sl" {
+			continue
+		}
+		if len(fields) < 10 {
+			return nil, fmt.Errorf("invalid /proc/net/%s line [%s]", protocol, scanner.Text())
+		}
+		socket := &Socket{Protocol: protocol}
+		var err error
+		if socket.LocalAddress, socket.LocalPort, err = parseSocketAddress(fields[1]); err != nil {
+			return nil, err
+		}
+		if socket.RemoteAddress, socket.RemotePort, err = parseSocketAddress(fields[2]); err != nil {
+			return nil, err
+		}
+		socket.State = tcpStates[fields[3]]
+		if strings.HasPrefix(protocol, "udp") && fields[3] == "07" {
+			socket.State = "UNCONN" // a bound udp socket, the equivalent of listening
+		}
+		socket.UID, _ = strconv.Atoi(fields[7])
+		socket.inode, _ = strconv.ParseUint(fields[9], 10, 64)
+		sockets = append(sockets, socket)
+	}
+	return sockets, scanner.Err()
+}
+
+func parseUnixSockets(content string) ([]*Socket, error) {
+	var sockets []*Socket
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] == "Num" {
+			continue
+		}
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("invalid /proc/net/unix line [%s]", scanner.Text())
+		}
+		socket := &Socket{Protocol: "unix", State: unixStates[fields[5]]}
+		if flags, _ := strconv.ParseUint(fields[3], 16, 32); flags&unixAcceptConnections != 0 {
+			socket.State = "LISTEN"
+		}
+		socket.inode, _ = strconv.ParseUint(fields[6], 10, 64)
+		if len(fields) > 7 {
+			socket.LocalAddress = fields[7]
+		}
+		sockets = append(sockets, socket)
+	}
+	return sockets, scanner.Err()
+}
+
+// socketOwners maps socket inodes to the pids having them open, by reading the /proc/<pid>/fd links.
+// Without root, the file descriptors of other users' processes can not be read, those are returned as denied.
+func socketOwners() (owners map[uint64]int, denied int) {
+	owners = make(map[uint64]int)
+	dirs, _ := filepath.Glob(procDir + "/[0-9]*/fd")
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(dir)))
+		if err != nil {
+			continue
+		}
+		fds, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if os.IsPermission(err) {
+				denied++
+			}
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(dir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+			if _, ok := owners[inode]; !ok {
+				owners[inode] = pid
+			}
+		}
+	}
+	return owners, denied
+}
+
+func processCommand(pid int) string {
+	content, err := ioutil.ReadFile(fmt.Sprintf("%s/%d/comm", procDir, pid))
+	if err != nil { // the process has exited in the meantime
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// summarizeSockets keeps listening sockets and established connections, and counts all sockets by state.
+func summarizeSockets(sockets []*Socket) *Sockets {
+	result := &Sockets{Counts: make(map[string]map[string]int)}
+	var listening, connections []*Socket
+	for _, socket := range sockets {
+		switch socket.State {
+		case "LISTEN", "UNCONN":
+			listening = append(listening, socket)
+		case "ESTABLISHED":
+			connections = append(connections, socket)
+		}
+		if result.Counts[socket.Protocol] == nil {
+			result.Counts[socket.Protocol] = make(map[string]int)
+		}
+		result.Counts[socket.Protocol][socket.State]++
+	}
+
+	sort.SliceStable(listening, func(i, j int) bool {
+		a, b := listening[i], listening[j]
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		if a.LocalPort != b.LocalPort {
+			return a.LocalPort < b.LocalPort
+		}
+		return a.LocalAddress < b.LocalAddress
+	})
+	result.Sockets = append(append([]*Socket{}, listening...), connections...)
+	return result
+}
+
+func sockets() (*Sockets, error) {
+	var all []*Socket
+	for _, protocol := range []string{"tcp", "tcp6", "udp", "udp6", "unix"} {
+		content, err := ioutil.ReadFile(procDir + "/net/" + protocol)
+		if os.IsNotExist(err) { // e.g. ipv6 disabled
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var parsed []*Socket
+		if protocol == "unix" {
+			parsed, err = parseUnixSockets(string(content))
+		} else {
+			parsed, err = parseInetSockets(protocol, string(content))
+		}
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, parsed...)
+	}
+
+	owners, denied := socketOwners()
+	var unknown int
+	for _, socket := range all {
+		if pid, ok := owners[socket.inode]; ok {
+			socket.Pid, socket.Command = pid, processCommand(pid)
+		} else if socket.inode != 0 {
+			unknown++
+		}
+	}
+
+	result := summarizeSockets(all)
+	if denied > 0 && unknown > 0 {
+		return result, Warnings{fmt.Sprintf("owner of %d sockets unknown, the file descriptors of %d processes can not be read", unknown, denied)}
+	}
+	return result, nil
+}
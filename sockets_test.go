@@ -0,0 +1,151 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1234 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1235 1 0000000000000000 100 0 0 10 0
+   2: 0F02000A:1F90 0202000A:D431 01 00000000:00000000 00:00000000 00000000  1000        0 1236 1 0000000000000000 20 4 0 10 -1
+   3: 0F02000A:1F90 0202000A:D432 06 00000000:00000000 03:00000A2E 00000000     0        0 0 3 0000000000000000
+`
+
+const procNetTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:0016 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 2000 1 0000000000000000 100 0 0 10 0
+   1: 00000000000000000000000001000000:0277 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 2001 1 0000000000000000 100 0 0 10 0
+`
+
+const procNetUDP = `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  100: 3500007F:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 3000 2 0000000000000000 0
+`
+
+const procNetUnix = `Num       RefCount Protocol Flags    Type St Inode Path
+0000000000000000: 00000002 00000000 00010000 0001 01 4000 /run/docker.sock
+0000000000000000: 00000003 00000000 00000000 0001 03 4001
+0000000000000000: 00000003 00000000 00000000 0001 03 4002 @/tmp/.X11-unix/X0
+`
+
+func Test_todoapp_sockets_parseSocketAddress(t *testing.T) {
+	address, port, err := parseSocketAddress("0100007F:1F90")
+	Expect(t, err, nil)
+	Expect(t, address, "127.0.0.1")
+	Expect(t, port, 8080)
+
+	address, port, _ = parseSocketAddress("00000000000000000000000001000000:0277")
+	Expect(t, address, "::1")
+	Expect(t, port, 631)
+
+	address, _, _ = parseSocketAddress("B80D0120000000000000000001000000:0050")
+	Expect(t, address, "2001:db8::1")
+
+	for _, invalid := range []string{"0100007F", "01007F:1F90", "0100007F:XYZ", "zz00007F:1F90"} {
+		_, _, err := parseSocketAddress(invalid)
+		NotExpect(t, err, nil)
+	}
+}
+
+func Test_todoapp_sockets_parse(t *testing.T) {
+	tcp, err := parseInetSockets("tcp", procNetTCP)
+	Expect(t, err, nil)
+	Expect(t, len(tcp), 4)
+	Expect(t, *tcp[2], Socket{Protocol: "tcp", State: "ESTABLISHED", LocalAddress: "10.0.2.15", LocalPort: 8080, RemoteAddress: "10.0.2.2", RemotePort: 54321, UID: 1000, inode: 1236})
+	Expect(t, tcp[3].State, "TIME_WAIT")
+
+	udp, _ := parseInetSockets("udp", procNetUDP)
+	Expect(t, udp[0].State, "UNCONN")
+	Expect(t, udp[0].LocalAddress, "127.0.0.53")
+	Expect(t, udp[0].LocalPort, 53)
+
+	unix, err := parseUnixSockets(procNetUnix)
+	Expect(t, err, nil)
+	Expect(t, len(unix), 3)
+	Expect(t, unix[0].State, "LISTEN")
+	Expect(t, unix[0].LocalAddress, "/run/docker.sock")
+	Expect(t, unix[1].State, "CONNECTED")
+	Expect(t, unix[1].LocalAddress, "")
+	Expect(t, unix[2].LocalAddress, "@/tmp/.X11-unix/X0")
+
+	_, err = parseInetSockets("tcp", "0: 0100007F:1F90 00000000:0000 0A\n")
+	NotExpect(t, err, nil)
+}
+
+func Test_todoapp_sockets(t *testing.T) {
+	original := procDir
+	defer func() {
+		procDir = original
+	}()
+	dir, err := ioutil.TempDir("", "dashboard-proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	procDir = dir
+
+	files := map[string]string{
+		"net/tcp":  procNetTCP,
+		"net/tcp6": procNetTCP6,
+		"net/udp":  procNetUDP,
+		"net/unix": procNetUnix,
+		"42/comm":  "nginx\n",
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "42", "fd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Symlink("socket:[1234]", filepath.Join(dir, "42", "fd", "3"))
+	os.Symlink("socket:[1236]", filepath.Join(dir, "42", "fd", "4"))
+	os.Symlink("/dev/null", filepath.Join(dir, "42", "fd", "0"))
+
+	// udp6 is missing, like on hosts without ipv6
+	data, err := sockets()
+	Expect(t, err, nil)
+	Expect(t, data.Counts["tcp"], map[string]int{"LISTEN": 2, "ESTABLISHED": 1, "TIME_WAIT": 1})
+	Expect(t, data.Counts["unix"], map[string]int{"LISTEN": 1, "CONNECTED": 2})
+	Expect(t, data.Counts["udp"]["UNCONN"], 1)
+
+	// listening sockets sorted by protocol and port, then established connections
+	var listed []string
+	for _, socket := range data.Sockets {
+		listed = append(listed, socket.Protocol+" "+socket.State+" "+socket.LocalAddress)
+	}
+	Expect(t, listed, []string{
+		"tcp LISTEN 127.0.0.1",
+		"tcp LISTEN 0.0.0.0",
+		"tcp6 LISTEN ::",
+		"tcp6 LISTEN ::1",
+		"udp UNCONN 127.0.0.53",
+		"unix LISTEN /run/docker.sock",
+		"tcp ESTABLISHED 10.0.2.15",
+	})
+	Expect(t, data.Sockets[1].Pid, 42)
+	Expect(t, data.Sockets[1].Command, "nginx")
+	Expect(t, data.Sockets[6].Pid, 42)
+	Expect(t, data.Sockets[0].Pid, 0)
+}
+
+func Test_todoapp_api_GetSockets(t *testing.T) {
+	var data Sockets
+	response := getV2(t, "/api/sockets", &data)
+	Expect(t, response.Code, http.StatusOK)
+	Contain(t, response.Body.String(), `"Counts"`)
+
+	response = getV2(t, "/api/v2/sockets", &data)
+	Expect(t, response.Code, http.StatusOK)
+}
@@ -246,24 +246,8 @@ func df() (diskUsage []*DiskUsage, err error) {
 		}
 	}()
 
-	// df -hP | awk '{print $1";"$2";"$3";"$4";"$5";"$6;}'
-	out, err := pipes(
-		exec.Command("df", "-hP"),
-		exec.Command("awk", `{print $1";"$2";"$3";"$4";"$5";"$6;}`),
-	)
-
-	lines := strings.Split(trim(out), "\n")
-	for _, line := range lines[1:] {
-		if strings.HasPrefix(line, "Filesystem;") {
-			continue
-		}
-		if strings.Contains(line, "Permission denied") {
-			err = nil
-			continue
-		}
-
-		values := strings.SplitN(line, ";", 6)
-
+	rows, err := dfRows("-hP")
+	for _, values := range rows {
 		percentage, err := strconv.Atoi(strings.Trim(trim(values[4]), "%"))
 		if err != nil {
 			return nil, err
@@ -283,6 +267,39 @@ func df() (diskUsage []*DiskUsage, err error) {
 	return diskUsage, err
 }
 
+// dfRows returns the fields of every filesystem line of df, called with the given size flags.
+func dfRows(flags string) (rows [][]string, err error) {
+	// df <flags> | awk '{print $1";"$2";"$3";"$4";"$5";"$6;}'
+	var stderr bytes.Buffer
+	command := exec.Command("df", flags)
+	command.Stderr = &stderr
+	out, err := pipes(
+		command,
+		exec.Command("awk", `{print $1";"$2";"$3";"$4";"$5";"$6;}`),
+	)
+
+	// df exits non-zero if any mount is unreadable, but still reports all the others
+	var warnings Warnings
+	for _, line := range strings.Split(trim(stderr.String()), "\n") {
+		if len(trim(line)) > 0 {
+			warnings = append(warnings, trim(line))
+		}
+	}
+	if len(warnings) > 0 && len(trim(out)) > 0 {
+		err = warnings
+	}
+
+	lines := strings.Split(trim(out), "\n")
+	for _, line := range lines[1:] {
+		if strings.HasPrefix(line, "Filesystem;") {
+			continue
+		}
+		rows = append(rows, strings.SplitN(line, ";", 6))
+	}
+
+	return rows, err
+}
+
 type Top struct {
 	Header    []string
 	Processes []*Process
@@ -491,12 +508,21 @@ type Env struct {
 
 func env() (env []*Env) {
 	for _, e := range os.Environ() {
-		pair := strings.Split(e, "=")
-		env = append(env, &Env{pair[0], pair[1]})
+		key, value := splitEnv(e)
+		env = append(env, &Env{key, value})
 	}
 	return env
 }
 
+// splitEnv splits "key=value" at the first '=', values may contain '=' themselves.
+func splitEnv(e string) (key, value string) {
+	pair := strings.SplitN(e, "=", 2)
+	if len(pair) == 1 {
+		return pair[0], ""
+	}
+	return pair[0], pair[1]
+}
+
 func pipes(commands ...*exec.Cmd) (string, error) {
 	if len(commands) < 1 {
 		return "", errors.New("not enough commands passed to pipes()")
@@ -512,19 +538,25 @@ func pipes(commands ...*exec.Cmd) (string, error) {
 	}
 	commands[len(commands)-1].Stdout = &stdout
 
-	for _, command := range commands {
+	for i, command := range commands {
 		if err := command.Start(); err != nil {
-			return stdout.String(), err
+			for _, started := range commands[:i] {
+				started.Process.Kill()
+				started.Wait()
+			}
+			return "", err
 		}
 	}
 
+	// every command is waited on, stdout is only complete once the last one has exited
+	var failed error
 	for _, command := range commands {
-		if err := command.Wait(); err != nil {
-			return stdout.String(), err
+		if err := command.Wait(); err != nil && failed == nil {
+			failed = err
 		}
 	}
 
-	return stdout.String(), nil
+	return stdout.String(), failed
 }
 
 func trim(input string) string {
@@ -0,0 +1,24 @@
+//go:build !linux
+
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoTerminal = errors.New("the terminal UI is only supported on Linux")
+
+func makeRaw(f *os.File) (func() error, error) {
+	return nil, errNoTerminal
+}
+
+func terminalSize(f *os.File) (int, int, error) {
+	return 0, 0, errNoTerminal
+}
+
+var resizeSignals []os.Signal
@@ -0,0 +1,298 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var selfSignedValidity = 365 * 24 * time.Hour
+
+// setupTLS configures HTTPS from the environment:
+//
+//	DASHBOARD_TLS_CERT         certificate file (PEM), enables HTTPS
+//	DASHBOARD_TLS_KEY          private key file (PEM)
+//	DASHBOARD_TLS_PORT         HTTPS port (default 3443), PORT then only redirects to HTTPS
+//	DASHBOARD_TLS_CLIENT_CA    CA bundle to verify client certificates against, enables mutual TLS
+//	DASHBOARD_TLS_CLIENT_AUTH  "request" (default) accepts clients without certificate, "require" rejects them
+//
+// A self-signed certificate is generated if the certificate and key files don't exist yet.
+// Both files are reloaded when they change on disk, so renewed certificates don't need a restart.
+func setupTLS() (*tls.Config, error) {
+	certFile := os.Getenv("DASHBOARD_TLS_CERT")
+	keyFile := os.Getenv("DASHBOARD_TLS_KEY")
+	if len(certFile) == 0 && len(keyFile) == 0 {
+		return nil, nil
+	}
+	if len(certFile) == 0 || len(keyFile) == 0 {
+		return nil, errors.New("both DASHBOARD_TLS_CERT and DASHBOARD_TLS_KEY are required")
+	}
+
+	if !fileExists(certFile) && !fileExists(keyFile) {
+		log.Printf("Generating self-signed certificate [%s]\n", certFile)
+		if err := generateSelfSigned(certFile, keyFile, currentHostname); err != nil {
+			return nil, err
+		}
+	}
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if caFile := os.Getenv("DASHBOARD_TLS_CLIENT_CA"); len(caFile) > 0 {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		switch mode := envOrDefault("DASHBOARD_TLS_CLIENT_AUTH", "request"); mode {
+		case "request":
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		case "require":
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		default:
+			return nil, fmt.Errorf("invalid DASHBOARD_TLS_CLIENT_AUTH [%s]", mode)
+		}
+	}
+
+	return config, nil
+}
+
+// serve runs the dashboard on PORT, or on DASHBOARD_TLS_PORT with PORT redirecting to it if TLS is configured.
+func serve(handler http.Handler) {
+	host := os.Getenv("HOST")
+	port := envOrDefault("PORT", "3000")
+
+	config, err := setupTLS()
+	if err != nil {
+		log.Fatalf("Could not configure TLS: %v", err)
+	}
+	if config == nil {
+		log.Printf("Listening on %s:%s\n", host, port)
+		log.Fatal(http.ListenAndServe(host+":"+port, handler))
+	}
+
+	tlsPort := envOrDefault("DASHBOARD_TLS_PORT", "3443")
+	go func() {
+		log.Printf("Redirecting %s:%s to HTTPS\n", host, port)
+		log.Fatal(http.ListenAndServe(host+":"+port, httpsRedirect(tlsPort)))
+	}()
+
+	server := &http.Server{
+		Addr:      host + ":" + tlsPort,
+		Handler:   handler,
+		TLSConfig: config,
+	}
+	log.Printf("Listening on %s:%s (HTTPS)\n", host, tlsPort)
+	log.Fatal(server.ListenAndServeTLS("", ""))
+}
+
+// httpsRedirect permanently redirects every request to the same URL on the HTTPS port.
+func httpsRedirect(tlsPort string) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if tlsPort != "443" {
+			host = host + ":" + tlsPort
+		}
+		http.Redirect(res, req, "https://"+host+req.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// certReloader serves the current certificate and picks up changes of the files on disk.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (c *certReloader) lastModified() time.Time {
+	var latest time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (c *certReloader) reload() error {
+	modTime := c.lastModified()
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.lastModified().After(c.modTime) {
+		// keep serving the previous certificate if the new one is incomplete or broken
+		if err := c.reload(); err != nil {
+			log.Printf("Could not reload certificate [%s]: %v\n", c.certFile, err)
+		} else {
+			log.Printf("Reloaded certificate [%s]\n", c.certFile)
+		}
+	}
+	return c.cert, nil
+}
+
+func generateSelfSigned(certFile, keyFile, hostname string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname, Organization: []string{"dashboard self-signed"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+	if len(hostname) > 0 && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in [%s]", file)
+	}
+	return pool, nil
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+// certAuth authenticates clients by their verified TLS client certificate.
+type certAuth struct {
+	roles        *roles
+	subjectRoles map[string]Role
+}
+
+// setupCertAuth enables client certificate authentication if DASHBOARD_TLS_CLIENT_CA is set, which requires HTTPS.
+// DASHBOARD_TLS_CLIENT_ROLES maps certificate subjects to roles as semicolon separated "subject=role" entries,
+// e.g. "CN=ops,O=Example=operator;monitoring=viewer". An entry matches either the full subject or the common name,
+// certificates without a matching entry get their role from DASHBOARD_ROLES.
+func setupCertAuth(roles *roles) *certAuth {
+	if len(os.Getenv("DASHBOARD_TLS_CLIENT_CA")) == 0 {
+		return nil
+	}
+	if len(os.Getenv("DASHBOARD_TLS_CERT")) == 0 || len(os.Getenv("DASHBOARD_TLS_KEY")) == 0 {
+		// without HTTPS no client can ever present a certificate
+		log.Fatalf("DASHBOARD_TLS_CLIENT_CA requires HTTPS, set DASHBOARD_TLS_CERT and DASHBOARD_TLS_KEY")
+	}
+
+	c := &certAuth{roles: roles, subjectRoles: make(map[string]Role)}
+	for _, entry := range strings.Split(os.Getenv("DASHBOARD_TLS_CLIENT_ROLES"), ";") {
+		if len(trim(entry)) == 0 {
+			continue
+		}
+		// subjects contain "=" themselves, the role follows the last one
+		index := strings.LastIndex(entry, "=")
+		if index < 0 {
+			log.Fatalf("Invalid DASHBOARD_TLS_CLIENT_ROLES, expected subject=role: [%s]", entry)
+		}
+		role, err := parseRole(entry[index+1:])
+		if err != nil {
+			log.Fatalf("Invalid DASHBOARD_TLS_CLIENT_ROLES: %v", err)
+		}
+		c.subjectRoles[trim(entry[:index])] = role
+	}
+	return c
+}
+
+func (c *certAuth) Authenticate(req *http.Request) (*Identity, error) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+	cert := req.TLS.VerifiedChains[0][0]
+
+	name := cert.Subject.CommonName
+	if len(name) == 0 {
+		return nil, errors.New("client certificate has no common name")
+	}
+	role, ok := c.subjectRoles[cert.Subject.String()]
+	if !ok {
+		role, ok = c.subjectRoles[name]
+	}
+	if !ok {
+		role = c.roles.For(name)
+	}
+	return &Identity{Name: name, Method: "certificate", Role: role}, nil
+}
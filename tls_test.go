@@ -0,0 +1,195 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func Test_todoapp_tls_SelfSigned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dashboard-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("DASHBOARD_TLS_CERT", dir+"/cert.pem")
+	os.Setenv("DASHBOARD_TLS_KEY", dir+"/key.pem")
+	defer os.Unsetenv("DASHBOARD_TLS_CERT")
+	defer os.Unsetenv("DASHBOARD_TLS_KEY")
+
+	config, err := setupTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, fileExists(dir+"/cert.pem"), true)
+	Expect(t, fileExists(dir+"/key.pem"), true)
+
+	cert, err := config.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, leaf.Subject.CommonName, currentHostname)
+	Expect(t, leaf.DNSNames[0], "localhost")
+
+	// replacing the files on disk is picked up by the next handshake
+	if err := generateSelfSigned(dir+"/cert.pem", dir+"/key.pem", "renewed.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(dir+"/cert.pem", future, future)
+
+	cert, err = config.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, leaf.Subject.CommonName, "renewed.example.com")
+}
+
+func Test_todoapp_tls_Redirect(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://example.com:3000/api/cpu?format=json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpsRedirect("3443").ServeHTTP(response, req)
+	Expect(t, response.Code, http.StatusMovedPermanently)
+	Expect(t, response.Header().Get("Location"), "https://example.com:3443/api/cpu?format=json")
+
+	response = httptest.NewRecorder()
+	httpsRedirect("443").ServeHTTP(response, req)
+	Expect(t, response.Header().Get("Location"), "https://example.com/api/cpu?format=json")
+}
+
+func testCertificate(t *testing.T, subject pkix.Name, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func Test_todoapp_tls_ClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dashboard-mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey, caPEM, _ := testCertificate(t, pkix.Name{CommonName: "Test CA"}, nil, nil)
+	if err := ioutil.WriteFile(dir+"/ca.pem", caPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, clientPEM, clientKeyPEM := testCertificate(t, pkix.Name{CommonName: "ops", Organization: []string{"Example"}}, ca, caKey)
+	clientCert, err := tls.X509KeyPair(clientPEM, clientKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("DASHBOARD_TLS_CERT", dir+"/cert.pem")
+	os.Setenv("DASHBOARD_TLS_KEY", dir+"/key.pem")
+	os.Setenv("DASHBOARD_TLS_CLIENT_CA", dir+"/ca.pem")
+	os.Setenv("DASHBOARD_TLS_CLIENT_ROLES", "CN=ops,O=Example=operator;monitoring=viewer")
+	defer os.Unsetenv("DASHBOARD_TLS_CERT")
+	defer os.Unsetenv("DASHBOARD_TLS_KEY")
+	defer os.Unsetenv("DASHBOARD_TLS_CLIENT_CA")
+	defer os.Unsetenv("DASHBOARD_TLS_CLIENT_ROLES")
+
+	config, err := setupTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(setupMartini())
+	server.TLS = config
+	server.StartTLS()
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{clientCert},
+	}}}
+	resp, err := client.Get(server.URL + "/api/permissions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	Expect(t, resp.StatusCode, http.StatusOK)
+	Contain(t, string(body), `"Name": "ops"`)
+	Contain(t, string(body), `"Method": "certificate"`)
+	Contain(t, string(body), `"Role": "operator"`)
+
+	// without a client certificate the request is not authenticated
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err = client.Get(server.URL + "/api/permissions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	Expect(t, resp.StatusCode, http.StatusUnauthorized)
+}
+
+func Test_todoapp_tls_ClientCAWithoutTLS(t *testing.T) {
+	// log.Fatalf exits, the check runs in a child process of the test binary
+	if os.Getenv("DASHBOARD_TEST_CLIENT_CA") == "1" {
+		os.Setenv("DASHBOARD_TLS_CLIENT_CA", "/etc/ssl/ca.pem")
+		setupCertAuth(nil)
+		return
+	}
+	command := exec.Command(os.Args[0], "-test.run=Test_todoapp_tls_ClientCAWithoutTLS")
+	command.Env = append(os.Environ(), "DASHBOARD_TEST_CLIENT_CA=1")
+	output, err := command.CombinedOutput()
+	NotExpect(t, err, nil)
+	Contain(t, string(output), "DASHBOARD_TLS_CLIENT_CA requires HTTPS")
+}
@@ -0,0 +1,487 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	tuiPanels     = []string{"cpu", "mem", "disk", "processes", "network"}
+	tuiCollectors = []string{"cpu", "mem", "disk", "top", "network"}
+	tuiHelp       = "q quit  tab/1-5 panel  up/down/pgup/pgdn scroll  c/m/p/t/n sort  r reverse  +/- interval  space refresh"
+)
+
+// processSorts are the orders of the process panel, selected by their key.
+var processSorts = []struct {
+	Key  string
+	Name string
+	Less func(a, b *ProcessV2) bool
+}{
+	{"c", "cpu", func(a, b *ProcessV2) bool { return a.CpuPercent > b.CpuPercent }},
+	{"m", "mem", func(a, b *ProcessV2) bool { return a.RssBytes > b.RssBytes }},
+	{"p", "pid", func(a, b *ProcessV2) bool { return a.Pid < b.Pid }},
+	{"t", "time", func(a, b *ProcessV2) bool { return a.CpuSeconds > b.CpuSeconds }},
+	{"n", "command", func(a, b *ProcessV2) bool { return a.Command < b.Command }},
+}
+
+type tuiLine struct {
+	Text      string
+	Highlight bool
+}
+
+type tuiUpdate struct {
+	snapshot *Snapshot
+	err      error
+}
+
+// tui is the full-screen terminal view of a snapshot, refreshed periodically from the local host or a remote dashboard.
+type tui struct {
+	source   string
+	fetch    func() (*Snapshot, error)
+	interval time.Duration
+
+	snapshot *Snapshot
+	err      error
+	updated  time.Time
+	focus    int
+	scroll   map[string]int
+	sortBy   int
+	reverse  bool
+}
+
+func newTUI(source string, fetch func() (*Snapshot, error), interval time.Duration) *tui {
+	return &tui{
+		source:   source,
+		fetch:    fetch,
+		interval: interval,
+		focus:    3,
+		scroll:   make(map[string]int),
+	}
+}
+
+// tuiCommand implements "dashboard tui", which shows the local host or with -url a remote dashboard.
+func tuiCommand(args []string) int {
+	flags := flag.NewFlagSet("tui", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	url := flags.String("url", "", "show a remote dashboard, e.g. https://host:3443")
+	token := flags.String("token", os.Getenv("DASHBOARD_FLEET_TOKEN"), "API token of the remote dashboard")
+	insecure := flags.Bool("insecure", false, "do not verify the certificate of the remote dashboard")
+	interval := flags.Duration("interval", 2*time.Second, "refresh interval")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *interval < time.Second {
+		fmt.Fprintln(stderr, "the refresh interval must be at least 1s")
+		return 2
+	}
+
+	source, rd := "local", setupRedactor()
+	fetch := func() (*Snapshot, error) {
+		return collectSnapshot(rd, tuiCollectors), nil
+	}
+	if len(*url) > 0 {
+		if *insecure {
+			fleetHTTPClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+		}
+		source = strings.TrimSuffix(*url, "/")
+		f := &fleet{hosts: map[string]string{"remote": source}, token: *token}
+		fetch = func() (*Snapshot, error) {
+			return f.Current("remote")
+		}
+	}
+
+	if err := newTUI(source, fetch, *interval).run(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func (t *tui) run(in *os.File, out io.Writer) error {
+	restore, err := makeRaw(in)
+	if err != nil {
+		return fmt.Errorf("not a terminal: %v", err)
+	}
+	defer restore()
+
+	// alternate screen without cursor, restored on exit
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan []byte)
+	go func() {
+		defer close(keys)
+		for {
+			buf := make([]byte, 64)
+			n, err := in.Read(buf)
+			if err != nil {
+				return
+			}
+			keys <- buf[:n]
+		}
+	}()
+
+	resize := make(chan os.Signal, 1)
+	if len(resizeSignals) > 0 {
+		signal.Notify(resize, resizeSignals...)
+		defer signal.Stop(resize)
+	}
+
+	updates := make(chan tuiUpdate, 1)
+	fetching := false
+	refresh := func() {
+		if fetching {
+			return
+		}
+		fetching = true
+		go func() {
+			snapshot, err := t.fetch()
+			updates <- tuiUpdate{snapshot, err}
+		}()
+	}
+	refresh()
+	timer := time.NewTimer(t.interval)
+	defer timer.Stop()
+
+	for {
+		width, height, err := terminalSize(in)
+		if err != nil || width == 0 || height == 0 {
+			width, height = 80, 24
+		}
+		t.draw(out, width, height)
+
+		select {
+		case buf, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			for _, key := range parseKeys(buf) {
+				quit, now := t.handle(key)
+				if quit {
+					return nil
+				}
+				if now {
+					refresh()
+				}
+			}
+		case <-resize:
+		case update := <-updates:
+			fetching = false
+			t.err = update.err
+			if update.err == nil {
+				t.snapshot, t.updated = update.snapshot, time.Now()
+			}
+		case <-timer.C:
+			refresh()
+			timer.Reset(t.interval)
+		}
+	}
+}
+
+// parseKeys splits terminal input into key names, escape sequences of special keys are named like "up" or "pgdn".
+func parseKeys(buf []byte) (keys []string) {
+	sequences := []struct {
+		Sequence string
+		Key      string
+	}{
+		{"\x1b[A", "up"}, {"\x1b[B", "down"}, {"\x1b[C", "right"}, {"\x1b[D", "left"},
+		{"\x1b[5~", "pgup"}, {"\x1b[6~", "pgdn"}, {"\x1b[H", "home"}, {"\x1b[F", "end"},
+		{"\x1b[1~", "home"}, {"\x1b[4~", "end"}, {"\x1b[Z", "backtab"},
+		{"\x1bOA", "up"}, {"\x1bOB", "down"}, {"\x1bOH", "home"}, {"\x1bOF", "end"},
+	}
+next:
+	for len(buf) > 0 {
+		for _, s := range sequences {
+			if bytes.HasPrefix(buf, []byte(s.Sequence)) {
+				keys = append(keys, s.Key)
+				buf = buf[len(s.Sequence):]
+				continue next
+			}
+		}
+		switch buf[0] {
+		case '\t':
+			keys = append(keys, "tab")
+		case 0x1b:
+			keys = append(keys, "esc")
+		case 3: // ctrl-c, there are no signals in raw mode
+			keys = append(keys, "q")
+		case '\r', '\n':
+			keys = append(keys, "enter")
+		default:
+			keys = append(keys, string(buf[0]))
+		}
+		buf = buf[1:]
+	}
+	return keys
+}
+
+// handle applies a key, it returns whether to quit and whether to refresh right away.
+func (t *tui) handle(key string) (quit bool, refresh bool) {
+	panel := tuiPanels[t.focus]
+	switch key {
+	case "q", "esc":
+		return true, false
+	case "tab", "right":
+		t.focus = (t.focus + 1) % len(tuiPanels)
+	case "backtab", "left":
+		t.focus = (t.focus + len(tuiPanels) - 1) % len(tuiPanels)
+	case "1", "2", "3", "4", "5":
+		t.focus = int(key[0] - '1')
+	case "down", "j":
+		t.scroll[panel]++
+	case "up", "k":
+		t.scroll[panel]--
+	case "pgdn":
+		t.scroll[panel] += 10
+	case "pgup":
+		t.scroll[panel] -= 10
+	case "home":
+		t.scroll[panel] = 0
+	case "end":
+		t.scroll[panel] = 1 << 30
+	case "r":
+		t.reverse = !t.reverse
+	case "+":
+		t.interval += time.Second
+	case "-":
+		if t.interval > time.Second {
+			t.interval -= time.Second
+		}
+	case " ", "enter":
+		return false, true
+	default:
+		for i, s := range processSorts {
+			if s.Key == key {
+				t.sortBy, t.reverse = i, false
+			}
+		}
+	}
+	if t.scroll[panel] < 0 {
+		t.scroll[panel] = 0
+	}
+	return false, false
+}
+
+func (t *tui) draw(out io.Writer, width, height int) {
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[H")
+	for i, line := range t.render(width, height) {
+		if i > 0 {
+			buf.WriteString("\r\n")
+		}
+		text := truncate(line.Text, width)
+		if line.Highlight {
+			buf.WriteString("\x1b[7m" + text + strings.Repeat(" ", width-utf8.RuneCountInString(text)) + "\x1b[0m")
+		} else {
+			buf.WriteString(text + "\x1b[K")
+		}
+	}
+	buf.WriteString("\x1b[J")
+	out.Write(buf.Bytes())
+}
+
+// render lays out all panels for a terminal of the given size, the process panel gets the space left over.
+func (t *tui) render(width, height int) []tuiLine {
+	hostname := "..."
+	if t.snapshot != nil {
+		hostname = t.snapshot.Hostname
+	}
+	status := fmt.Sprintf(" dashboard - %s (%s)", hostname, t.source)
+	if !t.updated.IsZero() {
+		status += fmt.Sprintf("  updated %s, every %s", t.updated.Format("15:04:05"), t.interval)
+	}
+	if t.err != nil {
+		status += "  error: " + t.err.Error()
+	}
+	lines := []tuiLine{{status, true}}
+
+	panels := make(map[string][]string)
+	for _, panel := range tuiPanels {
+		panels[panel] = t.panel(panel, width)
+	}
+
+	// fixed panels show all their rows, disks and interfaces are capped unless focused
+	available := height - 2
+	size := make(map[string]int)
+	for _, panel := range tuiPanels {
+		rows := len(panels[panel]) + 1
+		if (panel == "disk" || panel == "network") && tuiPanels[t.focus] != panel && rows > 7 {
+			rows = 7
+		}
+		if panel != "processes" {
+			size[panel] = rows
+			available -= rows
+		}
+	}
+	size["processes"] = available
+
+	for i, panel := range tuiPanels {
+		if size[panel] < 1 {
+			continue
+		}
+		rows := panels[panel]
+		title := fmt.Sprintf("[%d] %s", i+1, panel)
+		if panel == "processes" && len(processSorts) > 0 {
+			title += fmt.Sprintf(" by %s", processSorts[t.sortBy].Name)
+			if t.reverse {
+				title += " reversed"
+			}
+		}
+
+		// the first row of tables is their header, it stays in place while scrolling
+		header, body := "", rows
+		if len(rows) > 0 && (panel == "disk" || panel == "processes" || panel == "network") {
+			header, body = rows[0], rows[1:]
+		}
+		visible := size[panel] - 1
+		if len(header) > 0 {
+			visible--
+		}
+		if visible < 0 {
+			visible = 0
+		}
+		if last := len(body) - visible; t.scroll[panel] > last {
+			t.scroll[panel] = last
+		}
+		if t.scroll[panel] < 0 {
+			t.scroll[panel] = 0
+		}
+		if len(body) > visible {
+			title += fmt.Sprintf(" %d-%d of %d", t.scroll[panel]+1, t.scroll[panel]+visible, len(body))
+		}
+
+		lines = append(lines, tuiLine{title, i == t.focus})
+		if len(header) > 0 && size[panel] > 1 {
+			lines = append(lines, tuiLine{header, false})
+		}
+		end := t.scroll[panel] + visible
+		if end > len(body) {
+			end = len(body)
+		}
+		for _, row := range body[t.scroll[panel]:end] {
+			lines = append(lines, tuiLine{row, false})
+		}
+	}
+
+	if len(lines) > height-1 {
+		lines = lines[:height-1]
+	}
+	for len(lines) < height-1 {
+		lines = append(lines, tuiLine{})
+	}
+	return append(lines, tuiLine{" " + tuiHelp, true})
+}
+
+// panel returns the rows of a panel, tables start with their header.
+func (t *tui) panel(name string, width int) (rows []string) {
+	s := t.snapshot
+	if s == nil {
+		return []string{"  loading..."}
+	}
+	method := name
+	if name == "processes" {
+		method = "top"
+	}
+	if err, ok := s.Errors[method]; ok {
+		return []string{"  " + err}
+	}
+
+	switch name {
+	case "cpu":
+		if s.CPU != nil {
+			rows = append(rows, fmt.Sprintf("  %d x %s   load %.2f %.2f %.2f   %d/%d processes",
+				s.CPU.Processors, s.CPU.ModelName, s.CPU.Load1, s.CPU.Load5, s.CPU.Load15, s.CPU.RunningProcesses, s.CPU.TotalProcesses))
+		}
+	case "mem":
+		if s.Memory != nil {
+			barWidth := width - 40
+			rows = append(rows, memoryRow("RAM", s.Memory.RAM.TotalBytes, s.Memory.RAM.TotalBytes-s.Memory.RAM.AvailableBytes, barWidth))
+			rows = append(rows, memoryRow("Swap", s.Memory.Swap.TotalBytes, s.Memory.Swap.UsedBytes, barWidth))
+		}
+	case "disk":
+		rows = append(rows, fmt.Sprintf("  %-24s %8s %8s %8s %5s  %s", "Filesystem", "Size", "Used", "Avail", "Use%", "Mounted on"))
+		for _, disk := range s.Disks {
+			rows = append(rows, fmt.Sprintf("  %-24s %8s %8s %8s %4d%%  %s",
+				disk.Filesystem, humanBytes(disk.SizeBytes), humanBytes(disk.UsedBytes), humanBytes(disk.AvailableBytes), disk.UsagePercentage, disk.MountedOn))
+		}
+	case "processes":
+		rows = append(rows, fmt.Sprintf("  %7s %-10s %5s %5s %8s %9s  %s", "PID", "USER", "CPU%", "MEM%", "RSS", "TIME", "COMMAND"))
+		processes := append([]*ProcessV2{}, s.Processes...)
+		less := processSorts[t.sortBy].Less
+		sort.SliceStable(processes, func(i, j int) bool {
+			if t.reverse {
+				return less(processes[j], processes[i])
+			}
+			return less(processes[i], processes[j])
+		})
+		for _, p := range processes {
+			rows = append(rows, fmt.Sprintf("  %7d %-10s %5.1f %5.1f %8s %9s  %s",
+				p.Pid, truncate(p.User, 10), p.CpuPercent, p.MemPercent, humanBytes(p.RssBytes), (time.Duration(p.CpuSeconds)*time.Second).String(), p.Command))
+		}
+	case "network":
+		rows = append(rows, fmt.Sprintf("  %-16s %-6s %s", "Interface", "Family", "Address"))
+		for _, i := range s.Interfaces {
+			rows = append(rows, fmt.Sprintf("  %-16s %-6s %s/%d", i.Name, i.Family, i.Address, i.PrefixLength))
+		}
+	}
+	return rows
+}
+
+func memoryRow(name string, total, used uint64, barWidth int) string {
+	percent := 0.0
+	if total > 0 {
+		percent = float64(used) / float64(total) * 100
+	}
+	return fmt.Sprintf("  %-5s %s %5.1f%%  %s / %s", name, bar(percent, barWidth), percent, humanBytes(used), humanBytes(total))
+}
+
+func bar(percent float64, width int) string {
+	if width < 10 {
+		width = 10
+	}
+	filled := int(percent / 100 * float64(width))
+	if filled > width {
+		filled = width
+	}
+	return "[" + strings.Repeat("|", filled) + strings.Repeat(" ", width-filled) + "]"
+}
+
+// humanBytes formats a byte count with a binary unit suffix like df -h, e.g. 1.5G.
+func humanBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	value, suffix := float64(n), ""
+	for _, s := range []string{"K", "M", "G", "T", "P", "E"} {
+		if value < unit {
+			break
+		}
+		value, suffix = value/unit, s
+	}
+	if value < 10 {
+		return fmt.Sprintf("%.1f%s", value, suffix)
+	}
+	return fmt.Sprintf("%.0f%s", value, suffix)
+}
+
+func truncate(text string, width int) string {
+	if width < 0 || utf8.RuneCountInString(text) <= width {
+		return text
+	}
+	return string([]rune(text)[:width])
+}
@@ -0,0 +1,124 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func testTUI() *tui {
+	s := &Snapshot{
+		Hostname: "test",
+		CPU:      &CPUV2{Processors: 4, ModelName: "Test CPU", Load1: 0.5},
+		Memory:   &MemoryV2{RAM: MemoryDataV2{TotalBytes: 4 << 30, AvailableBytes: 1 << 30}},
+		Disks:    []*DiskUsageV2{{Filesystem: "/dev/sda1", SizeBytes: 100 << 30, MountedOn: "/"}},
+		Errors:   map[string]string{"network": "ip not found"},
+	}
+	for i := 1; i <= 30; i++ {
+		s.Processes = append(s.Processes, &ProcessV2{Pid: i, User: "root", CpuPercent: float64(i % 7), RssBytes: uint64(i) << 20, Command: fmt.Sprintf("cmd%02d", i)})
+	}
+	t := newTUI("local", func() (*Snapshot, error) { return s, nil }, 2*time.Second)
+	t.snapshot, t.updated = s, time.Now()
+	return t
+}
+
+func texts(lines []tuiLine) (result []string) {
+	for _, line := range lines {
+		result = append(result, line.Text)
+	}
+	return result
+}
+
+func Test_todoapp_tui_parseKeys(t *testing.T) {
+	Expect(t, parseKeys([]byte("q")), []string{"q"})
+	Expect(t, parseKeys([]byte("\x1b[A\x1b[6~m\t\x1b[Z")), []string{"up", "pgdn", "m", "tab", "backtab"})
+	Expect(t, parseKeys([]byte{0x1b}), []string{"esc"})
+	Expect(t, parseKeys([]byte{3}), []string{"q"})
+}
+
+func Test_todoapp_tui_Render(t *testing.T) {
+	tui := testTUI()
+	lines := tui.render(100, 30)
+	Expect(t, len(lines), 30)
+	Contain(t, lines[0].Text, "dashboard - test (local)")
+	Expect(t, lines[0].Highlight, true)
+	Contain(t, lines[29].Text, "q quit")
+
+	all := texts(lines)
+	Contain(t, fmt.Sprint(all), "4 x Test CPU   load 0.50")
+	Contain(t, fmt.Sprint(all), "RAM")
+	Contain(t, fmt.Sprint(all), "3.0G / 4.0G")
+	Contain(t, fmt.Sprint(all), "/dev/sda1")
+	Contain(t, fmt.Sprint(all), "ip not found")
+
+	// the process panel is focused and fills the remaining space, sorted by cpu
+	var title int
+	for i, line := range lines {
+		if line.Highlight && i > 0 && i < 29 {
+			title = i
+		}
+	}
+	Contain(t, lines[title].Text, "[4] processes by cpu 1-")
+	Contain(t, lines[title+1].Text, "PID USER")
+	Contain(t, lines[title+2].Text, "cmd06")
+
+	// sort by memory, then scroll down
+	tui.handle("m")
+	tui.handle("pgdn")
+	lines = tui.render(100, 30)
+	Contain(t, lines[title].Text, "processes by mem 11-")
+	Contain(t, lines[title+2].Text, "cmd20")
+
+	tui.handle("r")
+	tui.handle("home")
+	lines = tui.render(100, 30)
+	Contain(t, lines[title].Text, "by mem reversed 1-")
+	Contain(t, lines[title+2].Text, "cmd01")
+
+	// lines are cut to the terminal width
+	var out bytes.Buffer
+	tui.draw(&out, 20, 10)
+	Contain(t, out.String(), "  4 x Test CPU   loa\x1b[K")
+	NotContain(t, out.String(), "load 0.50")
+}
+
+func Test_todoapp_tui_Handle(t *testing.T) {
+	tui := testTUI()
+	Expect(t, tuiPanels[tui.focus], "processes")
+	tui.handle("tab")
+	Expect(t, tuiPanels[tui.focus], "network")
+	tui.handle("tab")
+	Expect(t, tuiPanels[tui.focus], "cpu")
+	tui.handle("backtab")
+	tui.handle("3")
+	Expect(t, tuiPanels[tui.focus], "disk")
+
+	tui.handle("up")
+	Expect(t, tui.scroll["disk"], 0)
+
+	tui.handle("+")
+	Expect(t, tui.interval, 3*time.Second)
+	tui.handle("-")
+	tui.handle("-")
+	tui.handle("-")
+	Expect(t, tui.interval, time.Second)
+
+	quit, refresh := tui.handle(" ")
+	Expect(t, quit, false)
+	Expect(t, refresh, true)
+	quit, _ = tui.handle("q")
+	Expect(t, quit, true)
+}
+
+func Test_todoapp_humanBytes(t *testing.T) {
+	Expect(t, humanBytes(0), "0B")
+	Expect(t, humanBytes(1023), "1023B")
+	Expect(t, humanBytes(1536), "1.5K")
+	Expect(t, humanBytes(300<<20), "300M")
+	Expect(t, humanBytes(5<<40), "5.0T")
+}
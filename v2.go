@@ -0,0 +1,548 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, the unit of process times in /proc, which is 100 on every Linux platform.
+const clockTicks = 100
+
+var (
+	procDir    = "/proc"
+	passwdFile = "/etc/passwd"
+	sysDir     = "/sys"
+)
+
+var v2Endpoints = []apiEndpoint{
+	{"hostname", "hostname", Host{}, "Hostname of the machine"},
+	{"ip", "ip", []string{}, "IP addresses the hostname resolves to"},
+	{"addresses", "addresses", Addresses{}, "All interface addresses classified as loopback, link-local, private, ula or public with reverse DNS names, the primary address by default route and optionally the external address"},
+	{"cpu", "cpu", CPUV2{}, "CPU model, speed, load averages, process counts and boot time"},
+	{"cpu_usage", "cpustat", CPUStat{}, "Utilization by state overall and per core, context switches and interrupts per second"},
+	{"mem", "mem", MemoryV2{}, "RAM and swap usage in bytes, calculated from /proc/meminfo"},
+	{"cgroup", "cgroup", Cgroup{}, "cgroup v1 or v2 of the dashboard, usually its container: memory limit, usage and OOM kills, CPU quota, shares, usage and throttling, pids"},
+	{"disk", "disk", []*DiskUsageV2{}, "Filesystem usage in bytes"},
+	{"disk_io", "diskio", []*DiskIO{}, "Operations and bytes per second, latency, queue depth and utilization of block devices and their mountpoints"},
+	{"processes", "top", TopV2{}, "top header and processes with sizes in bytes and exact start times"},
+	{"cgroups", "cgroups", CgroupTree{}, "Tree of all cgroups with their tasks, CPU, memory and I/O usage and member processes, like systemd-cgtop"},
+	{"containers", "docker", DockerContainers{}, "Docker containers with state, restart count, port mappings and live CPU, memory, network and block I/O stats"},
+	{"images", "docker_images", []*DockerImage{}, "Docker images with tags, size and the number of containers using them"},
+	{"kubernetes", "kubernetes", Kubernetes{}, "Pod name, namespace, node, labels, annotations, resource requests and limits and service account from the downward API"},
+	{"pods", "kubernetes_pods", []*KubernetesPod{}, "Pods in the namespace of the dashboard from the in-cluster Kubernetes API, if enabled"},
+	{"routing", "routing", Routing{}, "Routing table, default gateways, ARP and NDP neighbours, DNS resolver configuration, /etc/hosts and nsswitch order"},
+	{"sockets", "sockets", Sockets{}, "Listening sockets and established connections with their owning process, and socket counts by state"},
+	{"logged_on", "logged_on", []*LoggedOnV2{}, "Logged on users with login timestamps and times in seconds"},
+	{"users", "passwd", []*UserV2{}, "Accounts from /etc/passwd including uid and gid"},
+	{"network", "network", []*IfV2{}, "Addresses of all network interfaces, split into address and prefix length"},
+	{"network_traffic", "netstat", []*InterfaceStat{}, "Throughput, errors and drops per second, link speed, MTU, MAC and state of all network interfaces"},
+	{"env", "env", []*Env{}, "Environment variables of the dashboard, secrets are redacted"},
+	{"headers", "headers", http.Header{}, "Headers of the request as received by the dashboard"},
+}
+
+func dataV2(method string) (data interface{}, err error) {
+	switch method {
+	case "hostname":
+		data, err = hostname()
+	case "ip":
+		data, err = ip(currentHostname)
+	case "addresses":
+		data, err = addresses(currentHostname)
+	case "cpu":
+		data, err = cpuV2()
+	case "cpustat":
+		data, err = cpuStat()
+	case "mem":
+		data, err = memV2()
+	case "cgroup":
+		data, err = cgroup()
+	case "cgroups":
+		data, err = cgroups()
+	case "docker":
+		data, err = dockerContainers()
+	case "docker_images":
+		data, err = dockerImages()
+	case "kubernetes":
+		data, err = kubernetes()
+	case "kubernetes_pods":
+		data, err = kubernetesPods()
+	case "disk":
+		data, err = dfV2()
+	case "diskio":
+		data, err = diskIO()
+	case "top":
+		data, err = topV2()
+	case "sockets":
+		data, err = sockets()
+	case "logged_on":
+		data, err = wV2()
+	case "passwd":
+		data, err = passwdV2()
+	case "network":
+		data, err = networkV2()
+	case "netstat":
+		data, err = netStat()
+	case "routing":
+		data, err = routing()
+	case "env":
+		data = env()
+	default:
+		data, err = nil, errUnknownMethod
+	}
+	return
+}
+
+type CPUV2 struct {
+	Processors       int
+	ModelName        string
+	SpeedMHz         float64
+	Load1            float64
+	Load5            float64
+	Load15           float64
+	RunningProcesses int
+	TotalProcesses   int
+	BootTime         time.Time
+}
+
+func cpuV2() (*CPUV2, error) {
+	c, err := cpu()
+	if err != nil {
+		return nil, err
+	}
+
+	// /proc/loadavg reports "running/total" scheduling entities
+	processes := strings.SplitN(c.Processes, "/", 2)
+	if len(processes) != 2 {
+		return nil, fmt.Errorf("malformed process count [%s]", c.Processes)
+	}
+	running, err := strconv.Atoi(processes[0])
+	if err != nil {
+		return nil, err
+	}
+	total, err := strconv.Atoi(processes[1])
+	if err != nil {
+		return nil, err
+	}
+
+	boot, err := bootTime()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CPUV2{
+		Processors:       c.Processors,
+		ModelName:        c.ModelName,
+		SpeedMHz:         c.Speed,
+		Load1:            c.Load1,
+		Load5:            c.Load5,
+		Load15:           c.Load15,
+		RunningProcesses: running,
+		TotalProcesses:   total,
+		BootTime:         boot,
+	}, nil
+}
+
+func bootTime() (time.Time, error) {
+	content, err := ioutil.ReadFile(procDir + "/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseBootTime(string(content))
+}
+
+func parseBootTime(content string) (time.Time, error) {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "btime" {
+			seconds, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	return time.Time{}, errors.New("no btime found in /proc/stat")
+}
+
+type MemoryDataV2 struct {
+	TotalBytes     uint64
+	UsedBytes      uint64
+	FreeBytes      uint64
+	AvailableBytes uint64
+}
+
+type MemoryV2 struct {
+	RAM   MemoryDataV2
+	Swap  MemoryDataV2
+	Total MemoryDataV2
+}
+
+func memV2() (*MemoryV2, error) {
+	content, err := ioutil.ReadFile(procDir + "/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	return parseMeminfo(string(content))
+}
+
+// parseMeminfo calculates memory usage the way free(1) does, buffers and page cache don't count as used.
+func parseMeminfo(content string) (*MemoryV2, error) {
+	values := make(map[string]uint64)
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed meminfo line [%s]", line)
+		}
+		if len(fields) == 3 && fields[2] == "kB" {
+			value *= 1024
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = value
+	}
+	if _, ok := values["MemTotal"]; !ok {
+		return nil, errors.New("no MemTotal found in /proc/meminfo")
+	}
+
+	available, ok := values["MemAvailable"]
+	if !ok { // kernels before 3.14
+		available = values["MemFree"] + values["Buffers"] + values["Cached"]
+	}
+	ram := MemoryDataV2{
+		TotalBytes:     values["MemTotal"],
+		FreeBytes:      values["MemFree"],
+		AvailableBytes: available,
+	}
+	if reclaimable := values["MemFree"] + values["Buffers"] + values["Cached"] + values["SReclaimable"]; reclaimable < ram.TotalBytes {
+		ram.UsedBytes = ram.TotalBytes - reclaimable
+	}
+	swap := MemoryDataV2{
+		TotalBytes:     values["SwapTotal"],
+		FreeBytes:      values["SwapFree"],
+		AvailableBytes: values["SwapFree"],
+	}
+	if swap.FreeBytes < swap.TotalBytes {
+		swap.UsedBytes = swap.TotalBytes - swap.FreeBytes
+	}
+
+	return &MemoryV2{
+		RAM:  ram,
+		Swap: swap,
+		Total: MemoryDataV2{
+			TotalBytes:     ram.TotalBytes + swap.TotalBytes,
+			UsedBytes:      ram.UsedBytes + swap.UsedBytes,
+			FreeBytes:      ram.FreeBytes + swap.FreeBytes,
+			AvailableBytes: ram.AvailableBytes + swap.AvailableBytes,
+		},
+	}, nil
+}
+
+type DiskUsageV2 struct {
+	Filesystem      string
+	SizeBytes       uint64
+	UsedBytes       uint64
+	AvailableBytes  uint64
+	UsagePercentage int
+	MountedOn       string
+}
+
+func dfV2() (diskUsage []*DiskUsageV2, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New(fmt.Sprintf("%v", r))
+		}
+	}()
+
+	rows, err := dfRows("-PB1")
+	for _, values := range rows {
+		var sizes [3]uint64
+		for i := range sizes {
+			size, err := strconv.ParseUint(trim(values[i+1]), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			sizes[i] = size
+		}
+		percentage, err := strconv.Atoi(strings.Trim(trim(values[4]), "%"))
+		if err != nil {
+			return nil, err
+		}
+
+		diskUsage = append(diskUsage,
+			&DiskUsageV2{
+				Filesystem:      values[0],
+				SizeBytes:       sizes[0],
+				UsedBytes:       sizes[1],
+				AvailableBytes:  sizes[2],
+				UsagePercentage: percentage,
+				MountedOn:       values[5],
+			})
+	}
+
+	return diskUsage, err
+}
+
+type TopV2 struct {
+	Header    []string
+	Processes []*ProcessV2
+}
+
+type ProcessV2 struct {
+	User       string
+	Pid        int
+	CpuPercent float64
+	MemPercent float64
+	VszBytes   uint64
+	RssBytes   uint64
+	Tty        string
+	Stat       string
+	Start      *time.Time `json:",omitempty"`
+	CpuSeconds int64
+	Command    string
+}
+
+func topV2() (*TopV2, error) {
+	t, err := top()
+	if err != nil {
+		return nil, err
+	}
+	boot, err := bootTime()
+	if err != nil {
+		return nil, err
+	}
+
+	data := &TopV2{Header: t.Header}
+	for _, process := range t.Processes {
+		cpuSeconds, err := parseCPUTime(process.Time)
+		if err != nil {
+			return nil, err
+		}
+		data.Processes = append(data.Processes,
+			&ProcessV2{
+				User:       process.User,
+				Pid:        int(process.Pid),
+				CpuPercent: process.Cpu,
+				MemPercent: process.Mem,
+				VszBytes:   uint64(process.Vsz) * 1024,
+				RssBytes:   uint64(process.Rss) * 1024,
+				Tty:        process.Tty,
+				Stat:       process.Stat,
+				Start:      processStart(int(process.Pid), boot),
+				CpuSeconds: cpuSeconds,
+				Command:    process.Command,
+			})
+	}
+	return data, nil
+}
+
+// processStart returns the exact start time of a process, ps only prints it rounded to minutes or days.
+func processStart(pid int, boot time.Time) *time.Time {
+	content, err := ioutil.ReadFile(fmt.Sprintf("%s/%d/stat", procDir, pid))
+	if err != nil { // the process has exited in the meantime
+		return nil
+	}
+	ticks, err := parseStartTicks(string(content))
+	if err != nil {
+		return nil
+	}
+	start := boot.Add(time.Duration(ticks) * time.Second / clockTicks)
+	return &start
+}
+
+// parseStartTicks returns field 22 of /proc/<pid>/stat, the command name in field 2 may contain spaces and parentheses.
+func parseStartTicks(content string) (int64, error) {
+	index := strings.LastIndex(content, ")")
+	if index < 0 {
+		return 0, errors.New("malformed process stat")
+	}
+	fields := strings.Fields(content[index+1:])
+	if len(fields) < 20 {
+		return 0, errors.New("malformed process stat")
+	}
+	return strconv.ParseInt(fields[19], 10, 64)
+}
+
+// parseCPUTime parses the cumulative CPU time of ps, formatted as "MMM:SS" or "[DD-]HH:MM:SS".
+func parseCPUTime(value string) (int64, error) {
+	var days int64
+	if index := strings.Index(value, "-"); index >= 0 {
+		d, err := strconv.ParseInt(value[:index], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		days, value = d, value[index+1:]
+	}
+
+	var seconds int64
+	for _, part := range strings.Split(value, ":") {
+		number, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("malformed cpu time [%s]", value)
+		}
+		seconds = seconds*60 + number
+	}
+	return days*24*60*60 + seconds, nil
+}
+
+type LoggedOnV2 struct {
+	User        string
+	TTY         string
+	From        string
+	Login       *time.Time `json:",omitempty"`
+	IdleSeconds int64
+	JCPUSeconds int64
+	PCPUSeconds int64
+	What        string
+}
+
+func wV2() (loggedOn []*LoggedOnV2, err error) {
+	users, err := w()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, user := range users {
+		loggedOn = append(loggedOn,
+			&LoggedOnV2{
+				User:        user.User,
+				TTY:         user.TTY,
+				From:        user.From,
+				Login:       parseLoginTime(user.Login, now),
+				IdleSeconds: parseInterval(user.Idle),
+				JCPUSeconds: parseInterval(user.JCPU),
+				PCPUSeconds: parseInterval(user.PCPU),
+				What:        user.What,
+			})
+	}
+	return loggedOn, nil
+}
+
+// parseLoginTime resolves the login column of w, which is "HH:MM" for today,
+// "DddHH" within the last week and "DDMonYY" for anything older.
+func parseLoginTime(value string, now time.Time) *time.Time {
+	if t, err := time.ParseInLocation("15:04", value, now.Location()); err == nil {
+		login := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		if login.After(now) {
+			login = login.AddDate(0, 0, -1)
+		}
+		return &login
+	}
+	if len(value) == 5 {
+		if hour, err := strconv.Atoi(value[3:]); err == nil && hour < 24 {
+			for day := 0; day < 8; day++ {
+				login := time.Date(now.Year(), now.Month(), now.Day()-day, hour, 0, 0, 0, now.Location())
+				if login.Format("Mon") == value[:3] && login.Before(now) {
+					return &login
+				}
+			}
+		}
+	}
+	if t, err := time.ParseInLocation("02Jan06", value, now.Location()); err == nil {
+		return &t
+	}
+	return nil
+}
+
+// parseInterval converts the time columns of w to seconds, they are formatted as
+// "Ndays", "HH:MMm", "MM:SS" or "SS.CCs" depending on their length.
+func parseInterval(value string) int64 {
+	switch {
+	case strings.HasSuffix(value, "days"):
+		days, _ := strconv.ParseInt(strings.TrimSuffix(value, "days"), 10, 64)
+		return days * 24 * 60 * 60
+	case strings.HasSuffix(value, "m"):
+		minutes, _ := parseCPUTime(strings.TrimSuffix(value, "m"))
+		return minutes * 60
+	case strings.HasSuffix(value, "s"):
+		seconds, _ := strconv.ParseFloat(strings.TrimSuffix(value, "s"), 64)
+		return int64(seconds)
+	}
+	seconds, _ := parseCPUTime(value)
+	return seconds
+}
+
+type UserV2 struct {
+	Type        string
+	Name        string
+	Uid         int
+	Gid         int
+	Description string
+	Home        string
+	Shell       string
+}
+
+func passwdV2() (users []*UserV2, err error) {
+	err = readLines(passwdFile, func(line string) error {
+		values := strings.Split(line, ":")
+		if len(values) != 7 {
+			return errors.New("malformed passwd entry")
+		}
+		uid, err := strconv.Atoi(values[2])
+		if err != nil {
+			return err
+		}
+		gid, err := strconv.Atoi(values[3])
+		if err != nil {
+			return err
+		}
+
+		user := &UserV2{
+			Type:        "user",
+			Name:        values[0],
+			Uid:         uid,
+			Gid:         gid,
+			Description: values[4],
+			Home:        values[5],
+			Shell:       values[6],
+		}
+		if uid <= 499 {
+			user.Type = "system"
+		}
+		users = append(users, user)
+		return nil
+	})
+	return users, err
+}
+
+type IfV2 struct {
+	Name         string
+	Family       string
+	Address      string
+	PrefixLength int
+}
+
+func networkV2() (result []*IfV2, err error) {
+	interfaces, err := network()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range interfaces {
+		address, prefix, err := net.ParseCIDR(i.Value)
+		if err != nil {
+			return nil, err
+		}
+		length, _ := prefix.Mask.Size()
+		result = append(result,
+			&IfV2{
+				Name:         i.Name,
+				Family:       i.Type,
+				Address:      address.String(),
+				PrefixLength: length,
+			})
+	}
+	return result, nil
+}
@@ -0,0 +1,346 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+* License, v. 2.0. If a copy of the MPL was not distributed with this
+* file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func getV2(t *testing.T, path string, data interface{}) *httptest.ResponseRecorder {
+	m := setupMartini()
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:4005"+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.ServeHTTP(response, req)
+	if data != nil && response.Code == http.StatusOK {
+		if err := json.Unmarshal(response.Body.Bytes(), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return response
+}
+
+func Test_todoapp_api_v2_GetCPU(t *testing.T) {
+	var data CPUV2
+	response := getV2(t, "/api/v2/cpu", &data)
+	Expect(t, response.Code, http.StatusOK)
+
+	NotExpect(t, data.Processors, 0)
+	NotExpect(t, data.TotalProcesses, 0)
+	Expect(t, data.BootTime.Before(time.Now()), true)
+	Contain(t, response.Body.String(), `"BootTime": "`+data.BootTime.Format(time.RFC3339))
+}
+
+func Test_todoapp_api_v2_GetMemory(t *testing.T) {
+	var data MemoryV2
+	response := getV2(t, "/api/v2/mem", &data)
+	Expect(t, response.Code, http.StatusOK)
+
+	NotExpect(t, data.RAM.TotalBytes, uint64(0))
+	Expect(t, data.RAM.TotalBytes%1024, uint64(0))
+	Expect(t, data.Total.TotalBytes, data.RAM.TotalBytes+data.Swap.TotalBytes)
+	NotContain(t, response.Body.String(), `"TotalH"`)
+}
+
+func Test_todoapp_api_v2_GetDisk(t *testing.T) {
+	var data []*DiskUsageV2
+	response := getV2(t, "/api/v2/disk", &data)
+	Expect(t, response.Code, http.StatusOK)
+
+	if len(data) == 0 {
+		t.Fatal("no filesystems found")
+	}
+	for _, disk := range data {
+		if disk.UsedBytes > disk.SizeBytes {
+			t.Errorf("Expected used [%d] of [%s] to be at most its size [%d]", disk.UsedBytes, disk.MountedOn, disk.SizeBytes)
+		}
+	}
+}
+
+func Test_todoapp_api_v2_GetProcesses(t *testing.T) {
+	var data TopV2
+	response := getV2(t, "/api/v2/processes", &data)
+	Expect(t, response.Code, http.StatusOK)
+
+	if len(data.Processes) == 0 {
+		t.Fatal("no processes found")
+	}
+	for _, process := range data.Processes {
+		NotExpect(t, process.Pid, 0)
+		NotExpect(t, process.RssBytes, uint64(0))
+	}
+}
+
+func Test_todoapp_api_v2_GetUsers(t *testing.T) {
+	file, err := ioutil.TempFile("", "passwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("root:x:0:0:root:/root:/bin/bash\n# comment\nalice:x:1000:100:Alice,,,:/home/alice:/bin/zsh\n")
+	file.Close()
+
+	original := passwdFile
+	defer func() {
+		passwdFile = original
+	}()
+	passwdFile = file.Name()
+
+	var data []*UserV2
+	response := getV2(t, "/api/v2/users", &data)
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, data, []*UserV2{
+		{Type: "system", Name: "root", Uid: 0, Gid: 0, Description: "root", Home: "/root", Shell: "/bin/bash"},
+		{Type: "user", Name: "alice", Uid: 1000, Gid: 100, Description: "Alice,,,", Home: "/home/alice", Shell: "/bin/zsh"},
+	})
+}
+
+func Test_todoapp_api_v2_Schemas(t *testing.T) {
+	var index map[string]string
+	response := getV2(t, "/api/v2/schemas", &index)
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, len(index), len(v2Endpoints))
+	Expect(t, index["processes"], "/api/v2/schemas/processes")
+
+	var schema struct {
+		Id         string `json:"$id"`
+		Type       string `json:"type"`
+		Required   []string
+		Properties map[string]struct {
+			Type  interface{} `json:"type"`
+			Items struct {
+				Type       string `json:"type"`
+				Required   []string
+				Properties map[string]map[string]interface{}
+			} `json:"items"`
+		} `json:"properties"`
+	}
+	response = getV2(t, "/api/v2/schemas/processes", &schema)
+	Expect(t, response.Code, http.StatusOK)
+	Expect(t, schema.Id, "/api/v2/schemas/processes")
+	Expect(t, schema.Type, "object")
+
+	Expect(t, schema.Properties["Processes"].Type, []interface{}{"array", "null"}) // nil slices are encoded as null
+	process := schema.Properties["Processes"].Items
+	Expect(t, process.Type, "object")
+	Expect(t, process.Properties["Pid"]["type"], "integer")
+	Expect(t, process.Properties["RssBytes"]["minimum"], float64(0))
+	Expect(t, process.Properties["Start"]["format"], "date-time")
+	for _, name := range process.Required {
+		NotExpect(t, name, "Start")
+	}
+
+	response = getV2(t, "/api/v2/schemas/unknown", nil)
+	Expect(t, response.Code, http.StatusNotFound)
+}
+
+// validateSchema checks a decoded JSON value against the subset of JSON Schema generated by schemaFor.
+func validateSchema(schema map[string]interface{}, value interface{}, at string) (errors []string) {
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		for _, alternative := range anyOf {
+			if len(validateSchema(alternative.(map[string]interface{}), value, at)) == 0 {
+				return nil
+			}
+		}
+		return []string{fmt.Sprintf("%s: %v matches none of anyOf", at, value)}
+	}
+	if kinds, ok := schema["type"]; ok {
+		var allowed []interface{}
+		if list, ok := kinds.([]interface{}); ok {
+			allowed = list
+		} else {
+			allowed = []interface{}{kinds}
+		}
+		matches := false
+		for _, kind := range allowed {
+			switch v := value.(type) {
+			case nil:
+				matches = matches || kind == "null"
+			case bool:
+				matches = matches || kind == "boolean"
+			case float64:
+				matches = matches || kind == "number" || (kind == "integer" && v == math.Trunc(v))
+			case string:
+				matches = matches || kind == "string"
+			case []interface{}:
+				matches = matches || kind == "array"
+			case map[string]interface{}:
+				matches = matches || kind == "object"
+			}
+		}
+		if !matches {
+			return []string{fmt.Sprintf("%s: %#v is not of type %v", at, value, kinds)}
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || allowed == value
+		}
+		if !found {
+			errors = append(errors, fmt.Sprintf("%s: %v is not one of %v", at, value, enum))
+		}
+	}
+	if minimum, ok := schema["minimum"].(float64); ok {
+		if v, ok := value.(float64); ok && v < minimum {
+			errors = append(errors, fmt.Sprintf("%s: %v is less than %v", at, v, minimum))
+		}
+	}
+
+	switch v := value.(type) {
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				errors = append(errors, validateSchema(items, item, fmt.Sprintf("%s[%d]", at, i))...)
+			}
+		}
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := v[name.(string)]; !ok {
+					errors = append(errors, fmt.Sprintf("%s: %s is required", at, name))
+				}
+			}
+		}
+		for name, property := range v {
+			if definition, ok := properties[name].(map[string]interface{}); ok {
+				errors = append(errors, validateSchema(definition, property, at+"."+name)...)
+			} else if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				errors = append(errors, validateSchema(additional, property, at+"."+name)...)
+			} else if schema["additionalProperties"] == false {
+				errors = append(errors, fmt.Sprintf("%s: %s is not allowed", at, name))
+			}
+		}
+	}
+	return errors
+}
+
+// asJSON round trips a value through encoding/json, like a client decodes it.
+func asJSON(t *testing.T, value interface{}) (decoded map[string]interface{}) {
+	content, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v interface{}
+	if err := json.Unmarshal(content, &v); err != nil {
+		t.Fatal(err)
+	}
+	decoded, _ = v.(map[string]interface{})
+	return decoded
+}
+
+func Test_todoapp_api_v2_PayloadsMatchSchemas(t *testing.T) {
+	for _, endpoint := range v2Endpoints {
+		var schema map[string]interface{}
+		response := getV2(t, "/api/v2/schemas/"+endpoint.Path, &schema)
+		Expect(t, response.Code, http.StatusOK)
+
+		var payload interface{}
+		response = getV2(t, "/api/v2/"+endpoint.Path, &payload)
+		if response.Code != http.StatusOK { // collectors that are not available here are covered by their zero values
+			t.Logf("skipping /api/v2/%s, status %d", endpoint.Path, response.Code)
+			continue
+		}
+		for _, err := range validateSchema(schema, payload, endpoint.Path) {
+			t.Error(err)
+		}
+	}
+}
+
+// zero values have nil pointers, slices and maps, which are encoded as null
+func Test_todoapp_api_ZeroPayloadsMatchSchemas(t *testing.T) {
+	for path, doc := range apiDocs() {
+		payload := reflect.TypeOf(doc.Payload)
+		if payload.Kind() != reflect.Struct {
+			continue
+		}
+		schema := asJSON(t, schemaFor(payload, nil))
+		for _, err := range validateSchema(schema, asJSON(t, reflect.New(payload).Interface()), path) {
+			t.Error(err)
+		}
+	}
+}
+
+func Test_todoapp_v2_ParseMeminfo(t *testing.T) {
+	memory, err := parseMeminfo(`MemTotal:        1000 kB
+MemFree:          200 kB
+MemAvailable:     600 kB
+Buffers:          100 kB
+Cached:           200 kB
+SReclaimable:      50 kB
+SwapTotal:        400 kB
+SwapFree:         300 kB
+HugePages_Total:    0
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, memory.RAM, MemoryDataV2{TotalBytes: 1024000, UsedBytes: 460800, FreeBytes: 204800, AvailableBytes: 614400})
+	Expect(t, memory.Swap, MemoryDataV2{TotalBytes: 409600, UsedBytes: 102400, FreeBytes: 307200, AvailableBytes: 307200})
+	Expect(t, memory.Total.UsedBytes, uint64(563200))
+
+	_, err = parseMeminfo("SwapTotal: 0 kB\n")
+	NotExpect(t, err, nil)
+}
+
+func Test_todoapp_v2_ParseProcessTimes(t *testing.T) {
+	ticks, err := parseStartTicks("42 (tmux: server) S 1 42 42 0 -1 4194560 1 0 0 0 3 1 0 0 20 0 1 0 12345 1000 100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, ticks, int64(12345))
+
+	for value, expected := range map[string]int64{
+		"0:02":       2,
+		"125:07":     7507,
+		"01:02:03":   3723,
+		"2-01:00:00": 176400,
+	} {
+		seconds, err := parseCPUTime(value)
+		Expect(t, err, nil)
+		Expect(t, seconds, expected)
+	}
+
+	for value, expected := range map[string]int64{
+		"3days":  259200,
+		"1:02m":  3720,
+		"12.34s": 12,
+		"5:03":   303,
+		"":       0,
+	} {
+		Expect(t, parseInterval(value), expected)
+	}
+
+	boot, err := parseBootTime("cpu  1 2 3 4\nbtime 1700000000\nprocesses 42\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Expect(t, boot.Unix(), int64(1700000000))
+}
+
+func Test_todoapp_v2_ParseLoginTime(t *testing.T) {
+	now := time.Date(2024, time.October, 16, 10, 30, 0, 0, time.UTC) // a Wednesday
+
+	Expect(t, *parseLoginTime("09:15", now), time.Date(2024, time.October, 16, 9, 15, 0, 0, time.UTC))
+	Expect(t, *parseLoginTime("23:45", now), time.Date(2024, time.October, 15, 23, 45, 0, 0, time.UTC))
+	Expect(t, *parseLoginTime("Mon08", now), time.Date(2024, time.October, 14, 8, 0, 0, 0, time.UTC))
+	Expect(t, *parseLoginTime("Wed22", now), time.Date(2024, time.October, 9, 22, 0, 0, 0, time.UTC))
+	Expect(t, *parseLoginTime("03Sep24", now), time.Date(2024, time.September, 3, 0, 0, 0, 0, time.UTC))
+	Expect(t, parseLoginTime("?", now) == nil, true)
+}